@@ -1,29 +1,71 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/andlabs/ui"
 
 	"github.com/nulldozer/printer-calibration-utility/printer"
+	"github.com/nulldozer/printer-calibration-utility/profiles"
 )
 
+// levelingChoices backs the strategy dropdown; the first entry follows the
+// detected firmware. Keys are the names profiles store.
+var levelingChoices = []struct {
+	key   string
+	label string
+	new   func() printer.LevelingStrategy
+}{
+	{"", "Automatic", nil},
+	{"ubl", "Unified Bed Leveling (UBL)", func() printer.LevelingStrategy { return printer.UBLStrategy{} }},
+	{"bilinear", "Bilinear ABL", func() printer.LevelingStrategy { return printer.BilinearStrategy{} }},
+	{"mbl", "Manual Mesh (MBL)", func() printer.LevelingStrategy { return &printer.ManualMeshStrategy{} }},
+}
+
 type bedLevelTab struct {
 	client        *printer.Client
+	window        *ui.Window
 	hint          *ui.Label
 	status        *ui.Label
+	strategyDrop  *ui.Combobox
+	detected      *ui.Label
 	runBtn        *ui.Button
+	cancelBtn     *ui.Button
 	validateBtn   *ui.Button
+	manualLabel   *ui.Label
+	manualBtns    []*ui.Button
+	nextBtn       *ui.Button
+	readMeshBtn   *ui.Button
+	meshStats     *ui.Label
+	meshView      *meshView
+	mesh          *printer.Mesh
+	slotSpin      *ui.Spinbox
+	slotBtns      []*ui.Button
+	fileBtns      []*ui.Button
 	routineActive bool
-	waitCh        chan struct{}
-	progressSeen  bool
-	savedSeen     bool
-	loadedSeen    bool
+	cancel        context.CancelFunc
+	nextCh        chan struct{}
+	hasProbe      bool
+	g26           printer.G26Params
+
+	m48Samples *ui.Spinbox
+	m48X       *ui.Entry
+	m48Y       *ui.Entry
+	m48Tol     *ui.Entry
+	m48Btn     *ui.Button
+	m48Result  *ui.Label
+	m48Hist    *histogram
+	m48Running bool
 }
 
-func newBedLevelTab(client *printer.Client) *bedLevelTab {
-	t := &bedLevelTab{client: client}
+func newBedLevelTab(client *printer.Client, window *ui.Window) *bedLevelTab {
+	t := &bedLevelTab{client: client, window: window, hasProbe: true, g26: printer.DefaultG26Params}
 	client.AddBedLevelListener(t.onBedLine)
 	return t
 }
@@ -40,6 +82,24 @@ func (t *bedLevelTab) Build() ui.Control {
 	groupBox := ui.NewVerticalBox()
 	groupBox.SetPadded(true)
 
+	strategyRow := ui.NewHorizontalBox()
+	strategyRow.SetPadded(true)
+	strategyRow.Append(ui.NewLabel("Strategy"), false)
+	t.strategyDrop = ui.NewCombobox()
+	for _, choice := range levelingChoices {
+		t.strategyDrop.Append(choice.label)
+	}
+	t.strategyDrop.SetSelected(0)
+	t.strategyDrop.OnSelected(func(*ui.Combobox) {
+		t.updateRunButton()
+	})
+	strategyRow.Append(t.strategyDrop, true)
+	t.detected = ui.NewLabel("")
+	strategyRow.Append(t.detected, false)
+	groupBox.Append(strategyRow, false)
+
+	runRow := ui.NewHorizontalBox()
+	runRow.SetPadded(true)
 	t.runBtn = ui.NewButton("Start Bed Leveling Routine")
 	t.runBtn.OnClicked(func(*ui.Button) {
 		if t.routineActive {
@@ -47,24 +107,374 @@ func (t *bedLevelTab) Build() ui.Control {
 		}
 		t.startRoutine()
 	})
-	groupBox.Append(t.runBtn, false)
+	runRow.Append(t.runBtn, true)
+	t.cancelBtn = ui.NewButton("Cancel")
+	t.cancelBtn.OnClicked(func(*ui.Button) {
+		if t.cancel != nil {
+			t.cancel()
+		}
+	})
+	t.cancelBtn.Disable()
+	runRow.Append(t.cancelBtn, false)
+	groupBox.Append(runRow, false)
 
 	t.status = ui.NewLabel("")
 	groupBox.Append(t.status, false)
 
 	t.validateBtn = ui.NewButton("Print Validation Pattern")
 	t.validateBtn.OnClicked(func(*ui.Button) {
-		_ = t.client.PrintValidationPattern()
+		_ = t.client.PrintValidationPattern(t.g26)
 	})
 	groupBox.Append(t.validateBtn, false)
 
 	group.SetChild(groupBox)
 	vbox.Append(group, false)
+	vbox.Append(t.buildManualGroup(), false)
+	vbox.Append(t.buildRepeatabilityGroup(), false)
+	vbox.Append(t.buildMeshGroup(), true)
 
+	t.enableManual(false)
 	t.OnConnectionChanged(false)
 	return vbox
 }
 
+func (t *bedLevelTab) buildManualGroup() ui.Control {
+	group := ui.NewGroup("Manual Mesh Point")
+	group.SetMargined(true)
+	box := ui.NewVerticalBox()
+	box.SetPadded(true)
+
+	t.manualLabel = ui.NewLabel("Only used by manual mesh leveling.")
+	box.Append(t.manualLabel, false)
+
+	row := ui.NewHorizontalBox()
+	row.SetPadded(true)
+	t.manualBtns = nil
+	for _, d := range []float64{0.1, 0.02, -0.02, -0.1} {
+		delta := d
+		btn := ui.NewButton(fmt.Sprintf("Z %+.2f", delta))
+		btn.OnClicked(func(*ui.Button) {
+			_ = t.client.JogZ(delta)
+		})
+		row.Append(btn, false)
+		t.manualBtns = append(t.manualBtns, btn)
+	}
+	t.nextBtn = ui.NewButton("Store Point and Continue")
+	t.nextBtn.OnClicked(func(*ui.Button) {
+		select {
+		case t.nextCh <- struct{}{}:
+		default:
+		}
+	})
+	row.Append(t.nextBtn, false)
+	box.Append(row, false)
+
+	group.SetChild(box)
+	return group
+}
+
+func (t *bedLevelTab) buildRepeatabilityGroup() ui.Control {
+	group := ui.NewGroup("Probe Repeatability (M48)")
+	group.SetMargined(true)
+	hbox := ui.NewHorizontalBox()
+	hbox.SetPadded(true)
+
+	grid := ui.NewGrid()
+	grid.SetPadded(true)
+	t.m48Samples = ui.NewSpinbox(4, 50)
+	t.m48Samples.SetValue(10)
+	t.m48X = ui.NewEntry()
+	t.m48X.SetText("110")
+	t.m48Y = ui.NewEntry()
+	t.m48Y.SetText("110")
+	t.m48Tol = ui.NewEntry()
+	t.m48Tol.SetText("0.01")
+	grid.Append(ui.NewLabel("Samples"), 0, 0, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.m48Samples, 1, 0, 2, 1, true, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(ui.NewLabel("X, Y (mm)"), 0, 1, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.m48X, 1, 1, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.m48Y, 2, 1, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(ui.NewLabel("Max std dev (mm)"), 0, 2, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.m48Tol, 1, 2, 2, 1, true, ui.AlignFill, false, ui.AlignFill)
+	t.m48Btn = ui.NewButton("Run Repeatability Test")
+	t.m48Btn.OnClicked(func(*ui.Button) {
+		t.runRepeatability()
+	})
+	grid.Append(t.m48Btn, 0, 3, 3, 1, false, ui.AlignFill, false, ui.AlignFill)
+	t.m48Result = ui.NewLabel("")
+	grid.Append(t.m48Result, 0, 4, 3, 1, false, ui.AlignFill, false, ui.AlignFill)
+	hbox.Append(grid, false)
+
+	t.m48Hist = newHistogram()
+	hbox.Append(t.m48Hist.area, true)
+
+	group.SetChild(hbox)
+	return group
+}
+
+func (t *bedLevelTab) runRepeatability() {
+	x, errX := parseEntry(t.m48X)
+	y, errY := parseEntry(t.m48Y)
+	tol, errTol := parseEntry(t.m48Tol)
+	if errX != nil || errY != nil || errTol != nil || tol <= 0 {
+		t.m48Result.SetText("Check the position and tolerance.")
+		return
+	}
+	samples := t.m48Samples.Value()
+	t.m48Running = true
+	t.updateRunButton()
+	t.m48Hist.Set(nil, 0, false)
+	t.m48Result.SetText("Homing and probing...")
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if _, err := t.client.SendAndWait(ctx, "G28"); err != nil {
+			ui.QueueMain(func() {
+				t.m48Running = false
+				t.updateRunButton()
+				t.m48Result.SetText("Homing failed: " + err.Error())
+			})
+			return
+		}
+		var got []float64
+		res, err := t.client.ProbeRepeatability(ctx, samples, x, y, func(n int, z float64) {
+			got = append(got, z)
+			shown := append([]float64(nil), got...)
+			ui.QueueMain(func() {
+				t.m48Result.SetText(fmt.Sprintf("Sample %d of %d: %.3f", n, samples, z))
+				t.m48Hist.Set(shown, z, false)
+			})
+		})
+		ui.QueueMain(func() {
+			t.m48Running = false
+			t.updateRunButton()
+			if err != nil {
+				t.m48Result.SetText("Test failed: " + err.Error())
+				return
+			}
+			verdict := "PASS"
+			if !res.Within(tol) {
+				verdict = fmt.Sprintf("FAIL: std dev above %.3f, do not trust this probe", tol)
+			}
+			t.m48Result.SetText(fmt.Sprintf("Mean %.4f   Std dev %.4f\nMin %.3f   Max %.3f   Range %.3f\n%s",
+				res.Mean, res.StdDev, res.Min, res.Max, res.Range, verdict))
+			t.m48Hist.Set(res.Samples, res.Mean, !res.Within(tol))
+		})
+	}()
+}
+
+func (t *bedLevelTab) buildMeshGroup() ui.Control {
+	group := ui.NewGroup("Mesh")
+	group.SetMargined(true)
+	box := ui.NewVerticalBox()
+	box.SetPadded(true)
+
+	row := ui.NewHorizontalBox()
+	row.SetPadded(true)
+	t.readMeshBtn = ui.NewButton("Read Mesh")
+	t.readMeshBtn.OnClicked(func(*ui.Button) {
+		system := t.selectedStrategy().System()
+		go t.readMesh(system)
+	})
+	row.Append(t.readMeshBtn, false)
+	t.meshStats = ui.NewLabel("")
+	row.Append(t.meshStats, true)
+	box.Append(row, false)
+
+	slotRow := ui.NewHorizontalBox()
+	slotRow.SetPadded(true)
+	slotRow.Append(ui.NewLabel("UBL Slot"), false)
+	t.slotSpin = ui.NewSpinbox(0, 31)
+	slotRow.Append(t.slotSpin, false)
+	saveSlot := ui.NewButton("Save to Slot")
+	saveSlot.OnClicked(func(*ui.Button) {
+		go t.saveSlot(t.slotSpin.Value())
+	})
+	loadSlot := ui.NewButton("Load Slot")
+	loadSlot.OnClicked(func(*ui.Button) {
+		go t.loadSlot(t.slotSpin.Value())
+	})
+	listSlots := ui.NewButton("List Slots")
+	listSlots.OnClicked(func(*ui.Button) {
+		go t.listSlots()
+	})
+	t.slotBtns = []*ui.Button{saveSlot, loadSlot, listSlots}
+	for _, btn := range t.slotBtns {
+		slotRow.Append(btn, false)
+	}
+	box.Append(slotRow, false)
+
+	fileRow := ui.NewHorizontalBox()
+	fileRow.SetPadded(true)
+	exportCSV := ui.NewButton("Export CSV...")
+	exportCSV.OnClicked(func(*ui.Button) {
+		t.exportMesh(".csv")
+	})
+	exportJSON := ui.NewButton("Export JSON...")
+	exportJSON.OnClicked(func(*ui.Button) {
+		t.exportMesh(".json")
+	})
+	importBtn := ui.NewButton("Import to Printer...")
+	importBtn.OnClicked(func(*ui.Button) {
+		t.importMesh()
+	})
+	fileRow.Append(exportCSV, false)
+	fileRow.Append(exportJSON, false)
+	fileRow.Append(importBtn, false)
+	box.Append(fileRow, false)
+	// Export only needs a mesh on screen; import needs a printer.
+	t.fileBtns = []*ui.Button{importBtn}
+
+	t.meshView = newMeshView()
+	box.Append(t.meshView.area, true)
+
+	group.SetChild(box)
+	return group
+}
+
+// readMesh fetches the mesh from the printer and shows it.
+func (t *bedLevelTab) readMesh(system printer.LevelingSystem) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	mesh, err := t.client.ReadMesh(ctx, system)
+	if err != nil {
+		ui.QueueMain(func() {
+			t.meshStats.SetText("Could not read mesh: " + err.Error())
+		})
+		return
+	}
+	t.showMesh(mesh)
+}
+
+func (t *bedLevelTab) showMesh(mesh *printer.Mesh) {
+	st := mesh.Stats()
+	ui.QueueMain(func() {
+		t.mesh = mesh
+		t.meshView.SetMesh(mesh)
+		t.meshStats.SetText(fmt.Sprintf("%dx%d points   min %+.3f   max %+.3f   range %.3f mm   mean %+.3f",
+			mesh.Cols(), mesh.Rows(), st.Min, st.Max, st.Range, st.Mean))
+	})
+}
+
+func (t *bedLevelTab) setMeshStatus(text string) {
+	ui.QueueMain(func() {
+		t.meshStats.SetText(text)
+	})
+}
+
+func (t *bedLevelTab) saveSlot(slot int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := t.client.SaveMeshSlot(ctx, slot); err != nil {
+		t.setMeshStatus("Save failed: " + err.Error())
+		return
+	}
+	t.setMeshStatus(fmt.Sprintf("Mesh saved in slot %d.", slot))
+}
+
+func (t *bedLevelTab) loadSlot(slot int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := t.client.LoadMeshSlot(ctx, slot); err != nil {
+		t.setMeshStatus("Load failed: " + err.Error())
+		return
+	}
+	t.readMesh(printer.LevelingUBL)
+}
+
+func (t *bedLevelTab) listSlots() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	slots, err := t.client.MeshSlots(ctx)
+	if err != nil {
+		t.setMeshStatus("Could not list slots: " + err.Error())
+		return
+	}
+	active := "none"
+	if slots.Active >= 0 {
+		active = fmt.Sprint(slots.Active)
+	}
+	t.setMeshStatus(fmt.Sprintf("EEPROM holds %d meshes (slots 0-%d); active slot: %s", slots.Count, slots.Count-1, active))
+}
+
+// exportMesh saves the mesh on screen; ext picks the format when the chosen
+// file name has no extension of its own.
+func (t *bedLevelTab) exportMesh(ext string) {
+	if t.mesh == nil {
+		t.meshStats.SetText("Read a mesh before exporting it.")
+		return
+	}
+	path := ui.SaveFile(t.window)
+	if path == "" {
+		return
+	}
+	if filepath.Ext(path) == "" {
+		path += ext
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.meshStats.SetText("Export failed: " + err.Error())
+		return
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = t.mesh.WriteJSON(f)
+	} else {
+		err = t.mesh.WriteCSV(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.meshStats.SetText("Export failed: " + err.Error())
+		return
+	}
+	t.meshStats.SetText("Mesh exported to " + path)
+}
+
+func (t *bedLevelTab) importMesh() {
+	path := ui.OpenFile(t.window)
+	if path == "" {
+		return
+	}
+	mesh, err := readMeshFile(path)
+	if err != nil {
+		t.meshStats.SetText("Import failed: " + err.Error())
+		return
+	}
+	system := t.selectedStrategy().System()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := t.client.WriteMesh(ctx, mesh, system); err != nil {
+			t.setMeshStatus("Import failed: " + err.Error())
+			return
+		}
+		t.readMesh(system)
+		if system == printer.LevelingUBL {
+			t.setMeshStatus("Mesh imported and active; save it to a slot to keep it.")
+			return
+		}
+		if err := t.client.SaveSettings(ctx); err != nil {
+			t.setMeshStatus("Mesh imported but not saved: " + err.Error())
+			return
+		}
+		t.setMeshStatus("Mesh imported, activated and saved.")
+	}()
+}
+
+func readMeshFile(path string) (*printer.Mesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return printer.ReadMeshJSON(f)
+	}
+	return printer.ReadMeshCSV(f)
+}
+
 func (t *bedLevelTab) setStatus(text string) {
 	ui.QueueMain(func() {
 		if t.status != nil {
@@ -73,45 +483,149 @@ func (t *bedLevelTab) setStatus(text string) {
 	})
 }
 
+func (t *bedLevelTab) selectedStrategy() printer.LevelingStrategy {
+	var strategy printer.LevelingStrategy
+	idx := t.strategyDrop.Selected()
+	if idx > 0 && idx < len(levelingChoices) {
+		strategy = levelingChoices[idx].new()
+	} else {
+		strategy = printer.StrategyFor(t.client.Capabilities())
+	}
+	if ubl, ok := strategy.(printer.UBLStrategy); ok {
+		ubl.Slot = t.slotSpin.Value()
+		strategy = ubl
+	}
+	return strategy
+}
+
+// ApplyProfile selects the profile's strategy, mesh slot and validation
+// pattern. Call on the UI thread.
+func (t *bedLevelTab) ApplyProfile(p profiles.Profile) {
+	for i, choice := range levelingChoices {
+		if choice.key == p.Leveling {
+			t.strategyDrop.SetSelected(i)
+		}
+	}
+	t.slotSpin.SetValue(p.MeshSlot)
+	t.g26 = p.G26
+	t.updateRunButton()
+}
+
+func (t *bedLevelTab) SaveProfile(p *profiles.Profile) {
+	if idx := t.strategyDrop.Selected(); idx >= 0 && idx < len(levelingChoices) {
+		p.Leveling = levelingChoices[idx].key
+	}
+	p.MeshSlot = t.slotSpin.Value()
+	p.G26 = t.g26
+}
+
 func (t *bedLevelTab) startRoutine() {
+	strategy := t.selectedStrategy()
+	if manual, ok := strategy.(*printer.ManualMeshStrategy); ok {
+		manual.Confirm = t.confirmManualPoint
+	} else if !t.hasProbe {
+		t.setStatus("Firmware reports no Z probe; use manual mesh leveling.")
+		return
+	}
+
 	t.routineActive = true
-	t.progressSeen = false
-	t.savedSeen = false
-	t.loadedSeen = false
-	t.waitCh = make(chan struct{}, 1)
+	t.nextCh = make(chan struct{}, 1)
 	// Clear any leftover buffered lines so we only react to fresh output.
 	t.client.ClearLineBuffer()
-	t.setStatus("Running bed leveling routine...")
-	if t.runBtn != nil {
-		t.runBtn.Disable()
+	t.setStatus(fmt.Sprintf("Running %s...", strategy.Name()))
+	t.runBtn.Disable()
+	t.strategyDrop.Disable()
+	t.m48Btn.Disable()
+	t.cancelBtn.Enable()
+
+	// Manual leveling waits on the user, so it only ends when cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	if strategy.System() != printer.LevelingManual {
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Minute)
 	}
+	t.cancel = cancel
 	go func() {
-		if err := t.client.RunBedLevelingRoutine(); err != nil {
+		defer cancel()
+		err := t.client.RunBedLevelingRoutine(ctx, strategy)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			t.finishRoutine("Bed leveling timed out.")
+		case errors.Is(err, context.Canceled):
+			t.finishRoutine("Bed leveling cancelled.")
+		case err != nil:
 			t.finishRoutine("Bed leveling failed: " + err.Error())
-			return
-		}
-		select {
-		case <-t.waitCh:
+		default:
 			t.finishRoutine("Mesh saved and bed leveling activated.")
-		case <-time.After(5 * time.Minute):
-			t.finishRoutine("Bed leveling timed out.")
+			t.readMesh(strategy.System())
 		}
 	}()
 }
 
+// confirmManualPoint lets the user jog Z at one mesh point and returns when
+// they store it.
+func (t *bedLevelTab) confirmManualPoint(ctx context.Context, point, total int) error {
+	ui.QueueMain(func() {
+		t.manualLabel.SetText(fmt.Sprintf("Point %d of %d: lower the nozzle until it just grips a sheet of paper.", point, total))
+	})
+	t.enableManual(true)
+	defer t.enableManual(false)
+	select {
+	case <-t.nextCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *bedLevelTab) enableManual(enable bool) {
+	ui.QueueMain(func() {
+		for _, btn := range append([]*ui.Button{t.nextBtn}, t.manualBtns...) {
+			if enable {
+				btn.Enable()
+			} else {
+				btn.Disable()
+			}
+		}
+		if !enable {
+			t.manualLabel.SetText("Only used by manual mesh leveling.")
+		}
+	})
+}
+
 func (t *bedLevelTab) finishRoutine(msg string) {
 	if !t.routineActive {
 		return
 	}
 	t.routineActive = false
+	t.cancel = nil
 	ui.QueueMain(func() {
-		if t.runBtn != nil {
-			t.runBtn.Enable()
-		}
+		t.strategyDrop.Enable()
+		t.cancelBtn.Disable()
+		t.updateRunButton()
 		t.setStatus(msg)
 	})
 }
 
+// updateRunButton disables probing strategies on printers without a probe.
+func (t *bedLevelTab) updateRunButton() {
+	if t.m48Btn != nil {
+		if t.hasProbe && !t.m48Running && !t.routineActive && t.client.IsConnected() {
+			t.m48Btn.Enable()
+		} else {
+			t.m48Btn.Disable()
+		}
+	}
+	if t.routineActive || !t.client.IsConnected() {
+		return
+	}
+	strategy := t.selectedStrategy()
+	if t.hasProbe || strategy.System() == printer.LevelingManual {
+		t.runBtn.Enable()
+	} else {
+		t.runBtn.Disable()
+	}
+}
+
 func (t *bedLevelTab) onBedLine(line string) {
 	if !t.routineActive {
 		return
@@ -129,29 +643,38 @@ func (t *bedLevelTab) onBedLine(line string) {
 		strings.Contains(line, "Mesh invalidated") ||
 		strings.Contains(line, "Mesh saved") ||
 		strings.Contains(line, "Mesh loaded") ||
+		strings.Contains(line, "Mesh probing done") ||
+		strings.HasPrefix(line, "Bed X:") ||
+		strings.Contains(line, "MBL G29 point") ||
 		strings.Contains(strings.ToLower(line), "leveling") {
 		t.setStatus(line)
 	}
+}
 
-	if strings.Contains(line, "Mesh invalidated") || strings.Contains(line, "Probing mesh point") {
-		t.progressSeen = true
-	}
-
-	if t.progressSeen && strings.Contains(line, "Mesh saved") {
-		t.savedSeen = true
-	}
-	if t.progressSeen && strings.Contains(line, "Mesh loaded from slot") {
-		t.loadedSeen = true
-	}
-	if t.savedSeen && (t.loadedSeen || strings.Contains(line, "Done.")) {
-		select {
-		case t.waitCh <- struct{}{}:
-		default:
+func (t *bedLevelTab) OnCapabilities(caps printer.Capabilities) {
+	t.hasProbe = caps.Supports(printer.CapZProbe)
+	detected := printer.StrategyFor(caps)
+	ui.QueueMain(func() {
+		t.detected.SetText("Detected: " + detected.Name())
+		t.updateRunButton()
+		if caps.Leveling != printer.LevelingUnknown && caps.Leveling != printer.LevelingUBL {
+			for _, btn := range t.slotBtns {
+				btn.Disable()
+			}
 		}
-	}
+		if !t.hasProbe && t.hint != nil {
+			t.hint.SetText("Firmware reports no Z probe; only manual mesh leveling is available.")
+		}
+	})
 }
 
 func (t *bedLevelTab) OnConnectionChanged(connected bool) {
+	if !connected {
+		t.hasProbe = true
+		if t.cancel != nil {
+			t.cancel()
+		}
+	}
 	ui.QueueMain(func() {
 		if t.hint != nil {
 			if connected {
@@ -160,7 +683,10 @@ func (t *bedLevelTab) OnConnectionChanged(connected bool) {
 				t.hint.SetText("Connect first to run bed leveling.")
 			}
 		}
-		for _, btn := range []*ui.Button{t.runBtn, t.validateBtn} {
+		buttons := []*ui.Button{t.runBtn, t.validateBtn, t.readMeshBtn}
+		buttons = append(buttons, t.slotBtns...)
+		buttons = append(buttons, t.fileBtns...)
+		for _, btn := range buttons {
 			if btn == nil {
 				continue
 			}
@@ -170,8 +696,16 @@ func (t *bedLevelTab) OnConnectionChanged(connected bool) {
 				btn.Disable()
 			}
 		}
-		if !connected && t.status != nil {
-			t.status.SetText("")
+		if t.m48Btn != nil && !connected {
+			t.m48Btn.Disable()
+		}
+		if !connected {
+			if t.status != nil {
+				t.status.SetText("")
+			}
+			if t.detected != nil {
+				t.detected.SetText("")
+			}
 		}
 	})
 }
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.bug.st/serial"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+)
+
+func runPorts(ctx context.Context, opts *options, args []string) error {
+	ports, err := serial.GetPortsList()
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		if ports == nil {
+			ports = []string{}
+		}
+		return printJSON(ports)
+	}
+	for _, p := range ports {
+		fmt.Println(p)
+	}
+	return nil
+}
+
+type sendResult struct {
+	Command string   `json:"command"`
+	Lines   []string `json:"lines"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func runSend(ctx context.Context, opts *options, args []string) error {
+	if len(args) == 0 {
+		return errors.New("nothing to send")
+	}
+	client, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	var results []sendResult
+	var failed error
+	for _, cmd := range args {
+		resp, err := client.SendAndWait(ctx, cmd)
+		res := sendResult{Command: cmd, Lines: []string{}}
+		if resp != nil {
+			res.Lines = append(res.Lines, resp.Lines...)
+		}
+		if err != nil {
+			res.Error = err.Error()
+			failed = fmt.Errorf("%s: %w", cmd, err)
+		}
+		results = append(results, res)
+		if !opts.json {
+			for _, line := range res.Lines {
+				fmt.Println(line)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if opts.json {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	}
+	return failed
+}
+
+func runMonitorTemp(ctx context.Context, opts *options, args []string) error {
+	flags := flag.NewFlagSet("monitor-temp", flag.ExitOnError)
+	duration := flags.Duration("duration", 0, "stop after this long; 0 runs until interrupted")
+	logPath := flags.String("log", "", "also append the reports to this CSV file")
+	flags.Parse(args)
+
+	client, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+	if !client.Capabilities().Supports(printer.CapAutoreportTemp) {
+		return errors.New("firmware has no temperature auto-report (M155)")
+	}
+	if *logPath != "" {
+		f, err := os.Create(*logPath)
+		if err != nil {
+			return err
+		}
+		log, err := printer.NewTempLog(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		client.SetTempLog(log)
+		defer log.Close()
+	}
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	// Reports arrive on the read loop; hand them to this goroutine so
+	// output is never interleaved.
+	reports := make(chan printer.TempReport, 16)
+	client.AddTempListener(func(r printer.TempReport) {
+		select {
+		case reports <- r:
+		default:
+		}
+	})
+	if err := client.StartTempMonitoring(); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		select {
+		case <-ctx.Done():
+			client.StopTempMonitoring()
+			return nil
+		case r := <-reports:
+			if opts.json {
+				if err := enc.Encode(r); err != nil {
+					return err
+				}
+				continue
+			}
+			var parts []string
+			for _, h := range r.Heaters {
+				part := fmt.Sprintf("%s %.1f", printer.HeaterName(h.ID), h.Current)
+				if h.HasTarget {
+					part += fmt.Sprintf("/%.1f", h.Target)
+				}
+				parts = append(parts, part)
+			}
+			fmt.Printf("%s  %s\n", r.Time.Format("15:04:05"), strings.Join(parts, "  "))
+		}
+	}
+}
+
+type levelJSON struct {
+	Strategy string `json:"strategy"`
+	System   string `json:"system"`
+	printer.MeshStats
+}
+
+func runLevel(ctx context.Context, opts *options, args []string) error {
+	flags := flag.NewFlagSet("level", flag.ExitOnError)
+	name := flags.String("strategy", "auto", "auto, ubl, bilinear or mbl")
+	slot := flags.Int("slot", 0, "UBL mesh slot to save to")
+	flags.Parse(args)
+
+	client, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	var strategy printer.LevelingStrategy
+	switch strings.ToLower(*name) {
+	case "auto":
+		strategy = printer.StrategyFor(client.Capabilities())
+	case "ubl":
+		strategy = printer.UBLStrategy{}
+	case "bilinear":
+		strategy = printer.BilinearStrategy{}
+	case "mbl":
+		strategy = &printer.ManualMeshStrategy{}
+	default:
+		return fmt.Errorf("unknown strategy %q", *name)
+	}
+	switch s := strategy.(type) {
+	case printer.UBLStrategy:
+		s.Slot = *slot
+		strategy = s
+	case *printer.ManualMeshStrategy:
+		s.Confirm = promptManualPoint(client)
+	}
+
+	fmt.Fprintf(os.Stderr, "Leveling with %s...\n", strategy.Name())
+	if err := client.RunBedLevelingRoutine(ctx, strategy); err != nil {
+		return err
+	}
+	mesh, err := client.ReadMesh(ctx, strategy.System())
+	if err != nil {
+		return fmt.Errorf("leveled, but reading the mesh failed: %w", err)
+	}
+	st := mesh.Stats()
+	if opts.json {
+		return printJSON(levelJSON{
+			Strategy:  strategy.Name(),
+			System:    string(strategy.System()),
+			MeshStats: st,
+		})
+	}
+	fmt.Printf("%s done: %d points, min %+.3f, max %+.3f, range %.3f mm\n",
+		strategy.Name(), st.Points, st.Min, st.Max, st.Range)
+	return nil
+}
+
+// promptManualPoint lets the user jog the nozzle onto each manual mesh
+// point from the terminal.
+func promptManualPoint(client *printer.Client) func(ctx context.Context, point, total int) error {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	return func(ctx context.Context, point, total int) error {
+		fmt.Fprintf(os.Stderr, "Point %d of %d: enter a Z jog in mm (e.g. -0.05) until the paper drags, then an empty line to store.\n", point, total)
+		for {
+			fmt.Fprint(os.Stderr, "> ")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case line, ok := <-lines:
+				if !ok {
+					return errors.New("input closed")
+				}
+				line = strings.TrimSpace(line)
+				if line == "" {
+					return nil
+				}
+				delta, err := strconv.ParseFloat(line, 64)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "not a number")
+					continue
+				}
+				if err := client.JogZ(delta); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+type zOffsetJSON struct {
+	ZOffset float64 `json:"z_offset"`
+	Saved   bool    `json:"saved"`
+}
+
+func runZOffset(ctx context.Context, opts *options, args []string) error {
+	if len(args) == 0 || args[0] != "set" {
+		return errors.New("usage: zoffset set [-save] <z>")
+	}
+	flags := flag.NewFlagSet("zoffset set", flag.ExitOnError)
+	save := flags.Bool("save", false, "store the offset in EEPROM with M500")
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		return errors.New("usage: zoffset set [-save] <z>")
+	}
+	z, err := strconv.ParseFloat(flags.Arg(0), 64)
+	if err != nil {
+		return fmt.Errorf("invalid Z offset %q", flags.Arg(0))
+	}
+
+	client, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+	if err := client.ApplyZOffset(ctx, z); err != nil {
+		return err
+	}
+	if *save {
+		if !client.Capabilities().Supports(printer.CapEEPROM) {
+			return errors.New("offset applied, but firmware has no EEPROM to save it")
+		}
+		if err := client.SaveSettings(ctx); err != nil {
+			return err
+		}
+	}
+	if opts.json {
+		return printJSON(zOffsetJSON{ZOffset: z, Saved: *save})
+	}
+	fmt.Printf("Z offset %.3f applied", z)
+	if *save {
+		fmt.Print(" and saved")
+	}
+	fmt.Println()
+	return nil
+}
+
+func runMesh(ctx context.Context, opts *options, args []string) error {
+	if len(args) == 0 || args[0] != "export" {
+		return errors.New("usage: mesh export [-format csv|json] [-o file]")
+	}
+	flags := flag.NewFlagSet("mesh export", flag.ExitOnError)
+	format := flags.String("format", "", "csv or json; defaults to json with -json, else csv")
+	out := flags.String("o", "", "write to this file instead of stdout")
+	flags.Parse(args[1:])
+	if *format == "" {
+		*format = "csv"
+		if opts.json {
+			*format = "json"
+		}
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	client, err := connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+	system := printer.StrategyFor(client.Capabilities()).System()
+	mesh, err := client.ReadMesh(ctx, system)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		return mesh.WriteJSON(w)
+	}
+	return mesh.WriteCSV(w)
+}
@@ -0,0 +1,233 @@
+// Command calibrate runs the calibration steps of the Printer Calibration
+// Utility from a shell, without the GUI.
+//
+// Usage:
+//
+//	calibrate [connection flags] <command> [arguments]
+//
+// Commands:
+//
+//	ports                     list serial ports
+//	send <gcode>...           send commands and print the replies
+//	monitor-temp              print temperature reports until interrupted
+//	level                     run a bed leveling routine
+//	zoffset set <z>           set the probe Z offset
+//	mesh export               print the active bed mesh as CSV or JSON
+//	serve                     run the HTTP/WebSocket API for dashboards
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+	"github.com/nulldozer/printer-calibration-utility/profiles"
+	"github.com/nulldozer/printer-calibration-utility/simulator"
+)
+
+// options are the flags shared by every command.
+type options struct {
+	port      string
+	baud      int
+	addr      string
+	sim       string
+	checksums bool
+	profile   string
+	window    int
+	json      bool
+	timeout   time.Duration
+	verbose   bool
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, opts *options, args []string) error
+	// untimed commands run until interrupted and ignore -timeout.
+	untimed bool
+}
+
+var commands = []command{
+	{"ports", "ports", runPorts, false},
+	{"send", "send <gcode>...", runSend, false},
+	{"monitor-temp", "monitor-temp [-duration d] [-log file.csv]", runMonitorTemp, true},
+	{"level", "level [-strategy auto|ubl|bilinear|mbl] [-slot n]", runLevel, false},
+	{"zoffset", "zoffset set [-save] <z>", runZOffset, false},
+	{"mesh", "mesh export [-format csv|json] [-o file]", runMesh, false},
+	{"serve", "serve [-listen addr] [-allow-origin origins] [-allow-host hosts]", runServe, true},
+}
+
+func main() {
+	opts := &options{}
+	flags := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	flags.StringVar(&opts.port, "port", "", "serial port, e.g. /dev/ttyUSB0")
+	flags.IntVar(&opts.baud, "baud", 115200, "serial baud rate")
+	flags.StringVar(&opts.addr, "addr", "", "connect over TCP to host:port (ser2net, ESP3D)")
+	flags.StringVar(&opts.sim, "sim", "", "use a simulated printer: ubl, bilinear or mbl")
+	flags.BoolVar(&opts.checksums, "checksums", false, "send line numbers and checksums")
+	flags.StringVar(&opts.profile, "profile", "", "take connection settings from a saved printer profile")
+	flags.IntVar(&opts.window, "window", printer.DefaultCommandWindow, "commands in flight before waiting for ok")
+	flags.BoolVar(&opts.json, "json", false, "print results as JSON")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Minute, "give up after this long")
+	flags.BoolVar(&opts.verbose, "v", false, "echo all serial traffic to stderr")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: calibrate [flags] <command> [arguments]\n\nCommands:")
+		for _, c := range commands {
+			fmt.Fprintf(flags.Output(), "  %s\n", c.usage)
+		}
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if opts.profile != "" {
+		if err := applyProfile(flags, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "calibrate: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	name := flags.Arg(0)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		cancel := context.CancelFunc(func() {})
+		if !c.untimed {
+			ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		}
+		err := c.run(ctx, opts, flags.Args()[1:])
+		cancel()
+		stop()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "calibrate %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "calibrate: unknown command %q\n", name)
+	flags.Usage()
+	os.Exit(2)
+}
+
+// connect opens the transport chosen by the flags and waits until the
+// firmware's capabilities are known.
+func connect(ctx context.Context, opts *options) (*printer.Client, error) {
+	var (
+		transport printer.Transport
+		err       error
+	)
+	switch {
+	case opts.sim != "":
+		sim := simulator.New()
+		switch strings.ToLower(opts.sim) {
+		case "ubl":
+		case "bilinear":
+			sim.Leveling = simulator.Bilinear
+		case "mbl":
+			sim.Leveling = simulator.Manual
+			sim.Probe = false
+		default:
+			return nil, fmt.Errorf("unknown simulator %q", opts.sim)
+		}
+		var device io.ReadWriteCloser
+		transport, device = printer.NewPipe()
+		go func() {
+			_ = sim.Serve(device)
+		}()
+	case opts.addr != "":
+		transport, err = printer.DialTCP(opts.addr)
+	case opts.port != "":
+		transport, err = printer.OpenSerial(opts.port, opts.baud)
+	default:
+		return nil, errors.New("choose a printer with -port, -addr or -sim")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client := printer.NewClient()
+	client.SetChecksums(opts.checksums)
+	client.SetCommandWindow(opts.window)
+	if opts.verbose {
+		client.AddLogListener(func(text string) {
+			fmt.Fprint(os.Stderr, text)
+		})
+	}
+	detected := make(chan struct{}, 1)
+	client.AddCapabilitiesListener(func(printer.Capabilities) {
+		select {
+		case detected <- struct{}{}:
+		default:
+		}
+	})
+	if err := client.ConnectTransport(transport); err != nil {
+		return nil, err
+	}
+	select {
+	case <-detected:
+	case <-ctx.Done():
+		client.Disconnect()
+		return nil, ctx.Err()
+	}
+	return client, nil
+}
+
+// applyProfile fills the connection options from the named profile. Flags
+// given on the command line take precedence.
+func applyProfile(flags *flag.FlagSet, opts *options) error {
+	path, err := profiles.DefaultPath()
+	if err != nil {
+		return err
+	}
+	store, err := profiles.Load(path)
+	if err != nil {
+		return err
+	}
+	p, ok := store.Get(opts.profile)
+	if !ok {
+		return fmt.Errorf("no profile named %q in %s", opts.profile, path)
+	}
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if set["port"] || set["addr"] || set["sim"] {
+		return nil
+	}
+	switch p.Transport {
+	case profiles.TransportSerial:
+		if opts.port, err = p.ResolvePort(); err != nil {
+			return err
+		}
+		if !set["baud"] && p.Baud != 0 {
+			opts.baud = p.Baud
+		}
+	case profiles.TransportTCP:
+		opts.addr = p.Address
+	default:
+		opts.sim = strings.TrimPrefix(p.Transport, "sim-")
+	}
+	if !set["checksums"] {
+		opts.checksums = p.Checksums
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+	"github.com/nulldozer/printer-calibration-utility/server"
+)
+
+// runServe serves the API until interrupted. With connection flags the
+// printer is connected up front; otherwise clients connect through the API.
+func runServe(ctx context.Context, opts *options, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flags.String("listen", "127.0.0.1:8080", "address to listen on")
+	origins := flags.String("allow-origin", "", "comma-separated browser origins allowed to use the API")
+	hosts := flags.String("allow-host", "", "comma-separated host names, besides loopback ones, the API may be reached by")
+	flags.Parse(args)
+
+	var client *printer.Client
+	if opts.port != "" || opts.addr != "" || opts.sim != "" {
+		connectCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+		c, err := connect(connectCtx, opts)
+		cancel()
+		if err != nil {
+			return err
+		}
+		client = c
+	} else {
+		client = printer.NewClient()
+		client.SetCommandWindow(opts.window)
+	}
+	defer client.Disconnect()
+
+	srv := server.New(client)
+	if *origins != "" {
+		srv.AllowOrigins = strings.Split(*origins, ",")
+	}
+	if *hosts != "" {
+		srv.AllowHosts = strings.Split(*hosts, ",")
+	}
+	// Reaching the server by the address it listens on is always fine.
+	if host, _, err := net.SplitHostPort(*listen); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			srv.AllowHosts = append(srv.AllowHosts, host)
+		}
+	}
+	httpServer := &http.Server{Addr: *listen, Handler: srv}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.ListenAndServe()
+	}()
+	fmt.Fprintf(os.Stderr, "Serving on http://%s\n", *listen)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Hijacked WebSocket connections are not waited for; Close ends them.
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return httpServer.Close()
+}
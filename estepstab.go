@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andlabs/ui"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+)
+
+type eStepsTab struct {
+	client       *printer.Client
+	hint         *ui.Label
+	currentLabel *ui.Label
+	tempEntry    *ui.Entry
+	markEntry    *ui.Entry
+	lengthEntry  *ui.Entry
+	feedEntry    *ui.Entry
+	remainEntry  *ui.Entry
+	resultLabel  *ui.Label
+	readBtn      *ui.Button
+	extrudeBtn   *ui.Button
+	computeBtn   *ui.Button
+	applyBtn     *ui.Button
+	currentSteps float64
+	newSteps     float64
+	extruded     float64
+	mark         float64
+	canSave      bool
+}
+
+func newEStepsTab(client *printer.Client) *eStepsTab {
+	return &eStepsTab{client: client, canSave: true}
+}
+
+func (t *eStepsTab) Build() ui.Control {
+	vbox := ui.NewVerticalBox()
+	vbox.SetPadded(true)
+	t.hint = ui.NewLabel("")
+	vbox.Append(t.hint, false)
+
+	// Stage 1
+	stage1 := ui.NewGroup("Stage 1")
+	stage1.SetMargined(true)
+	stage1Box := ui.NewHorizontalBox()
+	stage1Box.SetPadded(true)
+	t.readBtn = ui.NewButton("Read Current E-Steps")
+	t.readBtn.OnClicked(func(*ui.Button) {
+		go t.readCurrent()
+	})
+	t.currentLabel = ui.NewLabel("Current: ?")
+	stage1Box.Append(t.readBtn, false)
+	stage1Box.Append(t.currentLabel, true)
+	stage1.SetChild(stage1Box)
+	vbox.Append(stage1, false)
+
+	// Stage 2
+	stage2 := ui.NewGroup("Stage 2")
+	stage2.SetMargined(true)
+	stage2Box := ui.NewVerticalBox()
+	stage2Box.SetPadded(true)
+	stage2Box.Append(ui.NewLabel("Mark the filament above the extruder inlet, then heat and extrude"), false)
+	grid := ui.NewGrid()
+	grid.SetPadded(true)
+	t.tempEntry = ui.NewEntry()
+	t.tempEntry.SetText("200")
+	t.markEntry = ui.NewEntry()
+	t.markEntry.SetText("120")
+	t.lengthEntry = ui.NewEntry()
+	t.lengthEntry.SetText("100")
+	t.feedEntry = ui.NewEntry()
+	t.feedEntry.SetText("100")
+	for i, row := range []struct {
+		label string
+		entry *ui.Entry
+	}{
+		{"Hotend temperature (C)", t.tempEntry},
+		{"Mark distance (mm)", t.markEntry},
+		{"Extrude length (mm)", t.lengthEntry},
+		{"Feedrate (mm/min)", t.feedEntry},
+	} {
+		grid.Append(ui.NewLabel(row.label), 0, i, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+		grid.Append(row.entry, 1, i, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+	}
+	stage2Box.Append(grid, false)
+	t.extrudeBtn = ui.NewButton("Heat and Extrude")
+	t.extrudeBtn.OnClicked(func(*ui.Button) {
+		t.startExtrude()
+	})
+	stage2Box.Append(t.extrudeBtn, false)
+	stage2.SetChild(stage2Box)
+	vbox.Append(stage2, false)
+
+	// Stage 3
+	stage3 := ui.NewGroup("Stage 3")
+	stage3.SetMargined(true)
+	stage3Box := ui.NewVerticalBox()
+	stage3Box.SetPadded(true)
+	stage3Box.Append(ui.NewLabel("Measure from the extruder inlet to the mark"), false)
+	measureRow := ui.NewHorizontalBox()
+	measureRow.SetPadded(true)
+	t.remainEntry = ui.NewEntry()
+	t.computeBtn = ui.NewButton("Compute")
+	t.computeBtn.OnClicked(func(*ui.Button) {
+		t.compute()
+	})
+	measureRow.Append(ui.NewLabel("Remaining (mm)"), false)
+	measureRow.Append(t.remainEntry, true)
+	measureRow.Append(t.computeBtn, false)
+	stage3Box.Append(measureRow, false)
+	t.resultLabel = ui.NewLabel("")
+	stage3Box.Append(t.resultLabel, false)
+	t.applyBtn = ui.NewButton("Apply and Save")
+	t.applyBtn.OnClicked(func(*ui.Button) {
+		go t.apply()
+	})
+	stage3Box.Append(t.applyBtn, false)
+	stage3.SetChild(stage3Box)
+	vbox.Append(stage3, false)
+
+	t.enableStage3(false)
+	t.OnConnectionChanged(false)
+	return vbox
+}
+
+func (t *eStepsTab) readCurrent() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	steps, err := t.client.ReadStepsPerUnit(ctx)
+	if err != nil {
+		t.setHint("Could not read steps/mm: " + err.Error())
+		return
+	}
+	t.currentSteps = steps.E
+	ui.QueueMain(func() {
+		t.currentLabel.SetText(fmt.Sprintf("Current: %.2f steps/mm", steps.E))
+		t.extrudeBtn.Enable()
+	})
+	t.setHint("")
+}
+
+func (t *eStepsTab) startExtrude() {
+	temp, err1 := parseEntry(t.tempEntry)
+	mark, err2 := parseEntry(t.markEntry)
+	length, err3 := parseEntry(t.lengthEntry)
+	feed, err4 := parseEntry(t.feedEntry)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || length <= 0 || feed <= 0 {
+		t.hint.SetText("Check the extrusion settings.")
+		return
+	}
+	if length >= mark {
+		t.hint.SetText("The mark must be further away than the extrude length.")
+		return
+	}
+	t.extrudeBtn.Disable()
+	t.enableStage3(false)
+	go func() {
+		defer ui.QueueMain(func() {
+			if t.client.IsConnected() {
+				t.extrudeBtn.Enable()
+			}
+		})
+		// Heating from cold plus a slow extrusion takes a few minutes.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		t.setHint(fmt.Sprintf("Heating to %.0f C...", temp))
+		if err := t.client.HeatHotendAndWait(ctx, temp); err != nil {
+			t.setHint("Heating failed: " + err.Error())
+			return
+		}
+		t.setHint(fmt.Sprintf("Extruding %.0f mm...", length))
+		if err := t.client.Extrude(ctx, length, feed); err != nil {
+			t.setHint("Extrusion failed: " + err.Error())
+			return
+		}
+		t.extruded, t.mark = length, mark
+		t.setHint("Done. Measure the remaining distance to the mark.")
+		ui.QueueMain(func() {
+			t.remainEntry.Enable()
+			t.computeBtn.Enable()
+		})
+	}()
+}
+
+func (t *eStepsTab) compute() {
+	remaining, err := parseEntry(t.remainEntry)
+	if err != nil || remaining < 0 {
+		t.resultLabel.SetText("Enter the remaining distance in mm.")
+		return
+	}
+	actual := t.mark - remaining
+	steps, err := printer.CalibratedESteps(t.currentSteps, t.extruded, actual)
+	if err != nil {
+		t.resultLabel.SetText(err.Error())
+		t.applyBtn.Disable()
+		return
+	}
+	t.newSteps = steps
+	t.resultLabel.SetText(fmt.Sprintf("Fed %.1f of %.1f mm; new value %.2f steps/mm", actual, t.extruded, steps))
+	t.applyBtn.Enable()
+}
+
+func (t *eStepsTab) apply() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := t.client.SetESteps(ctx, t.newSteps); err != nil {
+		t.setHint("Apply failed: " + err.Error())
+		return
+	}
+	t.currentSteps = t.newSteps
+	ui.QueueMain(func() {
+		t.currentLabel.SetText(fmt.Sprintf("Current: %.2f steps/mm", t.newSteps))
+	})
+	t.enableStage3(false)
+	if !t.canSave {
+		t.setHint(fmt.Sprintf("E-steps %.2f applied; firmware has no EEPROM, so it is lost on reset.", t.newSteps))
+		return
+	}
+	if err := t.client.SaveSettings(ctx); err != nil {
+		t.setHint("Save failed: " + err.Error())
+		return
+	}
+	t.setHint(fmt.Sprintf("E-steps %.2f applied and saved. Extrude again to verify.", t.newSteps))
+}
+
+func parseEntry(e *ui.Entry) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(e.Text()), 64)
+}
+
+func (t *eStepsTab) setHint(text string) {
+	ui.QueueMain(func() {
+		if t.hint != nil {
+			t.hint.SetText(text)
+		}
+	})
+}
+
+func (t *eStepsTab) enableStage3(enable bool) {
+	ui.QueueMain(func() {
+		for _, c := range []ui.Control{t.remainEntry, t.computeBtn, t.applyBtn} {
+			if enable {
+				c.Enable()
+			} else {
+				c.Disable()
+			}
+		}
+	})
+}
+
+func (t *eStepsTab) OnCapabilities(caps printer.Capabilities) {
+	t.canSave = caps.Supports(printer.CapEEPROM)
+}
+
+func (t *eStepsTab) OnConnectionChanged(connected bool) {
+	ui.QueueMain(func() {
+		if connected {
+			t.readBtn.Enable()
+			t.hint.SetText("Read the current e-steps to begin.")
+		} else {
+			t.readBtn.Disable()
+			t.extrudeBtn.Disable()
+			t.hint.SetText("Connect first to calibrate the extruder.")
+		}
+	})
+	if !connected {
+		t.enableStage3(false)
+	}
+}
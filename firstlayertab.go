@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/andlabs/ui"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+	"github.com/nulldozer/printer-calibration-utility/profiles"
+)
+
+var testPatterns = []struct {
+	label string
+	key   string
+}{
+	{"Squares per mesh region", printer.PatternSquares},
+	{"Concentric rings", printer.PatternRings},
+	{"Lines", printer.PatternLines},
+}
+
+// firstLayerTab prints a generated first-layer test, which unlike G26 needs
+// nothing special compiled into the firmware.
+type firstLayerTab struct {
+	client      *printer.Client
+	hint        *ui.Label
+	patternDrop *ui.Combobox
+	countSpin   *ui.Spinbox
+	bedXEntry   *ui.Entry
+	bedYEntry   *ui.Entry
+	nozzleEntry *ui.Entry
+	layerEntry  *ui.Entry
+	hotendEntry *ui.Entry
+	bedEntry    *ui.Entry
+	speedEntry  *ui.Entry
+	levelCheck  *ui.Checkbox
+	printBtn    *ui.Button
+	cancelBtn   *ui.Button
+	progress    *ui.ProgressBar
+	status      *ui.Label
+	cancel      context.CancelFunc
+	running     bool
+	connected   bool
+	canLevel    bool
+}
+
+func newFirstLayerTab(client *printer.Client) *firstLayerTab {
+	return &firstLayerTab{client: client, canLevel: true}
+}
+
+func (t *firstLayerTab) Build() ui.Control {
+	vbox := ui.NewVerticalBox()
+	vbox.SetPadded(true)
+	t.hint = ui.NewLabel("")
+	vbox.Append(t.hint, false)
+
+	group := ui.NewGroup("First-Layer Test Print")
+	group.SetMargined(true)
+	box := ui.NewVerticalBox()
+	box.SetPadded(true)
+
+	grid := ui.NewGrid()
+	grid.SetPadded(true)
+	row := 0
+	addRow := func(label string, c ui.Control) {
+		grid.Append(ui.NewLabel(label), 0, row, 1, 1, false, ui.AlignFill, false, ui.AlignCenter)
+		grid.Append(c, 1, row, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+		row++
+	}
+	t.patternDrop = ui.NewCombobox()
+	for _, p := range testPatterns {
+		t.patternDrop.Append(p.label)
+	}
+	addRow("Pattern", t.patternDrop)
+	t.countSpin = ui.NewSpinbox(1, 10)
+	addRow("Squares per side, rings or lines", t.countSpin)
+	t.bedXEntry = ui.NewEntry()
+	addRow("Bed width (mm)", t.bedXEntry)
+	t.bedYEntry = ui.NewEntry()
+	addRow("Bed depth (mm)", t.bedYEntry)
+	t.nozzleEntry = ui.NewEntry()
+	addRow("Nozzle diameter (mm)", t.nozzleEntry)
+	t.layerEntry = ui.NewEntry()
+	addRow("Layer height (mm)", t.layerEntry)
+	t.hotendEntry = ui.NewEntry()
+	addRow("Hotend (°C)", t.hotendEntry)
+	t.bedEntry = ui.NewEntry()
+	addRow("Bed (°C)", t.bedEntry)
+	t.speedEntry = ui.NewEntry()
+	addRow("Speed (mm/s)", t.speedEntry)
+	t.levelCheck = ui.NewCheckbox("Use the saved mesh (M420 S1)")
+	grid.Append(t.levelCheck, 0, row, 2, 1, false, ui.AlignFill, false, ui.AlignFill)
+	box.Append(grid, false)
+
+	btnRow := ui.NewHorizontalBox()
+	btnRow.SetPadded(true)
+	t.printBtn = ui.NewButton("Print Test")
+	t.printBtn.OnClicked(func(*ui.Button) {
+		t.startPrint()
+	})
+	t.cancelBtn = ui.NewButton("Cancel")
+	t.cancelBtn.OnClicked(func(*ui.Button) {
+		if t.cancel != nil {
+			t.cancel()
+		}
+	})
+	btnRow.Append(t.printBtn, false)
+	btnRow.Append(t.cancelBtn, false)
+	box.Append(btnRow, false)
+	t.progress = ui.NewProgressBar()
+	box.Append(t.progress, false)
+	t.status = ui.NewLabel("")
+	box.Append(t.status, false)
+
+	group.SetChild(box)
+	vbox.Append(group, false)
+
+	t.setParams(printer.DefaultTestPrint)
+	t.OnConnectionChanged(false)
+	return vbox
+}
+
+// setParams fills the form. Call on the UI thread.
+func (t *firstLayerTab) setParams(p printer.TestPrint) {
+	for i, pattern := range testPatterns {
+		if pattern.key == p.Pattern {
+			t.patternDrop.SetSelected(i)
+		}
+	}
+	t.countSpin.SetValue(p.Count)
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	t.bedXEntry.SetText(format(p.BedX))
+	t.bedYEntry.SetText(format(p.BedY))
+	t.nozzleEntry.SetText(format(p.Nozzle))
+	t.layerEntry.SetText(format(p.LayerHeight))
+	t.hotendEntry.SetText(format(p.Hotend))
+	t.bedEntry.SetText(format(p.Bed))
+	t.speedEntry.SetText(format(p.Speed))
+	t.levelCheck.SetChecked(p.Leveling)
+}
+
+// params reads the form. Call on the UI thread.
+func (t *firstLayerTab) params() (printer.TestPrint, error) {
+	p := printer.TestPrint{Count: t.countSpin.Value(), Leveling: t.levelCheck.Checked()}
+	if idx := t.patternDrop.Selected(); idx >= 0 && idx < len(testPatterns) {
+		p.Pattern = testPatterns[idx].key
+	}
+	for _, f := range []struct {
+		entry *ui.Entry
+		dst   *float64
+		name  string
+	}{
+		{t.bedXEntry, &p.BedX, "bed width"},
+		{t.bedYEntry, &p.BedY, "bed depth"},
+		{t.nozzleEntry, &p.Nozzle, "nozzle diameter"},
+		{t.layerEntry, &p.LayerHeight, "layer height"},
+		{t.hotendEntry, &p.Hotend, "hotend temperature"},
+		{t.bedEntry, &p.Bed, "bed temperature"},
+		{t.speedEntry, &p.Speed, "speed"},
+	} {
+		v, err := parseEntry(f.entry)
+		if err != nil {
+			return p, fmt.Errorf("enter the %s as a number", f.name)
+		}
+		*f.dst = v
+	}
+	return p, nil
+}
+
+func (t *firstLayerTab) startPrint() {
+	p, err := t.params()
+	if err != nil {
+		t.hint.SetText(err.Error())
+		return
+	}
+	p.Leveling = p.Leveling && t.canLevel
+	// Catch bad parameters before anything heats up.
+	if _, err := p.GCode(); err != nil {
+		t.hint.SetText(err.Error())
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.running = true
+	t.hint.SetText("")
+	t.status.SetText("Heating and homing...")
+	t.progress.SetValue(0)
+	t.updateButtons()
+	go func() {
+		defer cancel()
+		err := t.client.PrintTestPattern(ctx, p, func(sent, total int) {
+			ui.QueueMain(func() {
+				t.progress.SetValue(sent * 100 / total)
+				t.status.SetText(fmt.Sprintf("Sent %d of %d lines", sent, total))
+			})
+		})
+		ui.QueueMain(func() {
+			t.running = false
+			t.cancel = nil
+			switch {
+			case errors.Is(err, context.Canceled):
+				t.status.SetText("Cancelled; heaters off.")
+			case err != nil:
+				t.status.SetText("Failed: " + err.Error())
+			default:
+				t.status.SetText("Done. Check the first layer once the bed has cooled.")
+			}
+			t.updateButtons()
+		})
+	}()
+}
+
+func (t *firstLayerTab) updateButtons() {
+	if t.connected && !t.running {
+		t.printBtn.Enable()
+	} else {
+		t.printBtn.Disable()
+	}
+	if t.running {
+		t.cancelBtn.Enable()
+	} else {
+		t.cancelBtn.Disable()
+	}
+	if t.canLevel {
+		t.levelCheck.Enable()
+	} else {
+		t.levelCheck.Disable()
+	}
+}
+
+// ApplyProfile fills the form from the profile's test print, or from the
+// defaults sized to its bed when it has none. Call on the UI thread.
+func (t *firstLayerTab) ApplyProfile(p profiles.Profile) {
+	tp := p.TestPrint
+	if tp.Pattern == "" {
+		tp = printer.DefaultTestPrint
+		if p.BedX > 0 && p.BedY > 0 {
+			tp.BedX, tp.BedY = p.BedX, p.BedY
+		}
+	}
+	t.setParams(tp)
+}
+
+func (t *firstLayerTab) SaveProfile(p *profiles.Profile) {
+	if tp, err := t.params(); err == nil {
+		p.TestPrint = tp
+	}
+}
+
+func (t *firstLayerTab) OnCapabilities(caps printer.Capabilities) {
+	ui.QueueMain(func() {
+		t.canLevel = caps.Supports(printer.CapLevelingData)
+		t.updateButtons()
+	})
+}
+
+func (t *firstLayerTab) OnConnectionChanged(connected bool) {
+	ui.QueueMain(func() {
+		t.connected = connected
+		t.canLevel = true
+		if connected {
+			t.hint.SetText("")
+		} else {
+			t.hint.SetText("Connect first to print a test.")
+			if t.cancel != nil {
+				t.cancel()
+			}
+		}
+		t.updateButtons()
+	})
+}
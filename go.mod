@@ -4,6 +4,7 @@ go 1.24.1
 
 require (
 	github.com/andlabs/ui v0.0.0-20200610043537-70a69d6ae31e
+	github.com/gorilla/websocket v1.5.3
 	go.bug.st/serial v1.6.4
 )
 
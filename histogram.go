@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/andlabs/ui"
+)
+
+// histogram draws the spread of probe samples as bars, with the mean as a
+// dashed line. Bars turn red when the samples failed the tolerance.
+type histogram struct {
+	area    *ui.Area
+	samples []float64
+	mean    float64
+	failed  bool
+}
+
+func newHistogram() *histogram {
+	h := &histogram{}
+	h.area = ui.NewArea(h)
+	return h
+}
+
+// Set must be called on the UI thread.
+func (h *histogram) Set(samples []float64, mean float64, failed bool) {
+	h.samples = append([]float64(nil), samples...)
+	h.mean, h.failed = mean, failed
+	h.area.QueueRedrawAll()
+}
+
+func (h *histogram) Draw(a *ui.Area, dp *ui.AreaDrawParams) {
+	const (
+		left   = 8.0
+		right  = 8.0
+		top    = 8.0
+		bottom = 18.0
+		// Marlin reports samples to the micron; bins are never narrower.
+		minBin = 0.001
+	)
+	fillRect(dp, 0, 0, dp.AreaWidth, dp.AreaHeight, 1, 1, 1)
+	plotW := dp.AreaWidth - left - right
+	plotH := dp.AreaHeight - top - bottom
+	if plotW <= 0 || plotH <= 0 {
+		return
+	}
+	if len(h.samples) == 0 {
+		drawText(dp, "No samples yet", left, top+plotH/2-7, plotW)
+		return
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, z := range h.samples {
+		lo, hi = math.Min(lo, z), math.Max(hi, z)
+	}
+	bins := 10
+	width := math.Max((hi-lo)/float64(bins), minBin)
+	bins = int(math.Floor((hi-lo)/width+1e-9)) + 1
+	counts := make([]int, bins)
+	most := 0
+	for _, z := range h.samples {
+		i := min(int((z-lo)/width+1e-9), bins-1)
+		counts[i]++
+		most = max(most, counts[i])
+	}
+
+	r, g, b := 0.3, 0.5, 0.8
+	if h.failed {
+		r, g, b = 0.85, 0.3, 0.25
+	}
+	barW := plotW / float64(bins)
+	for i, n := range counts {
+		barH := plotH * float64(n) / float64(most)
+		fillRect(dp, left+float64(i)*barW+1, top+plotH-barH, barW-2, barH, r, g, b)
+	}
+
+	xOf := func(z float64) float64 {
+		return left + plotW*(z-lo)/(width*float64(bins))
+	}
+	black := &ui.DrawBrush{Type: ui.DrawBrushTypeSolid, A: 1}
+	strokeLine(dp, black, true, xOf(h.mean), top, xOf(h.mean), top+plotH)
+	drawText(dp, fmt.Sprintf("%.3f", lo), left-30, top+plotH+2, 60)
+	drawText(dp, fmt.Sprintf("%.3f", lo+width*float64(bins)), left+plotW-30, top+plotH+2, 60)
+}
+
+func (h *histogram) MouseEvent(a *ui.Area, me *ui.AreaMouseEvent) {}
+
+func (h *histogram) MouseCrossed(a *ui.Area, left bool) {}
+
+func (h *histogram) DragBroken(a *ui.Area) {}
+
+func (h *histogram) KeyEvent(a *ui.Area, ke *ui.AreaKeyEvent) bool {
+	return false
+}
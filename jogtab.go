@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andlabs/ui"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+)
+
+// jogAxis is one row of the jog panel.
+type jogAxis struct {
+	name     string
+	steps    []string
+	step     string
+	feedrate string
+	homes    bool
+
+	stepDrop  *ui.EditableCombobox
+	feedEntry *ui.Entry
+	target    *ui.Entry
+	jogBtns   []*ui.Button
+	homeBtn   *ui.Button
+}
+
+// jogTab moves the printer by hand and shows where the firmware says it
+// is, from M114 replies and, where supported, M154 auto-reports.
+type jogTab struct {
+	client     *printer.Client
+	hint       *ui.Label
+	posLabel   *ui.Label
+	refreshBtn *ui.Button
+	autoCheck  *ui.Checkbox
+	mode       *ui.RadioButtons
+	axes       []*jogAxis
+	homeAllBtn *ui.Button
+	goBtn      *ui.Button
+	busy       bool
+	connected  bool
+	canReport  bool
+}
+
+func newJogTab(client *printer.Client) *jogTab {
+	t := &jogTab{
+		client: client,
+		axes: []*jogAxis{
+			{name: "X", steps: []string{"0.1", "1", "10", "50"}, step: "10", feedrate: "3000", homes: true},
+			{name: "Y", steps: []string{"0.1", "1", "10", "50"}, step: "10", feedrate: "3000", homes: true},
+			{name: "Z", steps: []string{"0.01", "0.1", "1", "10"}, step: "1", feedrate: "600", homes: true},
+			{name: "E", steps: []string{"1", "5", "10", "50"}, step: "5", feedrate: "300"},
+		},
+	}
+	client.AddPositionListener(func(pos printer.Position) {
+		ui.QueueMain(func() {
+			t.showPosition(pos)
+		})
+	})
+	return t
+}
+
+func (t *jogTab) Build() ui.Control {
+	vbox := ui.NewVerticalBox()
+	vbox.SetPadded(true)
+	t.hint = ui.NewLabel("")
+	vbox.Append(t.hint, false)
+
+	posGroup := ui.NewGroup("Position")
+	posGroup.SetMargined(true)
+	posRow := ui.NewHorizontalBox()
+	posRow.SetPadded(true)
+	t.posLabel = ui.NewLabel("")
+	posRow.Append(t.posLabel, true)
+	t.refreshBtn = ui.NewButton("Refresh")
+	t.refreshBtn.OnClicked(func(*ui.Button) {
+		_ = t.client.RequestPosition()
+	})
+	posRow.Append(t.refreshBtn, false)
+	t.autoCheck = ui.NewCheckbox("Auto-report (M154)")
+	t.autoCheck.OnToggled(func(c *ui.Checkbox) {
+		t.setAutoReport(c.Checked())
+	})
+	posRow.Append(t.autoCheck, false)
+	posGroup.SetChild(posRow)
+	vbox.Append(posGroup, false)
+
+	jogGroup := ui.NewGroup("Jog")
+	jogGroup.SetMargined(true)
+	jogBox := ui.NewVerticalBox()
+	jogBox.SetPadded(true)
+	t.mode = ui.NewRadioButtons()
+	t.mode.Append("Relative: step by the amounts below")
+	t.mode.Append("Absolute: go to the targets below")
+	t.mode.SetSelected(0)
+	t.mode.OnSelected(func(*ui.RadioButtons) {
+		t.updateButtons()
+	})
+	jogBox.Append(t.mode, false)
+
+	grid := ui.NewGrid()
+	grid.SetPadded(true)
+	for col, title := range []string{"Axis", "", "Step (mm)", "", "Target (mm)", "Feedrate (mm/min)"} {
+		grid.Append(ui.NewLabel(title), col, 0, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	}
+	for i, a := range t.axes {
+		row := i + 1
+		a.stepDrop = ui.NewEditableCombobox()
+		for _, s := range a.steps {
+			a.stepDrop.Append(s)
+		}
+		a.stepDrop.SetText(a.step)
+		a.feedEntry = ui.NewEntry()
+		a.feedEntry.SetText(a.feedrate)
+		a.target = ui.NewEntry()
+		minus := ui.NewButton(a.name + "-")
+		plus := ui.NewButton(a.name + "+")
+		if a.name == "E" {
+			minus.SetText("Retract")
+			plus.SetText("Extrude")
+		}
+		axis := a
+		minus.OnClicked(func(*ui.Button) {
+			t.jog(axis, -1)
+		})
+		plus.OnClicked(func(*ui.Button) {
+			t.jog(axis, 1)
+		})
+		a.jogBtns = []*ui.Button{minus, plus}
+		grid.Append(ui.NewLabel(a.name), 0, row, 1, 1, false, ui.AlignFill, false, ui.AlignCenter)
+		grid.Append(minus, 1, row, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+		grid.Append(a.stepDrop, 2, row, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+		grid.Append(plus, 3, row, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+		grid.Append(a.target, 4, row, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+		grid.Append(a.feedEntry, 5, row, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+		if a.homes {
+			a.homeBtn = ui.NewButton("Home " + a.name)
+			a.homeBtn.OnClicked(func(*ui.Button) {
+				t.home(axis.name)
+			})
+			grid.Append(a.homeBtn, 6, row, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+		}
+	}
+	jogBox.Append(grid, false)
+
+	btnRow := ui.NewHorizontalBox()
+	btnRow.SetPadded(true)
+	t.goBtn = ui.NewButton("Go to Targets")
+	t.goBtn.OnClicked(func(*ui.Button) {
+		t.goToTargets()
+	})
+	btnRow.Append(t.goBtn, false)
+	t.homeAllBtn = ui.NewButton("Home All")
+	t.homeAllBtn.OnClicked(func(*ui.Button) {
+		t.home("")
+	})
+	btnRow.Append(t.homeAllBtn, false)
+	jogBox.Append(btnRow, false)
+	jogGroup.SetChild(jogBox)
+	vbox.Append(jogGroup, false)
+
+	t.OnConnectionChanged(false)
+	return vbox
+}
+
+func (t *jogTab) relative() bool {
+	return t.mode.Selected() == 0
+}
+
+func (t *jogTab) jog(a *jogAxis, dir float64) {
+	step, err := strconv.ParseFloat(strings.TrimSpace(a.stepDrop.Text()), 64)
+	if err != nil || step <= 0 {
+		t.hint.SetText(fmt.Sprintf("Enter a positive %s step in mm.", a.name))
+		return
+	}
+	feed, err := parseEntry(a.feedEntry)
+	if err != nil || feed <= 0 {
+		t.hint.SetText(fmt.Sprintf("Enter a positive %s feedrate in mm/min.", a.name))
+		return
+	}
+	t.run(func(ctx context.Context) error {
+		return t.client.Jog(ctx, a.name, dir*step, feed)
+	})
+}
+
+// goToTargets moves every axis with a target in one move, at the slowest
+// of their feedrates.
+func (t *jogTab) goToTargets() {
+	axes := map[string]float64{}
+	feed := 0.0
+	for _, a := range t.axes {
+		if strings.TrimSpace(a.target.Text()) == "" {
+			continue
+		}
+		v, err := parseEntry(a.target)
+		if err != nil {
+			t.hint.SetText(fmt.Sprintf("Enter the %s target in mm.", a.name))
+			return
+		}
+		f, err := parseEntry(a.feedEntry)
+		if err != nil || f <= 0 {
+			t.hint.SetText(fmt.Sprintf("Enter a positive %s feedrate in mm/min.", a.name))
+			return
+		}
+		axes[a.name] = v
+		if feed == 0 || f < feed {
+			feed = f
+		}
+	}
+	if len(axes) == 0 {
+		t.hint.SetText("Enter a target for at least one axis.")
+		return
+	}
+	t.run(func(ctx context.Context) error {
+		return t.client.Move(ctx, false, feed, axes)
+	})
+}
+
+func (t *jogTab) home(axes string) {
+	if axes == "" {
+		t.hint.SetText("Homing...")
+	} else {
+		t.hint.SetText("Homing " + axes + "...")
+	}
+	t.run(func(ctx context.Context) error {
+		return t.client.Home(ctx, axes)
+	})
+}
+
+// run does f off the UI thread with the panel disabled, then asks for the
+// new position unless the firmware is already reporting it.
+func (t *jogTab) run(f func(ctx context.Context) error) {
+	t.busy = true
+	t.updateButtons()
+	auto := t.autoCheck.Checked()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		err := f(ctx)
+		if !auto {
+			_ = t.client.RequestPosition()
+		}
+		ui.QueueMain(func() {
+			t.busy = false
+			if err != nil {
+				t.hint.SetText("Failed: " + err.Error())
+			} else {
+				t.hint.SetText("")
+			}
+			t.updateButtons()
+		})
+	}()
+}
+
+func (t *jogTab) setAutoReport(on bool) {
+	var err error
+	if on {
+		err = t.client.StartPositionReports(1)
+	} else {
+		err = t.client.StopPositionReports()
+	}
+	if err != nil {
+		t.hint.SetText("Failed: " + err.Error())
+		t.autoCheck.SetChecked(false)
+	}
+}
+
+func (t *jogTab) showPosition(pos printer.Position) {
+	t.posLabel.SetText(fmt.Sprintf("X %.2f   Y %.2f   Z %.2f   E %.2f", pos.X, pos.Y, pos.Z, pos.E))
+}
+
+func (t *jogTab) updateButtons() {
+	ready := t.connected && !t.busy
+	enable := func(c ui.Control, on bool) {
+		if on {
+			c.Enable()
+		} else {
+			c.Disable()
+		}
+	}
+	enable(t.refreshBtn, t.connected)
+	enable(t.autoCheck, t.connected && t.canReport)
+	enable(t.homeAllBtn, ready)
+	enable(t.goBtn, ready && !t.relative())
+	for _, a := range t.axes {
+		for _, b := range a.jogBtns {
+			enable(b, ready && t.relative())
+		}
+		enable(a.target, !t.relative())
+		if a.homeBtn != nil {
+			enable(a.homeBtn, ready)
+		}
+	}
+}
+
+func (t *jogTab) OnCapabilities(caps printer.Capabilities) {
+	ui.QueueMain(func() {
+		t.canReport = caps.Supports(printer.CapAutoreportPos)
+		t.updateButtons()
+	})
+}
+
+func (t *jogTab) OnConnectionChanged(connected bool) {
+	ui.QueueMain(func() {
+		t.connected = connected
+		t.canReport = false
+		t.autoCheck.SetChecked(false)
+		t.posLabel.SetText("Position unknown")
+		if connected {
+			t.hint.SetText("")
+			_ = t.client.RequestPosition()
+		} else {
+			t.hint.SetText("Connect first to move the printer.")
+		}
+		t.updateButtons()
+	})
+}
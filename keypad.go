@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/andlabs/ui"
+)
+
+// keyPad is an area that turns the Up and Down arrow keys into jogs while
+// it has focus, which it gets when clicked.
+type keyPad struct {
+	area    *ui.Area
+	text    string
+	enabled bool
+	onArrow func(dir float64)
+}
+
+func newKeyPad(text string, onArrow func(dir float64)) *keyPad {
+	k := &keyPad{text: text, onArrow: onArrow}
+	k.area = ui.NewArea(k)
+	return k
+}
+
+// SetEnabled must be called on the UI thread.
+func (k *keyPad) SetEnabled(on bool) {
+	k.enabled = on
+	k.area.QueueRedrawAll()
+}
+
+func (k *keyPad) Draw(a *ui.Area, dp *ui.AreaDrawParams) {
+	if k.enabled {
+		fillRect(dp, 0, 0, dp.AreaWidth, dp.AreaHeight, 0.92, 0.95, 1)
+	} else {
+		fillRect(dp, 0, 0, dp.AreaWidth, dp.AreaHeight, 0.93, 0.93, 0.93)
+	}
+	drawText(dp, k.text, 8, dp.AreaHeight/2-7, dp.AreaWidth-16)
+}
+
+func (k *keyPad) MouseEvent(a *ui.Area, me *ui.AreaMouseEvent) {}
+
+func (k *keyPad) MouseCrossed(a *ui.Area, left bool) {}
+
+func (k *keyPad) DragBroken(a *ui.Area) {}
+
+func (k *keyPad) KeyEvent(a *ui.Area, ke *ui.AreaKeyEvent) bool {
+	var dir float64
+	switch ke.ExtKey {
+	case ui.Up:
+		dir = 1
+	case ui.Down:
+		dir = -1
+	default:
+		return false
+	}
+	// Key repeat sends more presses while the key is held.
+	if !ke.Up && k.enabled {
+		k.onArrow(dir)
+	}
+	return true
+}
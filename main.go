@@ -1,44 +1,84 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/andlabs/ui"
 	_ "github.com/andlabs/ui/winmanifest"
 	"go.bug.st/serial"
 
 	"github.com/nulldozer/printer-calibration-utility/printer"
+	"github.com/nulldozer/printer-calibration-utility/profiles"
+	"github.com/nulldozer/printer-calibration-utility/simulator"
 )
 
 type serialUI struct {
-	window        *ui.Window
-	tab           *ui.Tab
-	mainBox       *ui.Box
-	connectionBox *ui.Box
-	portDropdown  *ui.Combobox
-	baudDropdown  *ui.Combobox
-	connectBtn    *ui.Button
-	statusLabel   *ui.Label
-	client        *printer.Client
-	serialTabUI   *serialTab
-	zTabUI        *zOffsetTab
-	tempTabUI     *tempTab
-	bedTabUI      *bedLevelTab
-
-	ports     []string
-	baudRates []int
+	window          *ui.Window
+	tab             *ui.Tab
+	mainBox         *ui.Box
+	connectionBox   *ui.Box
+	transportDrop   *ui.Combobox
+	addressEntry    *ui.Entry
+	portBox         *ui.Box
+	portDropdown    *ui.Combobox
+	baudDropdown    *ui.Combobox
+	checksumBox     *ui.Checkbox
+	profileBox      *ui.Box
+	profileDrop     *ui.EditableCombobox
+	loadProfileBtn  *ui.Button
+	connectBtn      *ui.Button
+	statusLabel     *ui.Label
+	client          *printer.Client
+	serialTabUI     *serialTab
+	zTabUI          *zOffsetTab
+	tempTabUI       *tempTab
+	bedTabUI        *bedLevelTab
+	trammingTabUI   *trammingTab
+	eStepsTabUI     *eStepsTab
+	settingsTabUI   *settingsTab
+	probeTabUI      *probeOffsetTab
+	jogTabUI        *jogTab
+	firstLayerTabUI *firstLayerTab
+
+	connDesc   string
+	ports      []string
+	baudRates  []int
+	transports []string
+	profiles   *profiles.Store
 
 	mu sync.Mutex
 }
 
+const (
+	transportSerial    = "Serial"
+	transportTCP       = "TCP (ser2net / ESP3D)"
+	transportSimulator = "Simulator (UBL)"
+	transportSimABL    = "Simulator (bilinear, probe)"
+	transportSimMBL    = "Simulator (manual mesh, no probe)"
+)
+
+// transportKeys are the names profiles store for each transport.
+var transportKeys = map[string]string{
+	transportSerial:    profiles.TransportSerial,
+	transportTCP:       profiles.TransportTCP,
+	transportSimulator: profiles.TransportSimUBL,
+	transportSimABL:    profiles.TransportSimBilinear,
+	transportSimMBL:    profiles.TransportSimManual,
+}
+
 func main() {
 	ui.Main(func() {
 		app := &serialUI{
-			baudRates: []int{250000, 115200, 57600, 38400, 19200, 9600},
-			client:    printer.NewClient(),
+			baudRates:  []int{250000, 115200, 57600, 38400, 19200, 9600},
+			transports: []string{transportSerial, transportTCP, transportSimulator, transportSimABL, transportSimMBL},
+			client:     printer.NewClient(),
 		}
+		app.client.AddCapabilitiesListener(app.onCapabilities)
 		app.buildUI()
 	})
 }
@@ -66,7 +106,7 @@ func (s *serialUI) buildUI() {
 
 	s.connectionBox = ui.NewVerticalBox()
 	s.connectionBox.SetPadded(false)
-	s.connectionBox.Append(s.makeConnectionGrid(""), false)
+	s.connectionBox.Append(s.makeConnectionGrid(), false)
 	mainBox.Append(s.connectionBox, false)
 
 	s.tab = ui.NewTab()
@@ -76,47 +116,109 @@ func (s *serialUI) buildUI() {
 	s.zTabUI = newZOffsetTab(s.client)
 	s.tab.Append("Z Offset", s.zTabUI.Build())
 	s.tab.SetMargined(1, true)
-	s.tempTabUI = newTempTab(s.client)
+	s.tempTabUI = newTempTab(s.client, s.window)
 	s.tab.Append("Temperature", s.tempTabUI.Build())
 	s.tab.SetMargined(2, true)
-	s.bedTabUI = newBedLevelTab(s.client)
+	s.bedTabUI = newBedLevelTab(s.client, s.window)
 	s.tab.Append("Bed Leveling", s.bedTabUI.Build())
 	s.tab.SetMargined(3, true)
+	s.trammingTabUI = newTrammingTab(s.client)
+	s.tab.Append("Tramming", s.trammingTabUI.Build())
+	s.tab.SetMargined(4, true)
+	s.eStepsTabUI = newEStepsTab(s.client)
+	s.tab.Append("E-Steps", s.eStepsTabUI.Build())
+	s.tab.SetMargined(5, true)
+	s.settingsTabUI = newSettingsTab(s.client, s.window)
+	s.tab.Append("Settings", s.settingsTabUI.Build())
+	s.tab.SetMargined(6, true)
+	s.probeTabUI = newProbeOffsetTab(s.client)
+	s.tab.Append("Probe Offset", s.probeTabUI.Build())
+	s.tab.SetMargined(7, true)
+	s.jogTabUI = newJogTab(s.client)
+	s.tab.Append("Jog", s.jogTabUI.Build())
+	s.tab.SetMargined(8, true)
+	s.firstLayerTabUI = newFirstLayerTab(s.client)
+	s.tab.Append("First Layer", s.firstLayerTabUI.Build())
+	s.tab.SetMargined(9, true)
 	mainBox.Append(s.tab, true)
 
-	s.refreshPorts()
+	s.loadProfiles()
+	s.refreshProfiles("")
+	s.refreshPorts(s.selectedPort())
+	if p, ok := s.profiles.Get(s.profiles.LastUsed); ok {
+		s.applyProfile(p)
+	}
 	s.window.Show()
 }
 
-func (s *serialUI) makeConnectionGrid(selectedPort string) ui.Control {
+func (s *serialUI) makeConnectionGrid() ui.Control {
 	grid := ui.NewGrid()
 	grid.SetPadded(true)
 
-	s.portDropdown = ui.NewCombobox()
-	grid.Append(ui.NewLabel("Serial Port"), 0, 0, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
-	grid.Append(s.portDropdown, 1, 0, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
-	targetIndex := -1
-	for i, port := range s.ports {
-		s.portDropdown.Append(port)
-		if selectedPort != "" && port == selectedPort {
-			targetIndex = i
-		}
-	}
-	if targetIndex >= 0 {
-		s.portDropdown.SetSelected(targetIndex)
-	} else if len(s.ports) > 0 {
-		s.portDropdown.SetSelected(0)
+	s.transportDrop = ui.NewCombobox()
+	for _, name := range s.transports {
+		s.transportDrop.Append(name)
 	}
+	s.transportDrop.SetSelected(0)
+	s.transportDrop.OnSelected(func(*ui.Combobox) {
+		s.updateTransportFields()
+	})
+	grid.Append(ui.NewLabel("Connection"), 0, 0, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(s.transportDrop, 1, 0, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+
+	// The dropdowns sit in boxes of their own so their items can be
+	// replaced without rebuilding, and unfocusing, the rest of the grid.
+	s.portBox = ui.NewHorizontalBox()
+	s.portDropdown = s.makePortDropdown("")
+	s.portBox.Append(s.portDropdown, true)
+	grid.Append(ui.NewLabel("Serial Port"), 0, 1, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(s.portBox, 1, 1, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
 
 	s.baudDropdown = ui.NewCombobox()
-	for _, baud := range s.baudRates {
-		s.baudDropdown.Append(fmt.Sprintf("%d", baud))
-	}
-	if s.baudDropdown.Selected() == -1 {
-		s.baudDropdown.SetSelected(0)
+	for _, rate := range s.baudRates {
+		s.baudDropdown.Append(fmt.Sprintf("%d", rate))
 	}
-	grid.Append(ui.NewLabel("Baud Rate"), 0, 1, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
-	grid.Append(s.baudDropdown, 1, 1, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+	s.baudDropdown.SetSelected(0)
+	grid.Append(ui.NewLabel("Baud Rate"), 0, 2, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(s.baudDropdown, 1, 2, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+
+	s.addressEntry = ui.NewEntry()
+	grid.Append(ui.NewLabel("Address"), 0, 3, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(s.addressEntry, 1, 3, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+
+	s.checksumBox = ui.NewCheckbox("Line numbers and checksums")
+	grid.Append(s.checksumBox, 1, 4, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+
+	s.profileBox = ui.NewHorizontalBox()
+	s.profileDrop = s.makeProfileDrop("")
+	s.profileBox.Append(s.profileDrop, true)
+	grid.Append(ui.NewLabel("Profile"), 0, 5, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(s.profileBox, 1, 5, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+	profileBtns := ui.NewHorizontalBox()
+	profileBtns.SetPadded(true)
+	// Typing a name must not apply anything, and the box cannot tell typing
+	// from picking, so a profile is only applied when Load is clicked.
+	s.loadProfileBtn = ui.NewButton("Load")
+	s.loadProfileBtn.OnClicked(func(*ui.Button) {
+		name := strings.TrimSpace(s.profileDrop.Text())
+		if p, ok := s.profiles.Get(name); ok {
+			s.applyProfile(p)
+		} else {
+			s.appendLog(fmt.Sprintf("No profile named %s", name))
+		}
+	})
+	saveProfile := ui.NewButton("Save")
+	saveProfile.OnClicked(func(*ui.Button) {
+		s.saveProfile()
+	})
+	deleteProfile := ui.NewButton("Delete")
+	deleteProfile.OnClicked(func(*ui.Button) {
+		s.deleteProfile()
+	})
+	profileBtns.Append(s.loadProfileBtn, false)
+	profileBtns.Append(saveProfile, false)
+	profileBtns.Append(deleteProfile, false)
+	grid.Append(profileBtns, 2, 5, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
 
 	s.connectBtn = ui.NewButton("Connect")
 	s.connectBtn.OnClicked(func(*ui.Button) {
@@ -130,15 +232,65 @@ func (s *serialUI) makeConnectionGrid(selectedPort string) ui.Control {
 
 	refresh := ui.NewButton("Refresh")
 	refresh.OnClicked(func(*ui.Button) {
-		s.refreshPorts()
+		s.refreshPorts(s.selectedPort())
 	})
 	grid.Append(refresh, 2, 1, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
 
+	s.updateTransportFields()
 	return grid
 }
 
-func (s *serialUI) refreshPorts() {
-	current := s.selectedPort()
+func (s *serialUI) makePortDropdown(selected string) *ui.Combobox {
+	drop := ui.NewCombobox()
+	targetIndex := -1
+	for i, port := range s.ports {
+		drop.Append(port)
+		if selected != "" && port == selected {
+			targetIndex = i
+		}
+	}
+	if targetIndex >= 0 {
+		drop.SetSelected(targetIndex)
+	} else if len(s.ports) > 0 {
+		drop.SetSelected(0)
+	}
+	return drop
+}
+
+func (s *serialUI) makeProfileDrop(text string) *ui.EditableCombobox {
+	drop := ui.NewEditableCombobox()
+	if s.profiles != nil {
+		for _, name := range s.profiles.Names() {
+			drop.Append(name)
+		}
+	}
+	drop.SetText(text)
+	return drop
+}
+
+// updateTransportFields enables only the inputs the selected transport uses.
+func (s *serialUI) updateTransportFields() {
+	if s.isConnected() {
+		return
+	}
+	transport := s.selectedTransport()
+	for _, c := range []ui.Control{s.portDropdown, s.baudDropdown} {
+		if transport == transportSerial {
+			c.Enable()
+		} else {
+			c.Disable()
+		}
+	}
+	if transport == transportTCP {
+		s.addressEntry.Enable()
+	} else {
+		s.addressEntry.Disable()
+	}
+}
+
+// refreshPorts lists the serial ports again and selects the named one if it
+// is still there.
+func (s *serialUI) refreshPorts(selected string) {
 	ports, err := serial.GetPortsList()
 	if err != nil {
 		s.appendLog(fmt.Sprintf("Failed to list ports: %v", err))
@@ -151,33 +303,245 @@ func (s *serialUI) refreshPorts() {
 	}
 
 	s.ports = ports
-	if s.connectionBox != nil {
-		// rebuild the grid to refresh dropdown items
-		s.connectionBox.Delete(0)
-		s.connectionBox.Append(s.makeConnectionGrid(current), false)
+	// Comboboxes cannot drop items, so the dropdown is replaced.
+	s.portBox.Delete(0)
+	s.portDropdown = s.makePortDropdown(selected)
+	s.portBox.Append(s.portDropdown, true)
+	if s.isConnected() {
+		s.portDropdown.Disable()
+	} else {
+		s.updateTransportFields()
 	}
 }
 
+// refreshProfiles lists the stored profiles again, showing text in the box.
+func (s *serialUI) refreshProfiles(text string) {
+	s.profileBox.Delete(0)
+	s.profileDrop = s.makeProfileDrop(text)
+	s.profileBox.Append(s.profileDrop, true)
+	if s.isConnected() {
+		s.profileDrop.Disable()
+	}
+}
+
+func (s *serialUI) loadProfiles() {
+	path, err := profiles.DefaultPath()
+	if err == nil {
+		s.profiles, err = profiles.Load(path)
+	}
+	if err != nil {
+		s.appendLog(fmt.Sprintf("Could not load profiles: %v", err))
+		s.profiles = &profiles.Store{}
+	}
+}
+
+// applyProfile fills the connection fields and tab settings from p and
+// remembers it as the last used profile.
+func (s *serialUI) applyProfile(p profiles.Profile) {
+	for i, name := range s.transports {
+		if transportKeys[name] == p.Transport {
+			s.transportDrop.SetSelected(i)
+		}
+	}
+	for i, rate := range s.baudRates {
+		if rate == p.Baud {
+			s.baudDropdown.SetSelected(i)
+		}
+	}
+	s.addressEntry.SetText(p.Address)
+	s.checksumBox.SetChecked(p.Checksums)
+	s.profileDrop.SetText(p.Name)
+
+	if p.Transport == profiles.TransportSerial {
+		port, err := p.ResolvePort()
+		if err != nil {
+			s.appendLog(fmt.Sprintf("Profile %s: %v", p.Name, err))
+		}
+		s.refreshPorts(port)
+		if port != "" && s.selectedPort() != port {
+			s.appendLog(fmt.Sprintf("Profile %s: port %s not found", p.Name, port))
+		}
+	}
+	s.updateTransportFields()
+
+	s.tempTabUI.ApplyProfile(p)
+	s.bedTabUI.ApplyProfile(p)
+	s.trammingTabUI.ApplyProfile(p)
+	s.firstLayerTabUI.ApplyProfile(p)
+
+	if s.profiles.LastUsed != p.Name {
+		s.profiles.LastUsed = p.Name
+		if err := s.profiles.Save(); err != nil {
+			s.appendLog(fmt.Sprintf("Could not save profiles: %v", err))
+		}
+	}
+}
+
+// saveProfile stores the current settings under the name in the profile
+// box. When connected it also records the firmware and probe offsets.
+func (s *serialUI) saveProfile() {
+	name := strings.TrimSpace(s.profileDrop.Text())
+	if name == "" {
+		s.appendLog("Enter a profile name before saving")
+		return
+	}
+	p, ok := s.profiles.Get(name)
+	if !ok {
+		p = profiles.New(name)
+	}
+	p.Transport = transportKeys[s.selectedTransport()]
+	p.Port = s.selectedPort()
+	p.Baud = s.selectedBaud()
+	p.Address = strings.TrimSpace(s.addressEntry.Text())
+	p.Checksums = s.checksumBox.Checked()
+	p.USBSerial = ""
+	if p.Transport == profiles.TransportSerial {
+		p.USBSerial = profiles.USBSerialOf(p.Port)
+	}
+	s.tempTabUI.SaveProfile(&p)
+	s.bedTabUI.SaveProfile(&p)
+	s.trammingTabUI.SaveProfile(&p)
+	s.firstLayerTabUI.SaveProfile(&p)
+
+	caps := s.client.Capabilities()
+	if !s.isConnected() || !caps.Supports(printer.CapZProbe) {
+		s.storeProfile(p)
+		return
+	}
+	p.Firmware = caps.FirmwareName
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		offset, err := s.client.ReadProbeOffset(ctx)
+		if err != nil {
+			s.appendLog(fmt.Sprintf("Could not read probe offsets: %v", err))
+		} else {
+			p.ProbeOffset = offset
+		}
+		ui.QueueMain(func() {
+			s.storeProfile(p)
+		})
+	}()
+}
+
+func (s *serialUI) storeProfile(p profiles.Profile) {
+	s.profiles.Put(p)
+	s.profiles.LastUsed = p.Name
+	if err := s.profiles.Save(); err != nil {
+		s.appendLog(fmt.Sprintf("Could not save profiles: %v", err))
+		return
+	}
+	s.appendLog(fmt.Sprintf("Saved profile %s", p.Name))
+	s.refreshProfiles(p.Name)
+}
+
+func (s *serialUI) deleteProfile() {
+	name := strings.TrimSpace(s.profileDrop.Text())
+	if _, ok := s.profiles.Get(name); !ok {
+		return
+	}
+	s.profiles.Delete(name)
+	if err := s.profiles.Save(); err != nil {
+		s.appendLog(fmt.Sprintf("Could not save profiles: %v", err))
+		return
+	}
+	s.appendLog(fmt.Sprintf("Deleted profile %s", name))
+	s.refreshProfiles("")
+}
+
 func (s *serialUI) connect() {
-	portName := s.selectedPort()
-	if portName == "" {
-		s.appendLog("Select a serial port before connecting")
+	var (
+		transport printer.Transport
+		desc      string
+		err       error
+	)
+	switch s.selectedTransport() {
+	case transportSerial:
+		portName := s.selectedPort()
+		if portName == "" {
+			s.appendLog("Select a serial port before connecting")
+			return
+		}
+		baud := s.selectedBaud()
+		if baud == 0 {
+			s.appendLog("Select a baud rate before connecting")
+			return
+		}
+		desc = fmt.Sprintf("%s @ %d baud", portName, baud)
+		transport, err = printer.OpenSerial(portName, baud)
+	case transportTCP:
+		addr := strings.TrimSpace(s.addressEntry.Text())
+		if addr == "" {
+			s.appendLog("Enter a host:port address before connecting")
+			return
+		}
+		desc = addr
+		transport, err = printer.DialTCP(addr)
+	case transportSimulator, transportSimABL, transportSimMBL:
+		sim := simulator.New()
+		switch s.selectedTransport() {
+		case transportSimABL:
+			sim.Leveling = simulator.Bilinear
+		case transportSimMBL:
+			sim.Leveling = simulator.Manual
+			sim.Probe = false
+		}
+		var device io.ReadWriteCloser
+		transport, device = printer.NewPipe()
+		go func() {
+			_ = sim.Serve(device)
+		}()
+		desc = "simulated printer"
+	default:
+		s.appendLog("Select a connection type before connecting")
 		return
 	}
-	baud := s.selectedBaud()
-	if baud == 0 {
-		s.appendLog("Select a baud rate before connecting")
+	if err != nil {
+		s.appendLog(fmt.Sprintf("Failed to open %s: %v", desc, err))
 		return
 	}
 
-	if err := s.client.Connect(portName, baud); err != nil {
-		s.appendLog(fmt.Sprintf("Failed to open %s: %v", portName, err))
+	s.client.SetChecksums(s.checksumBox.Checked())
+	if err := s.client.ConnectTransport(transport); err != nil {
+		s.appendLog(fmt.Sprintf("Failed to connect to %s: %v", desc, err))
 		return
 	}
 
+	s.connDesc = desc
 	s.updateConnectionUI(true)
-	s.appendLog(fmt.Sprintf("Connected to %s @ %d baud", portName, baud))
-	s.setStatus(fmt.Sprintf("Connected to %s @ %d baud", portName, baud))
+	s.appendLog(fmt.Sprintf("Connected to %s", desc))
+	s.setStatus(fmt.Sprintf("Connected to %s, detecting firmware...", desc))
+}
+
+func (s *serialUI) onCapabilities(caps printer.Capabilities) {
+	s.setStatus(fmt.Sprintf("Connected to %s: %s", s.connDesc, caps))
+	if s.zTabUI != nil {
+		s.zTabUI.OnCapabilities(caps)
+	}
+	if s.tempTabUI != nil {
+		s.tempTabUI.OnCapabilities(caps)
+	}
+	if s.bedTabUI != nil {
+		s.bedTabUI.OnCapabilities(caps)
+	}
+	if s.trammingTabUI != nil {
+		s.trammingTabUI.OnCapabilities(caps)
+	}
+	if s.eStepsTabUI != nil {
+		s.eStepsTabUI.OnCapabilities(caps)
+	}
+	if s.settingsTabUI != nil {
+		s.settingsTabUI.OnCapabilities(caps)
+	}
+	if s.probeTabUI != nil {
+		s.probeTabUI.OnCapabilities(caps)
+	}
+	if s.jogTabUI != nil {
+		s.jogTabUI.OnCapabilities(caps)
+	}
+	if s.firstLayerTabUI != nil {
+		s.firstLayerTabUI.OnCapabilities(caps)
+	}
 }
 
 func (s *serialUI) disconnect() {
@@ -196,6 +560,14 @@ func (s *serialUI) selectedPort() string {
 	return s.ports[idx]
 }
 
+func (s *serialUI) selectedTransport() string {
+	idx := s.transportDrop.Selected()
+	if idx < 0 || idx >= len(s.transports) {
+		return ""
+	}
+	return s.transports[idx]
+}
+
 func (s *serialUI) selectedBaud() int {
 	idx := s.baudDropdown.Selected()
 	if idx < 0 || idx >= len(s.baudRates) {
@@ -212,12 +584,20 @@ func (s *serialUI) updateConnectionUI(connected bool) {
 	ui.QueueMain(func() {
 		if connected {
 			s.connectBtn.SetText("Disconnect")
+			s.transportDrop.Disable()
 			s.portDropdown.Disable()
 			s.baudDropdown.Disable()
+			s.addressEntry.Disable()
+			s.checksumBox.Disable()
+			s.profileDrop.Disable()
+			s.loadProfileBtn.Disable()
 		} else {
 			s.connectBtn.SetText("Connect")
-			s.portDropdown.Enable()
-			s.baudDropdown.Enable()
+			s.transportDrop.Enable()
+			s.checksumBox.Enable()
+			s.profileDrop.Enable()
+			s.loadProfileBtn.Enable()
+			s.updateTransportFields()
 		}
 	})
 	if s.serialTabUI != nil {
@@ -232,6 +612,24 @@ func (s *serialUI) updateConnectionUI(connected bool) {
 	if s.bedTabUI != nil {
 		s.bedTabUI.OnConnectionChanged(connected)
 	}
+	if s.trammingTabUI != nil {
+		s.trammingTabUI.OnConnectionChanged(connected)
+	}
+	if s.eStepsTabUI != nil {
+		s.eStepsTabUI.OnConnectionChanged(connected)
+	}
+	if s.settingsTabUI != nil {
+		s.settingsTabUI.OnConnectionChanged(connected)
+	}
+	if s.probeTabUI != nil {
+		s.probeTabUI.OnConnectionChanged(connected)
+	}
+	if s.jogTabUI != nil {
+		s.jogTabUI.OnConnectionChanged(connected)
+	}
+	if s.firstLayerTabUI != nil {
+		s.firstLayerTabUI.OnConnectionChanged(connected)
+	}
 }
 
 func (s *serialUI) appendLog(text string) {
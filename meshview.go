@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/andlabs/ui"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+)
+
+// meshView draws a bed mesh as a colour heatmap, front of the bed at the
+// bottom, blue for the lowest point and red for the highest.
+type meshView struct {
+	area *ui.Area
+	mesh *printer.Mesh
+}
+
+func newMeshView() *meshView {
+	v := &meshView{}
+	v.area = ui.NewArea(v)
+	return v
+}
+
+// SetMesh must be called on the UI thread.
+func (v *meshView) SetMesh(mesh *printer.Mesh) {
+	v.mesh = mesh
+	v.area.QueueRedrawAll()
+}
+
+func (v *meshView) Draw(a *ui.Area, dp *ui.AreaDrawParams) {
+	const margin = 4.0
+	mesh := v.mesh
+	if mesh == nil || mesh.Rows() == 0 || mesh.Cols() == 0 {
+		drawText(dp, "No mesh loaded", margin, margin, dp.AreaWidth)
+		return
+	}
+	st := mesh.Stats()
+	cellW := (dp.AreaWidth - 2*margin) / float64(mesh.Cols())
+	cellH := (dp.AreaHeight - 2*margin) / float64(mesh.Rows())
+	for y, row := range mesh.Z {
+		top := margin + float64(mesh.Rows()-1-y)*cellH
+		for x, z := range row {
+			left := margin + float64(x)*cellW
+			r, g, b := 0.6, 0.6, 0.6
+			label := "."
+			if !math.IsNaN(z) {
+				r, g, b = heatColor(z, st.Min, st.Max)
+				label = fmt.Sprintf("%+.3f", z)
+			}
+			fillRect(dp, left+1, top+1, cellW-2, cellH-2, r, g, b)
+			drawText(dp, label, left, top+cellH/2-7, cellW)
+		}
+	}
+}
+
+func (v *meshView) MouseEvent(a *ui.Area, me *ui.AreaMouseEvent) {}
+
+func (v *meshView) MouseCrossed(a *ui.Area, left bool) {}
+
+func (v *meshView) DragBroken(a *ui.Area) {}
+
+func (v *meshView) KeyEvent(a *ui.Area, ke *ui.AreaKeyEvent) bool {
+	return false
+}
+
+// heatColor maps z within [min, max] from blue through green to red.
+func heatColor(z, min, max float64) (r, g, b float64) {
+	t := 0.5
+	if max > min {
+		t = (z - min) / (max - min)
+	}
+	if t < 0.5 {
+		return 0, t * 2, 1 - t*2
+	}
+	return (t - 0.5) * 2, 1 - (t-0.5)*2, 0
+}
+
+func fillRect(dp *ui.AreaDrawParams, x, y, w, h, r, g, b float64) {
+	path := ui.DrawNewPath(ui.DrawFillModeWinding)
+	path.AddRectangle(x, y, w, h)
+	path.End()
+	dp.Context.Fill(path, &ui.DrawBrush{Type: ui.DrawBrushTypeSolid, R: r, G: g, B: b, A: 1})
+	path.Free()
+}
+
+func drawText(dp *ui.AreaDrawParams, text string, x, y, width float64) {
+	str := ui.NewAttributedString(text)
+	layout := ui.DrawNewTextLayout(&ui.DrawTextLayoutParams{
+		String:      str,
+		DefaultFont: &ui.FontDescriptor{Family: "sans-serif", Size: 10},
+		Width:       width,
+		Align:       ui.DrawTextAlignCenter,
+	})
+	dp.Context.Text(layout, x, y)
+	layout.Free()
+	str.Free()
+}
@@ -0,0 +1,161 @@
+package printer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Capability names reported by Marlin's M115 "Cap:" lines.
+const (
+	CapAutoreportTemp = "AUTOREPORT_TEMP"
+	CapAutoreportPos  = "AUTOREPORT_POS"
+	CapEEPROM         = "EEPROM"
+	CapZProbe         = "Z_PROBE"
+	CapLevelingData   = "LEVELING_DATA"
+	CapBabystepping   = "BABYSTEPPING"
+)
+
+var reInfoKey = regexp.MustCompile(`([A-Z][A-Z_]+):`)
+
+// Capabilities is the firmware's answer to M115.
+type Capabilities struct {
+	FirmwareName  string
+	MachineType   string
+	ExtruderCount int
+	UUID          string
+	// Caps maps every "Cap:NAME:0|1" line to its value.
+	Caps map[string]bool
+	// Leveling is detected separately from M503, as M115 does not say.
+	Leveling LevelingSystem
+}
+
+// Supports reports whether the firmware has the named capability. Firmware
+// that reports no capabilities at all, or has not been asked yet, is
+// assumed to support everything.
+func (c Capabilities) Supports(name string) bool {
+	if len(c.Caps) == 0 {
+		return true
+	}
+	return c.Caps[name]
+}
+
+func (c Capabilities) String() string {
+	if c.FirmwareName == "" {
+		return "unknown firmware"
+	}
+	s := c.FirmwareName
+	if c.MachineType != "" {
+		s += " (" + c.MachineType + ")"
+	}
+	if c.ExtruderCount == 1 {
+		s += ", 1 extruder"
+	} else if c.ExtruderCount > 1 {
+		s += fmt.Sprintf(", %d extruders", c.ExtruderCount)
+	}
+	if c.Leveling != LevelingUnknown {
+		s += ", " + string(c.Leveling)
+	}
+	return s
+}
+
+// ParseCapabilities reads the FIRMWARE_NAME line and Cap: lines of an M115
+// reply.
+func ParseCapabilities(lines []string) Capabilities {
+	caps := Capabilities{Caps: make(map[string]bool)}
+	for _, line := range lines {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "echo:"))
+		switch {
+		case strings.HasPrefix(line, "Cap:"):
+			name, value, ok := strings.Cut(strings.TrimPrefix(line, "Cap:"), ":")
+			if ok {
+				caps.Caps[name] = strings.TrimSpace(value) == "1"
+			}
+		case strings.HasPrefix(line, "FIRMWARE_NAME:"):
+			info := parseInfoLine(line)
+			caps.FirmwareName = info["FIRMWARE_NAME"]
+			caps.MachineType = info["MACHINE_TYPE"]
+			caps.UUID = info["UUID"]
+			caps.ExtruderCount, _ = strconv.Atoi(info["EXTRUDER_COUNT"])
+		}
+	}
+	return caps
+}
+
+// parseInfoLine splits "KEY:value KEY2:value two" into a map. Values may
+// contain spaces, so each one runs up to the next upper-case key.
+func parseInfoLine(line string) map[string]string {
+	info := make(map[string]string)
+	idx := reInfoKey.FindAllStringSubmatchIndex(line, -1)
+	for i, m := range idx {
+		if m[0] > 0 && line[m[0]-1] != ' ' {
+			continue
+		}
+		end := len(line)
+		for _, next := range idx[i+1:] {
+			if line[next[0]-1] == ' ' {
+				end = next[0]
+				break
+			}
+		}
+		info[line[m[2]:m[3]]] = strings.TrimSpace(line[m[1]:end])
+	}
+	return info
+}
+
+// Capabilities returns what the firmware reported after connecting.
+func (c *Client) Capabilities() Capabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.caps
+}
+
+func (c *Client) AddCapabilitiesListener(f func(Capabilities)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capsListeners = append(c.capsListeners, f)
+}
+
+// detectCapabilities asks for M115, and M503 for the leveling system, and
+// publishes the result even when the firmware does not answer, so listeners
+// always learn that detection ended. If the board reboots meanwhile nothing
+// is published; the "start" it prints runs detection again.
+func (c *Client) detectCapabilities() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var caps Capabilities
+	resp, err := c.SendAndWait(ctx, "M115")
+	if errors.Is(err, ErrPrinterReset) {
+		return
+	}
+	if err != nil {
+		c.broadcastLog(fmt.Sprintf("Capability detection failed: %v\n", err))
+	} else {
+		caps = ParseCapabilities(resp.Lines)
+	}
+	if err == nil && caps.Supports(CapLevelingData) {
+		resp, err := c.SendAndWait(ctx, "M503")
+		if errors.Is(err, ErrPrinterReset) {
+			return
+		}
+		if err == nil {
+			caps.Leveling = detectLevelingSystem(resp.Lines)
+		}
+	}
+
+	c.mu.Lock()
+	if c.conn == nil {
+		c.mu.Unlock()
+		return
+	}
+	c.caps = caps
+	listeners := append([]func(Capabilities){}, c.capsListeners...)
+	c.mu.Unlock()
+	for _, f := range listeners {
+		f(caps)
+	}
+}
@@ -0,0 +1,52 @@
+package printer
+
+import "testing"
+
+func TestParseCapabilities(t *testing.T) {
+	reply := []string{
+		"FIRMWARE_NAME:Marlin 2.1.2 (Jan  1 2023 12:00:00) SOURCE_CODE_URL:github.com/MarlinFirmware/Marlin PROTOCOL_VERSION:1.0 MACHINE_TYPE:Ender-3 V2 EXTRUDER_COUNT:1 UUID:cede2a2f-41a2-4748-9b12-c55c62f367ff",
+		"Cap:SERIAL_XON_XOFF:0",
+		"Cap:EEPROM:1",
+		"echo:Cap:Z_PROBE:1",
+		"Cap:AUTOREPORT_TEMP:0",
+		"Cap:BABYSTEPPING:1",
+	}
+	caps := ParseCapabilities(reply)
+	if caps.FirmwareName != "Marlin 2.1.2 (Jan  1 2023 12:00:00)" {
+		t.Errorf("FirmwareName = %q", caps.FirmwareName)
+	}
+	if caps.MachineType != "Ender-3 V2" {
+		t.Errorf("MachineType = %q", caps.MachineType)
+	}
+	if caps.ExtruderCount != 1 {
+		t.Errorf("ExtruderCount = %d", caps.ExtruderCount)
+	}
+	if caps.UUID != "cede2a2f-41a2-4748-9b12-c55c62f367ff" {
+		t.Errorf("UUID = %q", caps.UUID)
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{CapEEPROM, true},
+		{CapZProbe, true},
+		{CapBabystepping, true},
+		{CapAutoreportTemp, false},
+		{"SERIAL_XON_XOFF", false},
+		{CapAutoreportPos, false},
+	}
+	for _, tt := range tests {
+		if got := caps.Supports(tt.name); got != tt.want {
+			t.Errorf("Supports(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSupportsWithoutCaps(t *testing.T) {
+	for _, caps := range []Capabilities{{}, ParseCapabilities([]string{"FIRMWARE_NAME:Klipper"})} {
+		if !caps.Supports(CapEEPROM) {
+			t.Errorf("%v: firmware without Cap: lines should be assumed to support everything", caps)
+		}
+	}
+}
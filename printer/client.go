@@ -1,28 +1,55 @@
 package printer
 
 import (
+	"context"
 	"fmt"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
-
-	goserial "go.bug.st/serial"
 )
 
 type Client struct {
-	mu            sync.Mutex
-	port          goserial.Port
-	readStop      chan struct{}
-	logListeners  []func(string)
-	tempListeners []func(hCurrent, hTarget, bCurrent, bTarget string)
-	bedListeners  []func(string)
-	lineBuf       string
-	monitoring    bool
+	mu             sync.Mutex
+	conn           *connection
+	window         int
+	checksums      bool
+	logListeners   []func(string)
+	tempListeners  []func(TempReport)
+	bedListeners   []func(string)
+	capsListeners  []func(Capabilities)
+	posListeners   []func(Position)
+	printListeners []func(bool)
+	tempLog        *TempLog
+	caps           Capabilities
+	lineBuf        string
+	monitoring     bool
 }
 
 func NewClient() *Client {
-	return &Client{}
+	return &Client{window: DefaultCommandWindow}
+}
+
+// SetCommandWindow sets how many commands may be sent before an "ok" is
+// required. Values below one are treated as one.
+func (c *Client) SetCommandWindow(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	c.window = n
+	if c.conn != nil {
+		c.conn.queue.setWindow(n)
+	}
+}
+
+// SetChecksums enables Marlin's "N<line> <cmd>*<checksum>" framing with
+// automatic retransmission on resend requests. It takes effect on the next
+// Connect.
+func (c *Client) SetChecksums(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checksums = enabled
 }
 
 func (c *Client) AddLogListener(f func(string)) {
@@ -31,7 +58,9 @@ func (c *Client) AddLogListener(f func(string)) {
 	c.logListeners = append(c.logListeners, f)
 }
 
-func (c *Client) AddTempListener(f func(hCurrent, hTarget, bCurrent, bTarget string)) {
+// AddTempListener registers f for every temperature report received while
+// monitoring is on.
+func (c *Client) AddTempListener(f func(TempReport)) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.tempListeners = append(c.tempListeners, f)
@@ -53,70 +82,94 @@ func (c *Client) ClearLineBuffer() {
 func (c *Client) IsConnected() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.port != nil
+	return c.conn != nil
 }
 
+// Connect opens a serial port and connects to it.
 func (c *Client) Connect(portName string, baud int) error {
-	mode := &goserial.Mode{BaudRate: baud}
-	port, err := goserial.Open(portName, mode)
+	port, err := OpenSerial(portName, baud)
 	if err != nil {
 		return err
 	}
-	if err := port.SetReadTimeout(500 * time.Millisecond); err != nil {
-		port.Close()
-		return err
-	}
+	return c.ConnectTransport(port)
+}
 
+// ConnectTransport takes ownership of an already opened transport; it is
+// closed by Disconnect or if the client is already connected.
+func (c *Client) ConnectTransport(port Transport) error {
 	c.mu.Lock()
-	if c.port != nil {
+	if c.conn != nil {
 		c.mu.Unlock()
 		port.Close()
 		return fmt.Errorf("already connected")
 	}
-	stop := make(chan struct{})
-	c.port = port
-	c.readStop = stop
+	conn := &connection{
+		port:   port,
+		queue:  newCommandQueue(c.window),
+		writer: newLineWriter(port, c.checksums),
+		stop:   make(chan struct{}),
+	}
+	c.conn = conn
 	c.mu.Unlock()
 
-	go c.readLoop(port, stop)
+	go c.readLoop(conn)
+	go c.writeLoop(conn)
+	if conn.writer.checksums {
+		// Start numbering from zero whatever the firmware saw last.
+		if err := c.SendRaw("M110 N0"); err != nil {
+			c.Disconnect()
+			return err
+		}
+	}
+	go c.detectCapabilities()
 	return nil
 }
 
 func (c *Client) Disconnect() error {
 	c.mu.Lock()
-	if c.port == nil {
-		c.mu.Unlock()
+	conn := c.conn
+	c.conn = nil
+	c.caps = Capabilities{}
+	c.mu.Unlock()
+	if conn == nil {
 		return nil
 	}
-	if c.readStop != nil {
-		close(c.readStop)
-	}
-	port := c.port
-	c.port = nil
-	c.readStop = nil
-	c.mu.Unlock()
-	return port.Close()
+	close(conn.stop)
+	conn.queue.close(ErrDisconnected)
+	return conn.port.Close()
 }
 
+// SendRaw queues cmd for sending and returns without waiting for the
+// printer to acknowledge it.
 func (c *Client) SendRaw(cmd string) error {
+	_, err := c.enqueue(cmd, nil, false)
+	return err
+}
+
+func (c *Client) enqueue(cmd string, onLine func(string), urgent bool) (*command, error) {
+	// Marlin drops comment-only lines without an "ok", which would stall the
+	// queue, so comments never go out.
+	if i := strings.IndexByte(cmd, ';'); i >= 0 {
+		cmd = cmd[:i]
+	}
 	cmd = strings.TrimSpace(cmd)
 	if cmd == "" {
-		return nil
+		return nil, nil
 	}
 	c.mu.Lock()
-	port := c.port
+	conn := c.conn
 	c.mu.Unlock()
-	if port == nil {
-		return fmt.Errorf("not connected")
+	if conn == nil {
+		return nil, ErrNotConnected
 	}
-	payload := cmd + "\n"
-	_, err := port.Write([]byte(payload))
-	return err
+	return conn.queue.push(cmd, onLine, urgent)
 }
 
 // Operations
-func (c *Client) ResetZOffset() error {
-	for _, cmd := range []string{"M851 Z0", "G28", "G0 Z0"} {
+
+// JogZ moves Z by delta from wherever it is.
+func (c *Client) JogZ(delta float64) error {
+	for _, cmd := range []string{"G91", fmt.Sprintf("G0 Z%.3f", delta), "G90"} {
 		if err := c.SendRaw(cmd); err != nil {
 			return err
 		}
@@ -124,25 +177,77 @@ func (c *Client) ResetZOffset() error {
 	return nil
 }
 
-func (c *Client) MoveToZ(z float64) error {
-	return c.SendRaw(fmt.Sprintf("G0 Z%.3f", z))
+// Babystep nudges Z by delta mm with M290 while a print is running and
+// waits for the firmware to acknowledge it. P0 keeps firmware built with
+// BABYSTEP_ZPROBE_OFFSET from also changing the probe offset, so the total
+// can be folded into M851 once, with FoldBabysteps.
+//
+// The step is queued ahead of lines not yet sent, so it only takes effect
+// promptly during prints this client streams line by line, such as
+// PrintTestPattern. Marlin reads no new commands while it runs a print of
+// its own like G26, so a step sent then lands once that print is done.
+func (c *Client) Babystep(ctx context.Context, delta float64) error {
+	pc, err := c.enqueue(fmt.Sprintf("M290 Z%.3f P0", delta), nil, true)
+	if err != nil {
+		return err
+	}
+	_, err = c.await(ctx, pc)
+	return err
+}
+
+// FoldBabysteps adds total, the sum of babysteps made, to the probe's Z
+// offset and returns the new offset. It is not saved; call SaveSettings.
+func (c *Client) FoldBabysteps(ctx context.Context, total float64) (float64, error) {
+	offset, err := c.ReadProbeOffset(ctx)
+	if err != nil {
+		return 0, err
+	}
+	z := offset.Z + total
+	if err := c.ApplyZOffset(ctx, z); err != nil {
+		return 0, err
+	}
+	return z, nil
 }
 
-func (c *Client) ApplyZOffset(z float64) error {
-	return c.SendRaw(fmt.Sprintf("M851 Z%.3f", z))
+// MoveTo moves to an absolute position and returns once it is there.
+func (c *Client) MoveTo(ctx context.Context, x, y, z float64) error {
+	return c.sendAll(ctx, "G90", fmt.Sprintf("G0 X%.2f Y%.2f Z%.2f", x, y, z), "M400")
 }
 
-func (c *Client) SaveSettings() error {
-	return c.SendRaw("M500")
+// JogXY moves X and Y by the given amounts from wherever they are and
+// returns once the move has finished.
+func (c *Client) JogXY(ctx context.Context, dx, dy float64) error {
+	return c.sendAll(ctx, "G91", fmt.Sprintf("G0 X%.3f Y%.3f", dx, dy), "G90", "M400")
+}
+
+func (c *Client) ApplyZOffset(ctx context.Context, z float64) error {
+	return c.sendAll(ctx, fmt.Sprintf("M851 Z%.3f", z))
+}
+
+// SetSoftEndstops turns the firmware's software endstops on or off with
+// M211. With them off the nozzle can go below Z0.
+func (c *Client) SetSoftEndstops(ctx context.Context, on bool) error {
+	if on {
+		return c.sendAll(ctx, "M211 S1")
+	}
+	return c.sendAll(ctx, "M211 S0")
+}
+
+func (c *Client) SaveSettings(ctx context.Context) error {
+	return c.sendAll(ctx, "M500")
 }
 
 func (c *Client) StartTempMonitoring() error {
+	c.mu.Lock()
 	c.monitoring = true
+	c.mu.Unlock()
 	return c.SendRaw("M155 S1")
 }
 
 func (c *Client) StopTempMonitoring() error {
+	c.mu.Lock()
 	c.monitoring = false
+	c.mu.Unlock()
 	return c.SendRaw("M155 S0")
 }
 
@@ -154,42 +259,66 @@ func (c *Client) PreheatBed(temp float64) error {
 	return c.SendRaw(fmt.Sprintf("M140 S%.0f", temp))
 }
 
-func (c *Client) RunBedLevelingRoutine() error {
-	cmds := []string{
-		"M501",
-		"M851",
-		"G28",
-		"G29 P1",
-		"G29 P3",
-		"G29 S0",
-		"G29 L0",
-		"M420 S1",
+// RunBedLevelingRoutine levels the bed with strategy, or with the one
+// matching the detected firmware when strategy is nil.
+func (c *Client) RunBedLevelingRoutine(ctx context.Context, strategy LevelingStrategy) error {
+	if strategy == nil {
+		strategy = StrategyFor(c.Capabilities())
 	}
-	for _, cmd := range cmds {
-		if err := c.SendRaw(cmd); err != nil {
-			return err
-		}
-	}
-	return nil
+	return strategy.Run(ctx, c)
 }
 
-func (c *Client) PrintValidationPattern() error {
-	return c.SendRaw("G26 H220 P0.5 L0.15")
+// G26Params configures the mesh validation pattern. Zero Bed leaves the
+// bed temperature to the firmware default.
+type G26Params struct {
+	Hotend      float64 `json:"hotend"`
+	Bed         float64 `json:"bed,omitempty"`
+	LayerHeight float64 `json:"layer_height"`
+	Prime       float64 `json:"prime"`
+}
+
+var DefaultG26Params = G26Params{Hotend: 220, LayerHeight: 0.15, Prime: 0.5}
+
+func (c *Client) PrintValidationPattern(p G26Params) error {
+	cmd := fmt.Sprintf("G26 H%.0f P%.2f L%.2f", p.Hotend, p.Prime, p.LayerHeight)
+	if p.Bed > 0 {
+		cmd += fmt.Sprintf(" B%.0f", p.Bed)
+	}
+	return c.SendRaw(cmd)
 }
 
 // internal
-func (c *Client) readLoop(port goserial.Port, stop <-chan struct{}) {
+// connection holds everything owned by a single Connect/Disconnect cycle.
+type connection struct {
+	port   Transport
+	queue  *commandQueue
+	writer *lineWriter
+	stop   chan struct{}
+}
+
+func (c *Client) writeLoop(conn *connection) {
+	for {
+		cmd, ok := conn.queue.next()
+		if !ok {
+			return
+		}
+		if err := conn.writer.write(cmd.line); err != nil {
+			conn.queue.fail(cmd, err)
+			c.broadcastLog(fmt.Sprintf("Write error: %v\n", err))
+		}
+	}
+}
+
+func (c *Client) readLoop(conn *connection) {
 	buf := make([]byte, 1024)
-	reHot := regexp.MustCompile(`T:([0-9.]+)\s*/\s*([0-9.]+)`)
-	reBed := regexp.MustCompile(`B:([0-9.]+)\s*/\s*([0-9.]+)`)
 	for {
 		select {
-		case <-stop:
+		case <-conn.stop:
 			return
 		default:
 		}
 
-		n, err := port.Read(buf)
+		n, err := conn.port.Read(buf)
 		if err != nil {
 			if isTimeoutError(err) {
 				continue
@@ -203,7 +332,7 @@ func (c *Client) readLoop(port goserial.Port, stop <-chan struct{}) {
 		}
 		data := string(buf[:n])
 		c.broadcastLog(data)
-		c.consumeDataLines(data, reHot, reBed)
+		c.consumeDataLines(data, conn)
 	}
 }
 
@@ -216,40 +345,79 @@ func (c *Client) broadcastLog(text string) {
 	}
 }
 
-func (c *Client) broadcastTemp(hCurrent, hTarget, bCurrent, bTarget string) {
+func (c *Client) broadcastTemp(report TempReport) {
 	c.mu.Lock()
-	listeners := append([]func(string, string, string, string){}, c.tempListeners...)
+	listeners := append([]func(TempReport){}, c.tempListeners...)
 	c.mu.Unlock()
 	for _, f := range listeners {
-		f(hCurrent, hTarget, bCurrent, bTarget)
+		f(report)
 	}
 }
 
-func (c *Client) consumeDataLines(chunk string, reHot, reBed *regexp.Regexp) {
+func (c *Client) consumeDataLines(chunk string, conn *connection) {
 	c.lineBuf += chunk
 	lines := strings.Split(c.lineBuf, "\n")
 	c.lineBuf = lines[len(lines)-1]
 	complete := lines[:len(lines)-1]
 
 	for _, line := range complete {
-		c.consumeTempLine(line, reHot, reBed)
+		c.consumeAckLine(line, conn)
+		c.consumeTempLine(line)
 		c.consumeBedLine(line)
+		c.consumePositionLine(line)
 	}
 }
 
-func (c *Client) consumeTempLine(line string, reHot, reBed *regexp.Regexp) {
-	if !c.monitoring {
+func (c *Client) consumeAckLine(line string, conn *connection) {
+	line = strings.TrimSpace(line)
+	if n, ok := parseResendRequest(line); ok {
+		// Marlin follows every resend request with an "ok" that does not
+		// acknowledge anything we are waiting for.
+		conn.queue.skipAck()
+		if err := conn.writer.resend(n); err != nil {
+			c.broadcastLog(fmt.Sprintf("Resend failed: %v\n", err))
+		}
 		return
 	}
-	var hcur, htar, bcur, btar string
-	if m := reHot.FindStringSubmatch(line); len(m) == 3 {
-		hcur, htar = m[1], m[2]
+	switch {
+	case line == "ok" || strings.HasPrefix(line, "ok "):
+		conn.queue.ack()
+	case line == "start":
+		// The board rebooted; anything in flight is gone. Boards that reset
+		// when the port opens lose the first M115 this way, so ask again.
+		conn.queue.reset(ErrPrinterReset)
+		conn.writer.reset()
+		go c.detectCapabilities()
+	case line == "" || isReplyNoise(line):
+	case strings.HasPrefix(line, "Error:"):
+		if !isProtocolError(line) {
+			conn.queue.collect(line)
+			conn.queue.failHead(&FirmwareError{Message: strings.TrimPrefix(line, "Error:")})
+		}
+	default:
+		conn.queue.collect(line)
 	}
-	if m := reBed.FindStringSubmatch(line); len(m) == 3 {
-		bcur, btar = m[1], m[2]
+}
+
+func (c *Client) consumeTempLine(line string) {
+	c.mu.Lock()
+	log := c.tempLog
+	monitoring := c.monitoring
+	c.mu.Unlock()
+	if log == nil && !monitoring {
+		return
+	}
+	report, ok := ParseTempReport(line, time.Now())
+	if !ok {
+		return
+	}
+	if log != nil {
+		if err := log.Write(report); err != nil {
+			c.broadcastLog(fmt.Sprintf("Temperature log error: %v\n", err))
+		}
 	}
-	if hcur != "" || bcur != "" {
-		c.broadcastTemp(hcur, htar, bcur, btar)
+	if monitoring {
+		c.broadcastTemp(report)
 	}
 }
 
@@ -0,0 +1,107 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StepsPerUnit are the axis resolutions M92 sets, in steps per mm.
+type StepsPerUnit struct {
+	X, Y, Z, E float64
+}
+
+// ReadStepsPerUnit reads the current M92 values from the M503 report.
+func (c *Client) ReadStepsPerUnit(ctx context.Context) (StepsPerUnit, error) {
+	resp, err := c.SendAndWait(ctx, "M503")
+	if err != nil {
+		return StepsPerUnit{}, fmt.Errorf("M503: %w", err)
+	}
+	for _, line := range resp.Lines {
+		if steps, ok := parseM92(line); ok {
+			return steps, nil
+		}
+	}
+	return StepsPerUnit{}, fmt.Errorf("no M92 line in M503 report")
+}
+
+func parseM92(line string) (StepsPerUnit, bool) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), "echo:")
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "M92" {
+		return StepsPerUnit{}, false
+	}
+	var steps StepsPerUnit
+	dst := map[byte]*float64{'X': &steps.X, 'Y': &steps.Y, 'Z': &steps.Z, 'E': &steps.E}
+	for _, f := range fields[1:] {
+		if len(f) < 2 {
+			continue
+		}
+		if p, ok := dst[f[0]]; ok {
+			if v, err := strconv.ParseFloat(f[1:], 64); err == nil {
+				*p = v
+			}
+		}
+	}
+	return steps, steps.E > 0
+}
+
+// HeatHotendAndWait sets the hotend target and blocks until the firmware
+// reports it reached (M109).
+func (c *Client) HeatHotendAndWait(ctx context.Context, temp float64) error {
+	return c.sendAll(ctx, fmt.Sprintf("M109 S%.0f", temp))
+}
+
+// Extrude pushes length mm of filament at feedrate mm/min in relative
+// extrusion mode and returns once the move has finished. It then switches
+// back to absolute extrusion (M82), Marlin's default, even if the move
+// failed or ctx is done.
+func (c *Client) Extrude(ctx context.Context, length, feedrate float64) (err error) {
+	if err := c.sendAll(ctx, "M83"); err != nil {
+		return err
+	}
+	defer func() {
+		// M82 may queue behind the extrusion if ctx ended during it.
+		timeout := 10 * time.Second
+		if feedrate > 0 {
+			timeout += time.Duration(math.Abs(length) / feedrate * float64(time.Minute))
+		}
+		rctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if rerr := c.sendAll(rctx, "M82"); rerr != nil {
+			if err != nil {
+				err = fmt.Errorf("%w; restoring absolute extrusion: %w", err, rerr)
+			} else {
+				err = fmt.Errorf("restoring absolute extrusion: %w", rerr)
+			}
+		}
+	}()
+	resp, err := c.SendAndWait(ctx, fmt.Sprintf("G1 E%.2f F%.0f", length, feedrate))
+	if err != nil {
+		return err
+	}
+	if resp.Find("cold extrusion prevented") != "" {
+		return fmt.Errorf("hotend too cold to extrude")
+	}
+	return c.sendAll(ctx, "M400")
+}
+
+// SetESteps applies new extruder steps/mm without storing them.
+func (c *Client) SetESteps(ctx context.Context, steps float64) error {
+	return c.sendAll(ctx, fmt.Sprintf("M92 E%.2f", steps))
+}
+
+// CalibratedESteps returns the steps/mm that make the extruder feed
+// requested mm when it actually fed actual mm at current steps/mm.
+func CalibratedESteps(current, requested, actual float64) (float64, error) {
+	if current <= 0 || requested <= 0 {
+		return 0, fmt.Errorf("invalid extrusion settings")
+	}
+	if actual <= 0 {
+		return 0, fmt.Errorf("no filament was fed")
+	}
+	return current * requested / actual, nil
+}
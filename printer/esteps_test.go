@@ -0,0 +1,34 @@
+package printer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalibratedESteps(t *testing.T) {
+	tests := []struct {
+		current, requested, actual float64
+		want                       float64
+		err                        bool
+	}{
+		{current: 93, requested: 100, actual: 100, want: 93},
+		{current: 93, requested: 100, actual: 90, want: 103.333333},
+		{current: 415, requested: 100, actual: 104, want: 399.038462},
+		{current: 93, requested: 100, actual: 0, err: true},
+		{current: 93, requested: 100, actual: -5, err: true},
+		{current: 0, requested: 100, actual: 90, err: true},
+		{current: 93, requested: 0, actual: 90, err: true},
+	}
+	for _, tt := range tests {
+		got, err := CalibratedESteps(tt.current, tt.requested, tt.actual)
+		if tt.err {
+			if err == nil {
+				t.Errorf("CalibratedESteps(%g, %g, %g) = %g, want an error", tt.current, tt.requested, tt.actual, got)
+			}
+			continue
+		}
+		if err != nil || math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("CalibratedESteps(%g, %g, %g) = %g, %v; want %g", tt.current, tt.requested, tt.actual, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LevelingSystem is the bed leveling implementation compiled into the
+// firmware.
+type LevelingSystem string
+
+const (
+	LevelingUnknown  LevelingSystem = ""
+	LevelingUBL      LevelingSystem = "UBL"
+	LevelingBilinear LevelingSystem = "Bilinear"
+	LevelingManual   LevelingSystem = "MBL"
+)
+
+var reManualPoint = regexp.MustCompile(`MBL G29 point (\d+) of (\d+)`)
+
+// LevelingStrategy probes the bed, stores the mesh and turns leveling on.
+type LevelingStrategy interface {
+	Name() string
+	System() LevelingSystem
+	Run(ctx context.Context, c *Client) error
+}
+
+// StrategyFor picks the strategy matching the firmware. When the leveling
+// system could not be detected, probe-equipped printers get UBL, which is
+// what this tool always used, and the rest get manual mesh leveling.
+func StrategyFor(caps Capabilities) LevelingStrategy {
+	switch caps.Leveling {
+	case LevelingBilinear:
+		return BilinearStrategy{}
+	case LevelingManual:
+		return &ManualMeshStrategy{}
+	case LevelingUBL:
+		return UBLStrategy{}
+	}
+	if caps.Supports(CapZProbe) {
+		return UBLStrategy{}
+	}
+	return &ManualMeshStrategy{}
+}
+
+// UBLStrategy runs Unified Bed Leveling: probe, fill the unreachable
+// points, save to the EEPROM slot and activate.
+type UBLStrategy struct {
+	Slot int
+}
+
+func (UBLStrategy) Name() string           { return "Unified Bed Leveling" }
+func (UBLStrategy) System() LevelingSystem { return LevelingUBL }
+
+func (s UBLStrategy) Run(ctx context.Context, c *Client) error {
+	if err := c.sendAll(ctx, "M501", "M851", "G28", "G29 P1", "G29 P3"); err != nil {
+		return err
+	}
+	if err := c.SaveMeshSlot(ctx, s.Slot); err != nil {
+		return err
+	}
+	if err := c.LoadMeshSlot(ctx, s.Slot); err != nil {
+		return err
+	}
+	return c.sendAll(ctx, "M420 S1")
+}
+
+// BilinearStrategy runs bilinear (or linear/3-point) automatic bed
+// leveling, where a single G29 probes the whole grid.
+type BilinearStrategy struct{}
+
+func (BilinearStrategy) Name() string           { return "Bilinear ABL" }
+func (BilinearStrategy) System() LevelingSystem { return LevelingBilinear }
+
+func (BilinearStrategy) Run(ctx context.Context, c *Client) error {
+	if err := c.sendAll(ctx, "G28", "G29", "M500"); err != nil {
+		return err
+	}
+	return activateLeveling(ctx, c)
+}
+
+// ManualMeshStrategy runs Mesh Bed Leveling on printers without a probe.
+// The firmware parks the nozzle over each point in turn and Confirm is
+// called so the user can lower it onto the bed, typically with JogZ, before
+// the height is stored and the next point is visited.
+type ManualMeshStrategy struct {
+	Confirm func(ctx context.Context, point, total int) error
+}
+
+func (*ManualMeshStrategy) Name() string           { return "Manual Mesh (MBL)" }
+func (*ManualMeshStrategy) System() LevelingSystem { return LevelingManual }
+
+func (s *ManualMeshStrategy) Run(ctx context.Context, c *Client) error {
+	if s.Confirm == nil {
+		return fmt.Errorf("manual mesh leveling needs a Confirm callback")
+	}
+	resp, err := c.SendAndWait(ctx, "G29 S1")
+	if err != nil {
+		return fmt.Errorf("G29 S1: %w", err)
+	}
+	for {
+		if resp.Find("Mesh probing done") != "" {
+			break
+		}
+		point, total, ok := parseManualPoint(resp)
+		if !ok {
+			return fmt.Errorf("firmware did not report the next mesh point")
+		}
+		if err := s.Confirm(ctx, point, total); err != nil {
+			return err
+		}
+		if resp, err = c.SendAndWait(ctx, "G29 S2"); err != nil {
+			return fmt.Errorf("G29 S2: %w", err)
+		}
+	}
+	if err := c.sendAll(ctx, "M500"); err != nil {
+		return err
+	}
+	return activateLeveling(ctx, c)
+}
+
+func parseManualPoint(resp *Response) (point, total int, ok bool) {
+	m := reManualPoint.FindStringSubmatch(resp.Find("MBL G29 point"))
+	if m == nil {
+		return 0, 0, false
+	}
+	point, _ = strconv.Atoi(m[1])
+	total, _ = strconv.Atoi(m[2])
+	return point, total, true
+}
+
+func activateLeveling(ctx context.Context, c *Client) error {
+	resp, err := c.SendAndWait(ctx, "M420 S1")
+	if err != nil {
+		return fmt.Errorf("M420 S1: %w", err)
+	}
+	if resp.Find("Bed Leveling ON") == "" {
+		return fmt.Errorf("firmware did not turn bed leveling on")
+	}
+	return nil
+}
+
+// detectLevelingSystem reads the section heading Marlin prints in M503
+// above its leveling settings.
+func detectLevelingSystem(lines []string) LevelingSystem {
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "Unified Bed Leveling"):
+			return LevelingUBL
+		case strings.Contains(line, "Mesh Bed Leveling"):
+			return LevelingManual
+		case strings.Contains(line, "Auto Bed Leveling"):
+			return LevelingBilinear
+		}
+	}
+	return LevelingUnknown
+}
@@ -0,0 +1,167 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	reMeshRow    = regexp.MustCompile(`^\s*(\d+)\s*\|?\s+(.*)$`)
+	reMeshCorner = regexp.MustCompile(`\(\s*(-?[0-9.]+)\s*,\s*(-?[0-9.]+)\s*\)`)
+)
+
+// Mesh is a grid of probed Z heights. Z[y][x] has row 0 at the front of the
+// bed and column 0 at the left; points that were never probed are NaN.
+type Mesh struct {
+	Z [][]float64
+	// The bed area the grid spans, when the report states it. UBL's
+	// topography report does; bilinear and manual reports do not.
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// MeshStats summarises the valid points of a mesh.
+type MeshStats struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Range  float64 `json:"range"`
+	Mean   float64 `json:"mean"`
+	Points int     `json:"points"`
+}
+
+func (m *Mesh) Rows() int {
+	return len(m.Z)
+}
+
+func (m *Mesh) Cols() int {
+	if len(m.Z) == 0 {
+		return 0
+	}
+	return len(m.Z[0])
+}
+
+// HasBounds reports whether the bed coordinates of the grid are known.
+func (m *Mesh) HasBounds() bool {
+	return m.MaxX > m.MinX && m.MaxY > m.MinY
+}
+
+func (m *Mesh) Stats() MeshStats {
+	st := MeshStats{Min: math.Inf(1), Max: math.Inf(-1)}
+	sum := 0.0
+	for _, row := range m.Z {
+		for _, z := range row {
+			if math.IsNaN(z) {
+				continue
+			}
+			st.Min = math.Min(st.Min, z)
+			st.Max = math.Max(st.Max, z)
+			sum += z
+			st.Points++
+		}
+	}
+	if st.Points == 0 {
+		return MeshStats{}
+	}
+	st.Range = st.Max - st.Min
+	st.Mean = sum / float64(st.Points)
+	return st
+}
+
+// ParseMesh reads a mesh from UBL's "G29 T" topography report or from the
+// grids "M420 V" prints for bilinear and manual mesh leveling. Every row
+// line starts with its row index, so the order the firmware prints rows in
+// does not matter.
+func ParseMesh(lines []string) (*Mesh, error) {
+	rows := map[int][]float64{}
+	maxRow, cols := -1, 0
+	var corners [][2]float64
+	for _, line := range lines {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "echo:"))
+		if strings.HasPrefix(line, "(") {
+			for _, m := range reMeshCorner.FindAllStringSubmatch(line, -1) {
+				x, _ := strconv.ParseFloat(m[1], 64)
+				y, _ := strconv.ParseFloat(m[2], 64)
+				corners = append(corners, [2]float64{x, y})
+			}
+			continue
+		}
+		m := reMeshRow.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		values, ok := parseMeshValues(m[2])
+		if !ok {
+			continue
+		}
+		row, _ := strconv.Atoi(m[1])
+		rows[row] = values
+		if row > maxRow {
+			maxRow = row
+		}
+		if len(values) > cols {
+			cols = len(values)
+		}
+	}
+	if maxRow < 0 {
+		return nil, fmt.Errorf("no mesh data in report")
+	}
+
+	mesh := &Mesh{Z: make([][]float64, maxRow+1)}
+	for y := range mesh.Z {
+		mesh.Z[y] = make([]float64, cols)
+		for x := range mesh.Z[y] {
+			mesh.Z[y][x] = math.NaN()
+		}
+		copy(mesh.Z[y], rows[y])
+	}
+	if len(corners) > 0 {
+		mesh.MinX, mesh.MinY = math.Inf(1), math.Inf(1)
+		mesh.MaxX, mesh.MaxY = math.Inf(-1), math.Inf(-1)
+		for _, c := range corners {
+			mesh.MinX, mesh.MaxX = math.Min(mesh.MinX, c[0]), math.Max(mesh.MaxX, c[0])
+			mesh.MinY, mesh.MaxY = math.Min(mesh.MinY, c[1]), math.Max(mesh.MaxY, c[1])
+		}
+	}
+	return mesh, nil
+}
+
+// parseMeshValues reads one row of heights. UBL brackets the point under
+// the nozzle and prints "." for unprobed points. Rows without a decimal
+// value, such as the column header, are rejected.
+func parseMeshValues(s string) ([]float64, bool) {
+	var values []float64
+	decimal := false
+	for _, tok := range strings.Fields(s) {
+		tok = strings.Trim(tok, "[]")
+		if tok == "." || tok == "nan" {
+			values = append(values, math.NaN())
+			continue
+		}
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, false
+		}
+		if strings.Contains(tok, ".") {
+			decimal = true
+		}
+		values = append(values, v)
+	}
+	return values, decimal
+}
+
+// ReadMesh asks the firmware for its current mesh, with "G29 T" on UBL and
+// "M420 V" otherwise.
+func (c *Client) ReadMesh(ctx context.Context, system LevelingSystem) (*Mesh, error) {
+	cmd := "M420 V"
+	if system == LevelingUBL {
+		cmd = "G29 T"
+	}
+	resp, err := c.SendAndWait(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMesh(resp.Lines)
+}
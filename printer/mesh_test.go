@@ -0,0 +1,109 @@
+package printer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseMesh(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name   string
+		lines  []string
+		want   [][]float64
+		bounds [4]float64
+	}{
+		{
+			name: "UBL topography",
+			lines: []string{
+				"Bed Topography Report:",
+				"",
+				"(  0,220)                (220,220)",
+				"           0       1       2",
+				" 2 | +0.100  +0.050  -0.020 ",
+				" 1 | +0.010  [+0.000]   .   ",
+				" 0 | -0.100  -0.050  +0.020 ",
+				"(  0,  0)                (220,  0)",
+			},
+			want: [][]float64{
+				{-0.1, -0.05, 0.02},
+				{0.01, 0, nan},
+				{0.1, 0.05, -0.02},
+			},
+			bounds: [4]float64{0, 0, 220, 220},
+		},
+		{
+			name: "bilinear grid",
+			lines: []string{
+				"Bilinear Leveling Grid:",
+				"      0      1",
+				" 0 +0.125 -0.050",
+				" 1 +0.250 +0.000",
+				"echo:Bed Leveling ON",
+			},
+			want: [][]float64{
+				{0.125, -0.05},
+				{0.25, 0},
+			},
+		},
+		{
+			name: "manual mesh with echo prefixes",
+			lines: []string{
+				"echo:Mesh Bed Leveling:",
+				"echo: 1 0.20 0.30",
+				"echo: 0 0.00 0.10",
+			},
+			want: [][]float64{
+				{0, 0.1},
+				{0.2, 0.3},
+			},
+		},
+	}
+	for _, tt := range tests {
+		mesh, err := ParseMesh(tt.lines)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !sameGrid(mesh.Z, tt.want) {
+			t.Errorf("%s: Z = %v, want %v", tt.name, mesh.Z, tt.want)
+		}
+		got := [4]float64{mesh.MinX, mesh.MinY, mesh.MaxX, mesh.MaxY}
+		if got != tt.bounds {
+			t.Errorf("%s: bounds = %v, want %v", tt.name, got, tt.bounds)
+		}
+		if mesh.HasBounds() != (tt.bounds != [4]float64{}) {
+			t.Errorf("%s: HasBounds = %v", tt.name, mesh.HasBounds())
+		}
+	}
+}
+
+func TestParseMeshWithoutData(t *testing.T) {
+	for _, lines := range [][]string{
+		nil,
+		{"echo:Mesh not valid."},
+		{"      0      1      2"},
+	} {
+		if mesh, err := ParseMesh(lines); err == nil {
+			t.Errorf("ParseMesh(%q) = %v, want an error", lines, mesh.Z)
+		}
+	}
+}
+
+func sameGrid(a, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for y := range a {
+		if len(a[y]) != len(b[y]) {
+			return false
+		}
+		for x := range a[y] {
+			if math.IsNaN(a[y][x]) != math.IsNaN(b[y][x]) ||
+				!math.IsNaN(a[y][x]) && math.Abs(a[y][x]-b[y][x]) > 1e-9 {
+				return false
+			}
+		}
+	}
+	return true
+}
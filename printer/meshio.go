@@ -0,0 +1,260 @@
+package printer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+var (
+	reSlotCount  = regexp.MustCompile(`can hold (\d+) meshes`)
+	reActiveSlot = regexp.MustCompile(`Active Mesh Slot:?\s*(-?\d+)`)
+)
+
+// MeshSlots describes UBL's mesh storage in EEPROM.
+type MeshSlots struct {
+	Count  int
+	Active int
+}
+
+var meshCSVHeader = []string{"i", "j", "x", "y", "z"}
+
+// WriteCSV writes one line per point: column and row index as M421 takes
+// them, bed coordinates when known, and the height, which is empty for
+// unprobed points.
+func (m *Mesh) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(meshCSVHeader); err != nil {
+		return err
+	}
+	for j, row := range m.Z {
+		for i, z := range row {
+			rec := []string{strconv.Itoa(i), strconv.Itoa(j), "", "", ""}
+			if x, y, ok := m.PointPosition(i, j); ok {
+				rec[2] = strconv.FormatFloat(x, 'f', 3, 64)
+				rec[3] = strconv.FormatFloat(y, 'f', 3, 64)
+			}
+			if !math.IsNaN(z) {
+				rec[4] = strconv.FormatFloat(z, 'f', 4, 64)
+			}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadMeshCSV reads a mesh written by WriteCSV.
+func ReadMeshCSV(r io.Reader) (*Mesh, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("mesh CSV has no points")
+	}
+	type point struct {
+		i, j int
+		x, y float64
+		z    float64
+		pos  bool
+	}
+	var points []point
+	cols, rows := 0, 0
+	for n, rec := range records[1:] {
+		if len(rec) != len(meshCSVHeader) {
+			return nil, fmt.Errorf("line %d: expected %d fields, got %d", n+2, len(meshCSVHeader), len(rec))
+		}
+		p := point{z: math.NaN()}
+		if p.i, err = strconv.Atoi(rec[0]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+2, err)
+		}
+		if p.j, err = strconv.Atoi(rec[1]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+2, err)
+		}
+		if rec[2] != "" && rec[3] != "" {
+			p.x, _ = strconv.ParseFloat(rec[2], 64)
+			p.y, _ = strconv.ParseFloat(rec[3], 64)
+			p.pos = true
+		}
+		if rec[4] != "" {
+			if p.z, err = strconv.ParseFloat(rec[4], 64); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+2, err)
+			}
+		}
+		if p.i < 0 || p.j < 0 {
+			return nil, fmt.Errorf("line %d: negative index", n+2)
+		}
+		cols, rows = max(cols, p.i+1), max(rows, p.j+1)
+		points = append(points, p)
+	}
+
+	mesh := newNaNMesh(cols, rows)
+	mesh.MinX, mesh.MinY = math.Inf(1), math.Inf(1)
+	mesh.MaxX, mesh.MaxY = math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		mesh.Z[p.j][p.i] = p.z
+		if p.pos {
+			mesh.MinX, mesh.MaxX = math.Min(mesh.MinX, p.x), math.Max(mesh.MaxX, p.x)
+			mesh.MinY, mesh.MaxY = math.Min(mesh.MinY, p.y), math.Max(mesh.MaxY, p.y)
+		}
+	}
+	if math.IsInf(mesh.MinX, 0) {
+		mesh.MinX, mesh.MinY, mesh.MaxX, mesh.MaxY = 0, 0, 0, 0
+	}
+	return mesh, nil
+}
+
+// meshJSON is the file form of a Mesh; unprobed points are null.
+type meshJSON struct {
+	MinX float64      `json:"min_x,omitempty"`
+	MinY float64      `json:"min_y,omitempty"`
+	MaxX float64      `json:"max_x,omitempty"`
+	MaxY float64      `json:"max_y,omitempty"`
+	Z    [][]*float64 `json:"z"`
+}
+
+func (m *Mesh) WriteJSON(w io.Writer) error {
+	out := meshJSON{MinX: m.MinX, MinY: m.MinY, MaxX: m.MaxX, MaxY: m.MaxY}
+	for _, row := range m.Z {
+		jr := make([]*float64, len(row))
+		for i, z := range row {
+			if !math.IsNaN(z) {
+				z := z
+				jr[i] = &z
+			}
+		}
+		out.Z = append(out.Z, jr)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func ReadMeshJSON(r io.Reader) (*Mesh, error) {
+	var in meshJSON
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return nil, err
+	}
+	if len(in.Z) == 0 || len(in.Z[0]) == 0 {
+		return nil, fmt.Errorf("mesh JSON has no points")
+	}
+	mesh := newNaNMesh(len(in.Z[0]), len(in.Z))
+	mesh.MinX, mesh.MinY, mesh.MaxX, mesh.MaxY = in.MinX, in.MinY, in.MaxX, in.MaxY
+	for j, row := range in.Z {
+		if len(row) != mesh.Cols() {
+			return nil, fmt.Errorf("mesh JSON row %d has %d points, want %d", j, len(row), mesh.Cols())
+		}
+		for i, z := range row {
+			if z != nil {
+				mesh.Z[j][i] = *z
+			}
+		}
+	}
+	return mesh, nil
+}
+
+// PointPosition returns the bed coordinates of grid point (i, j), if the
+// mesh bounds are known.
+func (m *Mesh) PointPosition(i, j int) (x, y float64, ok bool) {
+	if !m.HasBounds() {
+		return 0, 0, false
+	}
+	x, y = m.MinX, m.MinY
+	if m.Cols() > 1 {
+		x += (m.MaxX - m.MinX) * float64(i) / float64(m.Cols()-1)
+	}
+	if m.Rows() > 1 {
+		y += (m.MaxY - m.MinY) * float64(j) / float64(m.Rows()-1)
+	}
+	return x, y, true
+}
+
+func newNaNMesh(cols, rows int) *Mesh {
+	mesh := &Mesh{Z: make([][]float64, rows)}
+	for j := range mesh.Z {
+		mesh.Z[j] = make([]float64, cols)
+		for i := range mesh.Z[j] {
+			mesh.Z[j][i] = math.NaN()
+		}
+	}
+	return mesh
+}
+
+// WriteMesh loads mesh into the printer one M421 point at a time and turns
+// leveling on. On UBL the whole stored mesh is invalidated first so points
+// missing from the file do not keep stale values. The mesh must match the
+// firmware's grid size. It is not saved; use SaveMeshSlot on UBL or
+// SaveSettings otherwise.
+func (c *Client) WriteMesh(ctx context.Context, mesh *Mesh, system LevelingSystem) error {
+	if system == LevelingUBL {
+		if err := c.sendAll(ctx, "G29 I999"); err != nil {
+			return err
+		}
+	}
+	for j, row := range mesh.Z {
+		for i, z := range row {
+			if math.IsNaN(z) {
+				continue
+			}
+			if err := c.sendAll(ctx, fmt.Sprintf("M421 I%d J%d Z%.4f", i, j, z)); err != nil {
+				return err
+			}
+		}
+	}
+	return activateLeveling(ctx, c)
+}
+
+// SaveMeshSlot stores the active UBL mesh in EEPROM slot n.
+func (c *Client) SaveMeshSlot(ctx context.Context, n int) error {
+	cmd := fmt.Sprintf("G29 S%d", n)
+	resp, err := c.SendAndWait(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	if resp.Find("Mesh saved") == "" {
+		return fmt.Errorf("firmware did not confirm saving the mesh in slot %d", n)
+	}
+	return nil
+}
+
+// LoadMeshSlot makes the UBL mesh in EEPROM slot n the active one.
+func (c *Client) LoadMeshSlot(ctx context.Context, n int) error {
+	cmd := fmt.Sprintf("G29 L%d", n)
+	resp, err := c.SendAndWait(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	if resp.Find("Mesh loaded") == "" {
+		return fmt.Errorf("firmware did not load a mesh from slot %d", n)
+	}
+	return nil
+}
+
+// MeshSlots asks UBL how many meshes fit in EEPROM and which is active.
+func (c *Client) MeshSlots(ctx context.Context) (MeshSlots, error) {
+	resp, err := c.SendAndWait(ctx, "G29 W")
+	if err != nil {
+		return MeshSlots{}, err
+	}
+	slots := MeshSlots{Active: -1}
+	for _, line := range resp.Lines {
+		if m := reSlotCount.FindStringSubmatch(line); m != nil {
+			slots.Count, _ = strconv.Atoi(m[1])
+		}
+		if m := reActiveSlot.FindStringSubmatch(line); m != nil {
+			slots.Active, _ = strconv.Atoi(m[1])
+		}
+	}
+	if slots.Count == 0 {
+		return slots, fmt.Errorf("firmware did not report mesh storage")
+	}
+	return slots, nil
+}
@@ -0,0 +1,121 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BedHeater is the heater index M303 uses for the bed.
+const BedHeater = -1
+
+// PIDParams are the gains M303 reports and M301/M304 set.
+type PIDParams struct {
+	Kp, Ki, Kd float64
+}
+
+func (p PIDParams) String() string {
+	return fmt.Sprintf("Kp %.2f  Ki %.2f  Kd %.2f", p.Kp, p.Ki, p.Kd)
+}
+
+// PIDCycle is one oscillation reported while M303 runs. Ku and Tu are zero
+// for the first cycles, before Marlin has enough data to estimate them.
+type PIDCycle struct {
+	Cycle    int
+	Bias     float64
+	Min, Max float64
+	Ku, Tu   float64
+}
+
+var (
+	rePIDCycle = regexp.MustCompile(`bias:\s*(-?\d+).*?min:\s*(-?[\d.]+)\s+max:\s*(-?[\d.]+)(?:.*?Ku:\s*(-?[\d.]+)\s+Tu:\s*(-?[\d.]+))?`)
+	rePIDGains = regexp.MustCompile(`Kp:\s*(-?[\d.]+)\s+Ki:\s*(-?[\d.]+)\s+Kd:\s*(-?[\d.]+)`)
+)
+
+// PIDAutotune runs M303 on heater (an extruder index or BedHeater) at temp
+// for the given number of cycles. progress, if not nil, is called for every
+// completed cycle from the read loop and must not block. The firmware
+// adopts the result itself (U1); ApplyPID sets it explicitly.
+func (c *Client) PIDAutotune(ctx context.Context, heater int, temp float64, cycles int, progress func(PIDCycle)) (PIDParams, error) {
+	if cycles < 3 {
+		// Marlin needs at least three oscillations for an estimate.
+		cycles = 3
+	}
+	n := 0
+	cmd := fmt.Sprintf("M303 E%d S%.0f C%d U1", heater, temp, cycles)
+	resp, err := c.SendAndStream(ctx, cmd, func(line string) {
+		cycle, ok := parsePIDCycle(line)
+		if !ok {
+			return
+		}
+		n++
+		cycle.Cycle = n
+		if progress != nil {
+			progress(cycle)
+		}
+	})
+	if err != nil {
+		return PIDParams{}, err
+	}
+	return parsePIDResult(resp.Lines)
+}
+
+// ApplyPID sets the gains of heater without storing them.
+func (c *Client) ApplyPID(ctx context.Context, heater int, pid PIDParams) error {
+	if heater == BedHeater {
+		return c.sendAll(ctx, fmt.Sprintf("M304 P%.2f I%.2f D%.2f", pid.Kp, pid.Ki, pid.Kd))
+	}
+	return c.sendAll(ctx, fmt.Sprintf("M301 E%d P%.2f I%.2f D%.2f", heater, pid.Kp, pid.Ki, pid.Kd))
+}
+
+func parsePIDCycle(line string) (PIDCycle, bool) {
+	m := rePIDCycle.FindStringSubmatch(line)
+	if m == nil {
+		return PIDCycle{}, false
+	}
+	var cycle PIDCycle
+	cycle.Bias, _ = strconv.ParseFloat(m[1], 64)
+	cycle.Min, _ = strconv.ParseFloat(m[2], 64)
+	cycle.Max, _ = strconv.ParseFloat(m[3], 64)
+	if m[4] != "" {
+		cycle.Ku, _ = strconv.ParseFloat(m[4], 64)
+		cycle.Tu, _ = strconv.ParseFloat(m[5], 64)
+	}
+	return cycle, true
+}
+
+// parsePIDResult takes the last "Kp: Ki: Kd:" line of an M303 reply, or the
+// "#define DEFAULT_Kp" block older firmware prints instead.
+func parsePIDResult(lines []string) (PIDParams, error) {
+	var pid PIDParams
+	found := false
+	defines := map[string]*float64{"Kp": &pid.Kp, "Ki": &pid.Ki, "Kd": &pid.Kd}
+	for _, line := range lines {
+		if strings.Contains(line, "Autotune failed") {
+			return PIDParams{}, &FirmwareError{Message: strings.TrimSpace(line)}
+		}
+		if m := rePIDGains.FindStringSubmatch(line); m != nil {
+			pid.Kp, _ = strconv.ParseFloat(m[1], 64)
+			pid.Ki, _ = strconv.ParseFloat(m[2], 64)
+			pid.Kd, _ = strconv.ParseFloat(m[3], 64)
+			found = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "#define" {
+			name := strings.TrimPrefix(strings.TrimPrefix(fields[1], "DEFAULT_bed"), "DEFAULT_")
+			if dst, ok := defines[name]; ok {
+				if v, err := strconv.ParseFloat(fields[2], 64); err == nil {
+					*dst = v
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		return PIDParams{}, fmt.Errorf("no PID result in M303 reply")
+	}
+	return pid, nil
+}
@@ -0,0 +1,177 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Position is the logical position of the nozzle in mm.
+type Position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+	E float64 `json:"e"`
+}
+
+// rePosition matches the logical part of an M114 reply or M154 report,
+// before "Count". It is anchored so probe results such as "Bed X: 110.00
+// Y: 110.00 Z: 0.01" are not taken for positions.
+var rePosition = regexp.MustCompile(`^X:\s*(-?[\d.]+)\s+Y:\s*(-?[\d.]+)\s+Z:\s*(-?[\d.]+)(?:\s+E:\s*(-?[\d.]+))?`)
+
+// ReadPosition asks for the current position with M114.
+func (c *Client) ReadPosition(ctx context.Context) (Position, error) {
+	resp, err := c.SendAndWait(ctx, "M114")
+	if err != nil {
+		return Position{}, fmt.Errorf("M114: %w", err)
+	}
+	for _, line := range resp.Lines {
+		if pos, ok := parsePosition(line); ok {
+			return pos, nil
+		}
+	}
+	return Position{}, fmt.Errorf("no position in M114 reply")
+}
+
+// AddPositionListener registers f for every position the printer reports:
+// M114 replies, whoever asked for them, and M154 auto-reports. f runs on
+// the read loop.
+func (c *Client) AddPositionListener(f func(Position)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.posListeners = append(c.posListeners, f)
+}
+
+// RequestPosition sends M114 without waiting; the reply reaches position
+// listeners.
+func (c *Client) RequestPosition() error {
+	return c.SendRaw("M114")
+}
+
+// StartPositionReports has the firmware report its position every interval
+// seconds with M154, where AUTOREPORT_POS is supported.
+func (c *Client) StartPositionReports(interval int) error {
+	if !c.Capabilities().Supports(CapAutoreportPos) {
+		return fmt.Errorf("firmware has no position auto-report (M154)")
+	}
+	return c.SendRaw(fmt.Sprintf("M154 S%d", max(interval, 1)))
+}
+
+func (c *Client) StopPositionReports() error {
+	return c.SendRaw("M154 S0")
+}
+
+// Axes lists the axes Move and Home accept, in the order they are written.
+const Axes = "XYZE"
+
+// Move makes one G1 move to the given axis values, or by them when relative
+// is set. feedrate is in mm/min; zero keeps the last one. It returns once
+// the firmware has queued the move, not when it is done.
+func (c *Client) Move(ctx context.Context, relative bool, feedrate float64, axes map[string]float64) error {
+	cmd := "G1"
+	for _, axis := range strings.Split(Axes, "") {
+		if v, ok := axes[axis]; ok {
+			cmd += fmt.Sprintf(" %s%.3f", axis, v)
+		}
+	}
+	if cmd == "G1" {
+		return fmt.Errorf("no axes to move")
+	}
+	if feedrate > 0 {
+		cmd += fmt.Sprintf(" F%.0f", feedrate)
+	}
+	mode := "G90"
+	if relative {
+		mode = "G91"
+	}
+	if err := c.sendAll(ctx, mode); err != nil {
+		return err
+	}
+	resp, err := c.SendAndWait(ctx, cmd)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", cmd, err)
+	}
+	if relative {
+		// Everything else here assumes absolute positioning, so G90 is
+		// restored even if the move failed or ctx is done.
+		rctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		rerr := c.sendAll(rctx, "G90")
+		cancel()
+		if rerr != nil {
+			if err != nil {
+				return fmt.Errorf("%w; restoring absolute positioning: %w", err, rerr)
+			}
+			return fmt.Errorf("restoring absolute positioning: %w", rerr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Find("cold extrusion prevented") != "" {
+		return fmt.Errorf("hotend too cold to extrude")
+	}
+	return nil
+}
+
+// Jog moves one axis by distance mm from wherever it is.
+func (c *Client) Jog(ctx context.Context, axis string, distance, feedrate float64) error {
+	return c.Move(ctx, true, feedrate, map[string]float64{axis: distance})
+}
+
+// Home homes the given axes, such as "XY", or all of them when axes is
+// empty, and returns once homing is done.
+func (c *Client) Home(ctx context.Context, axes string) error {
+	cmd := "G28"
+	for _, axis := range strings.ToUpper(axes) {
+		if !strings.ContainsRune("XYZ", axis) {
+			return fmt.Errorf("cannot home axis %c", axis)
+		}
+		cmd += " " + string(axis)
+	}
+	return c.sendAll(ctx, cmd)
+}
+
+func (c *Client) consumePositionLine(line string) {
+	c.mu.Lock()
+	listeners := append([]func(Position){}, c.posListeners...)
+	c.mu.Unlock()
+	if len(listeners) == 0 {
+		return
+	}
+	pos, ok := parsePosition(line)
+	if !ok {
+		return
+	}
+	for _, f := range listeners {
+		f(pos)
+	}
+}
+
+// isPositionReport reports whether line, received while cmd is in flight,
+// is an M154 auto-report rather than part of cmd's reply. Only M114 asks
+// for a position, so for anything else it can only be a report.
+func isPositionReport(cmd, line string) bool {
+	if !rePosition.MatchString(strings.TrimSpace(line)) {
+		return false
+	}
+	fields := strings.Fields(cmd)
+	return len(fields) == 0 || !strings.EqualFold(fields[0], "M114")
+}
+
+func parsePosition(line string) (Position, bool) {
+	m := rePosition.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return Position{}, false
+	}
+	var pos Position
+	pos.X, _ = strconv.ParseFloat(m[1], 64)
+	pos.Y, _ = strconv.ParseFloat(m[2], 64)
+	pos.Z, _ = strconv.ParseFloat(m[3], 64)
+	if m[4] != "" {
+		pos.E, _ = strconv.ParseFloat(m[4], 64)
+	}
+	return pos, true
+}
@@ -0,0 +1,67 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// ProbeOffset is the nozzle-to-probe offset set by M851.
+type ProbeOffset struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+var reProbeOffset = regexp.MustCompile(`(?:Probe Offset|M851)\s+X:?\s*(-?[\d.]+)\s+Y:?\s*(-?[\d.]+)\s+Z:?\s*(-?[\d.]+)`)
+
+// ReadProbeOffset asks the firmware for its current probe offset (M851
+// without arguments).
+func (c *Client) ReadProbeOffset(ctx context.Context) (ProbeOffset, error) {
+	resp, err := c.SendAndWait(ctx, "M851")
+	if err != nil {
+		return ProbeOffset{}, fmt.Errorf("M851: %w", err)
+	}
+	for _, line := range resp.Lines {
+		if off, ok := parseProbeOffset(line); ok {
+			return off, nil
+		}
+	}
+	return ProbeOffset{}, fmt.Errorf("no probe offset in M851 reply")
+}
+
+func parseProbeOffset(line string) (ProbeOffset, bool) {
+	m := reProbeOffset.FindStringSubmatch(line)
+	if m == nil {
+		return ProbeOffset{}, false
+	}
+	var off ProbeOffset
+	off.X, _ = strconv.ParseFloat(m[1], 64)
+	off.Y, _ = strconv.ParseFloat(m[2], 64)
+	off.Z, _ = strconv.ParseFloat(m[3], 64)
+	return off, true
+}
+
+// ZOffsetFromTouch returns the probe Z offset that puts the nozzle on the
+// bed at Z0, given the offset the printer was homed with and the Z it
+// reported with the nozzle touching the bed.
+func ZOffsetFromTouch(old ProbeOffset, touchZ float64) float64 {
+	return old.Z + touchZ
+}
+
+// ApplyProbeXYOffset sets the probe's X and Y offset from the nozzle,
+// leaving Z alone. It is not saved; call SaveSettings.
+func (c *Client) ApplyProbeXYOffset(ctx context.Context, x, y float64) error {
+	return c.sendAll(ctx, fmt.Sprintf("M851 X%.2f Y%.2f", x, y))
+}
+
+// DeployProbe lowers a BLTouch-style probe pin (M401); StowProbe raises it
+// again (M402).
+func (c *Client) DeployProbe(ctx context.Context) error {
+	return c.sendAll(ctx, "M401")
+}
+
+func (c *Client) StowProbe(ctx context.Context) error {
+	return c.sendAll(ctx, "M402")
+}
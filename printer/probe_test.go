@@ -0,0 +1,28 @@
+package printer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestZOffsetFromTouch(t *testing.T) {
+	tests := []struct {
+		old    float64
+		touchZ float64
+		want   float64
+	}{
+		// Touching at Z0 means the old offset was right.
+		{-1.5, 0, -1.5},
+		// Touching above Z0: the nozzle sat too high, so the offset rises.
+		{-1.5, 0.3, -1.2},
+		// Touching below Z0, as soft endstops are off for the procedure.
+		{-1.5, -0.25, -1.75},
+		{0, -2.1, -2.1},
+	}
+	for _, tt := range tests {
+		got := ZOffsetFromTouch(ProbeOffset{X: -40, Y: -10, Z: tt.old}, tt.touchZ)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("ZOffsetFromTouch(%g, %g) = %g, want %g", tt.old, tt.touchZ, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package printer
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// resendHistory is how many framed lines are kept for retransmission.
+const resendHistory = 256
+
+var (
+	reResend  = regexp.MustCompile(`(?i)^(?:resend|rs)[:\s]\s*N?(\d+)`)
+	reM110Arg = regexp.MustCompile(`\bN(\d+)`)
+)
+
+// lineWriter serialises writes to the port. With checksums enabled every
+// line is sent as "N<line> <cmd>*<checksum>" and remembered so it can be
+// retransmitted when the firmware asks for it.
+type lineWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	checksums bool
+	next      int
+	history   map[int]string
+
+	// A single corrupted line makes the firmware reject everything sent
+	// after it too, each with its own resend request for the same line.
+	// Only the first of those triggers a retransmission.
+	resendFrom  int
+	resendDupes int
+}
+
+func newLineWriter(w io.Writer, checksums bool) *lineWriter {
+	return &lineWriter{
+		w:         w,
+		checksums: checksums,
+		next:      1,
+		history:   make(map[int]string),
+	}
+}
+
+func (lw *lineWriter) write(line string) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if !lw.checksums {
+		_, err := io.WriteString(lw.w, line+"\n")
+		return err
+	}
+
+	n := lw.next
+	if strings.HasPrefix(line, "M110") {
+		if m := reM110Arg.FindStringSubmatch(line); m != nil {
+			n, _ = strconv.Atoi(m[1])
+		}
+	}
+	framed := frameLine(n, line)
+	lw.history[n] = framed
+	delete(lw.history, n-resendHistory)
+	lw.next = n + 1
+	_, err := io.WriteString(lw.w, framed+"\n")
+	return err
+}
+
+// resend retransmits every line from n onwards.
+func (lw *lineWriter) resend(n int) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if !lw.checksums || n >= lw.next {
+		return nil
+	}
+	if n == lw.resendFrom && lw.resendDupes > 0 {
+		lw.resendDupes--
+		return nil
+	}
+	if _, ok := lw.history[n]; !ok {
+		return fmt.Errorf("line %d is no longer in the resend history", n)
+	}
+	lw.resendFrom = n
+	lw.resendDupes = lw.next - 1 - n
+	for i := n; i < lw.next; i++ {
+		if _, err := io.WriteString(lw.w, lw.history[i]+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset matches a freshly booted firmware, which expects line 1 next.
+func (lw *lineWriter) reset() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.next = 1
+	lw.history = make(map[int]string)
+	lw.resendDupes = 0
+}
+
+func frameLine(n int, cmd string) string {
+	body := fmt.Sprintf("N%d %s", n, cmd)
+	return fmt.Sprintf("%s*%d", body, checksum(body))
+}
+
+// checksum is Marlin's XOR of every byte before the '*'.
+func checksum(s string) byte {
+	var cs byte
+	for i := 0; i < len(s); i++ {
+		cs ^= s[i]
+	}
+	return cs
+}
+
+func parseResendRequest(line string) (int, bool) {
+	m := reResend.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
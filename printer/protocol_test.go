@@ -0,0 +1,193 @@
+package printer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nulldozer/printer-calibration-utility/simulator"
+)
+
+func TestFrameLine(t *testing.T) {
+	tests := []struct {
+		n    int
+		cmd  string
+		want string
+	}{
+		{0, "M110 N0", "N0 M110 N0*125"},
+		{1, "M105", "N1 M105*38"},
+		{12, "G28 X0 Y0", "N12 G28 X0 Y0*33"},
+	}
+	for _, tt := range tests {
+		if got := frameLine(tt.n, tt.cmd); got != tt.want {
+			t.Errorf("frameLine(%d, %q) = %q, want %q", tt.n, tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestParseResendRequest(t *testing.T) {
+	tests := []struct {
+		line string
+		n    int
+		ok   bool
+	}{
+		{"Resend: 5", 5, true},
+		{"Resend:3", 3, true},
+		{"resend: N17", 17, true},
+		{"rs N12", 12, true},
+		{"RS:7", 7, true},
+		{"ok", 0, false},
+		{"Error:checksum mismatch, Last Line: 4", 0, false},
+		{"Resend: x", 0, false},
+	}
+	for _, tt := range tests {
+		n, ok := parseResendRequest(tt.line)
+		if n != tt.n || ok != tt.ok {
+			t.Errorf("parseResendRequest(%q) = %d, %v; want %d, %v", tt.line, n, ok, tt.n, tt.ok)
+		}
+	}
+}
+
+func TestLineWriterResend(t *testing.T) {
+	var out strings.Builder
+	lw := newLineWriter(&out, true)
+	for _, cmd := range []string{"M110 N0", "G28", "M105", "M114"} {
+		if err := lw.write(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := []string{"N0 M110 N0*125", frameLine(1, "G28"), frameLine(2, "M105"), frameLine(3, "M114")}
+	if want := strings.Join(sent, "\n") + "\n"; out.String() != want {
+		t.Fatalf("wrote %q, want %q", out.String(), want)
+	}
+
+	tests := []struct {
+		name string
+		n    int
+		want []string
+		err  bool
+	}{
+		// A bad line 2 makes the firmware reject line 3 as well, each
+		// with a request for line 2; only the first retransmits.
+		{name: "first request", n: 2, want: sent[2:]},
+		{name: "duplicate", n: 2},
+		{name: "retransmission rejected too", n: 2, want: sent[2:]},
+		{name: "not sent yet", n: 4},
+		{name: "new request", n: 1, want: sent[1:]},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		err := lw.resend(tt.n)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: resend(%d) = %v", tt.name, tt.n, err)
+		}
+		want := ""
+		for _, line := range tt.want {
+			want += line + "\n"
+		}
+		if out.String() != want {
+			t.Errorf("%s: resend(%d) wrote %q, want %q", tt.name, tt.n, out.String(), want)
+		}
+	}
+}
+
+func TestLineWriterResendOutOfHistory(t *testing.T) {
+	var out strings.Builder
+	lw := newLineWriter(&out, true)
+	for i := 0; i < resendHistory+10; i++ {
+		if err := lw.write("M105"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lw.resend(1); err == nil {
+		t.Error("resend of a line dropped from the history succeeded")
+	}
+}
+
+func TestLineWriterPlain(t *testing.T) {
+	var out strings.Builder
+	lw := newLineWriter(&out, false)
+	if err := lw.write("M105"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lw.resend(1); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "M105\n" {
+		t.Errorf("wrote %q, want plain lines and no resends", out.String())
+	}
+}
+
+// corruptOnce garbles the first line from the host that contains from, so
+// the firmware sees a checksum that does not match. Unlike a serial port,
+// net.Pipe has no buffer, so the printer's writes are queued; otherwise the
+// resend the client writes from its read loop would wait on the printer
+// while the printer waits to print its "ok".
+type corruptOnce struct {
+	io.ReadWriteCloser
+	from, to []byte
+	done     atomic.Bool
+	out      chan []byte
+}
+
+func newCorruptOnce(rw io.ReadWriteCloser, from, to string) *corruptOnce {
+	c := &corruptOnce{ReadWriteCloser: rw, from: []byte(from), to: []byte(to), out: make(chan []byte, 64)}
+	go func() {
+		for b := range c.out {
+			if _, err := rw.Write(b); err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *corruptOnce) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if i := bytes.Index(p[:n], c.from); i >= 0 && !c.done.Load() {
+		copy(p[i:], c.to)
+		c.done.Store(true)
+	}
+	return n, err
+}
+
+func (c *corruptOnce) Write(p []byte) (int, error) {
+	c.out <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestResendAfterBadChecksum(t *testing.T) {
+	tr, device := NewPipe()
+	corrupt := newCorruptOnce(device, "M114", "M115")
+	go simulator.New().Serve(corrupt)
+	c := NewClient()
+	c.SetChecksums(true)
+	if err := c.ConnectTransport(tr); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := c.SendAndWait(ctx, "M114")
+	if err != nil {
+		t.Fatalf("M114: %v", err)
+	}
+	if !corrupt.done.Load() {
+		t.Fatal("the line was never corrupted")
+	}
+	if resp.Find("X:") == "" {
+		t.Errorf("M114 reply has no position after the resend: %q", resp.Lines)
+	}
+	if resp.Find("FIRMWARE_NAME") != "" {
+		t.Errorf("the corrupted line was run: %q", resp.Lines)
+	}
+	// The line numbers must still agree afterwards.
+	if _, err := c.SendAndWait(ctx, "M400"); err != nil {
+		t.Errorf("M400 after the resend: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package printer
+
+import (
+	"errors"
+	"sync"
+)
+
+// DefaultCommandWindow is the number of commands allowed in flight before the
+// client waits for an "ok". One is safe on every board; raise it towards the
+// firmware's BUFSIZE for faster streaming.
+const DefaultCommandWindow = 1
+
+var (
+	ErrNotConnected = errors.New("not connected")
+	ErrDisconnected = errors.New("disconnected")
+	ErrPrinterReset = errors.New("printer reset")
+)
+
+type command struct {
+	line   string
+	lines  []string
+	onLine func(string)
+	urgent bool
+	done   chan struct{}
+	err    error
+	// finished is guarded by the queue's mutex. Once it is set, lines and
+	// err no longer change and may be read without the lock.
+	finished bool
+}
+
+// finish completes the command; only the first call has any effect. The
+// caller holds the queue's mutex.
+func (cmd *command) finish(err error) {
+	if cmd.finished {
+		return
+	}
+	cmd.finished = true
+	cmd.err = err
+	close(cmd.done)
+}
+
+// commandQueue holds commands waiting to be written and commands written but
+// not yet acknowledged. The firmware answers every line with exactly one "ok"
+// in the order received, so acknowledgements always complete the oldest
+// in-flight command.
+type commandQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	window   int
+	pending  []*command
+	inflight []*command
+	closed   bool
+	skip     int
+}
+
+func newCommandQueue(window int) *commandQueue {
+	if window < 1 {
+		window = 1
+	}
+	q := &commandQueue{window: window}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a command. Urgent commands go ahead of every other command not
+// yet written, behind earlier urgent ones, so they are not held up by a
+// long stream such as a print.
+func (q *commandQueue) push(line string, onLine func(string), urgent bool) (*command, error) {
+	cmd := &command{line: line, onLine: onLine, urgent: urgent, done: make(chan struct{})}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrDisconnected
+	}
+	i := len(q.pending)
+	if urgent {
+		i = 0
+		for i < len(q.pending) && q.pending[i].urgent {
+			i++
+		}
+	}
+	q.pending = append(q.pending, nil)
+	copy(q.pending[i+1:], q.pending[i:])
+	q.pending[i] = cmd
+	q.cond.Broadcast()
+	return cmd, nil
+}
+
+// next blocks until a pending command may be written and moves it in flight.
+// It returns false once the queue is closed.
+func (q *commandQueue) next() (*command, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.closed && (len(q.pending) == 0 || len(q.inflight) >= q.window) {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	cmd := q.pending[0]
+	q.pending = q.pending[1:]
+	q.inflight = append(q.inflight, cmd)
+	return cmd, true
+}
+
+func (q *commandQueue) ack() {
+	q.mu.Lock()
+	if q.skip > 0 {
+		q.skip--
+		q.mu.Unlock()
+		return
+	}
+	if len(q.inflight) == 0 {
+		q.mu.Unlock()
+		return
+	}
+	cmd := q.inflight[0]
+	q.inflight = q.inflight[1:]
+	cmd.finish(nil)
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// collect attributes a reply line to the oldest in-flight command. Lines
+// arriving after a failed command's error are dropped.
+func (q *commandQueue) collect(line string) {
+	q.mu.Lock()
+	if len(q.inflight) == 0 || q.inflight[0].finished {
+		q.mu.Unlock()
+		return
+	}
+	cmd := q.inflight[0]
+	if isPositionReport(cmd.line, line) {
+		q.mu.Unlock()
+		return
+	}
+	cmd.lines = append(cmd.lines, line)
+	q.mu.Unlock()
+	if cmd.onLine != nil {
+		cmd.onLine(line)
+	}
+}
+
+// failHead completes the oldest in-flight command with err but keeps it in
+// flight so the "ok" the firmware may still send is not credited to the
+// next command.
+func (q *commandQueue) failHead(err error) {
+	q.mu.Lock()
+	if len(q.inflight) == 0 {
+		q.mu.Unlock()
+		return
+	}
+	q.inflight[0].finish(err)
+	q.mu.Unlock()
+}
+
+// withdraw removes cmd if it has not been written yet.
+func (q *commandQueue) withdraw(cmd *command) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, c := range q.pending {
+		if c == cmd {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// skipAck ignores the next "ok", which belongs to a resend request rather
+// than to an in-flight command.
+func (q *commandQueue) skipAck() {
+	q.mu.Lock()
+	q.skip++
+	q.mu.Unlock()
+}
+
+// fail drops an in-flight command that never reached the printer.
+func (q *commandQueue) fail(cmd *command, err error) {
+	q.mu.Lock()
+	for i, c := range q.inflight {
+		if c == cmd {
+			q.inflight = append(q.inflight[:i], q.inflight[i+1:]...)
+			break
+		}
+	}
+	cmd.finish(err)
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// reset fails every in-flight command, e.g. after the firmware rebooted and
+// will never acknowledge them. Pending commands are kept.
+func (q *commandQueue) reset(err error) {
+	q.mu.Lock()
+	for _, cmd := range q.inflight {
+		cmd.finish(err)
+	}
+	q.inflight = nil
+	q.skip = 0
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+func (q *commandQueue) close(err error) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	for _, cmd := range append(q.inflight, q.pending...) {
+		cmd.finish(err)
+	}
+	q.inflight = nil
+	q.pending = nil
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+func (q *commandQueue) setWindow(n int) {
+	if n < 1 {
+		n = 1
+	}
+	q.mu.Lock()
+	q.window = n
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
@@ -0,0 +1,126 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	reM48Sample = regexp.MustCompile(`(\d+) of (\d+):\s*z:\s*(-?[\d.]+)`)
+	reM48Field  = regexp.MustCompile(`(Mean|Min|Max|Range|Standard Deviation):\s*(-?[\d.]+)`)
+)
+
+// Repeatability is the outcome of an M48 probe repeatability test.
+type Repeatability struct {
+	Samples []float64
+	Mean    float64
+	StdDev  float64
+	Min     float64
+	Max     float64
+	Range   float64
+}
+
+// Within reports whether the probe's standard deviation is at most tol mm.
+func (r *Repeatability) Within(tol float64) bool {
+	return r.StdDev <= tol
+}
+
+// ProbeRepeatability probes the same spot samples times (4 to 50) with
+// "M48 P<n> X Y V4". progress, if not nil, receives each sample as it is
+// reported, from the read loop.
+func (c *Client) ProbeRepeatability(ctx context.Context, samples int, x, y float64, progress func(n int, z float64)) (*Repeatability, error) {
+	if samples < 4 || samples > 50 {
+		return nil, fmt.Errorf("sample count must be 4 to 50, not %d", samples)
+	}
+	cmd := fmt.Sprintf("M48 P%d X%.1f Y%.1f V4", samples, x, y)
+	resp, err := c.SendAndStream(ctx, cmd, func(line string) {
+		if progress == nil {
+			return
+		}
+		if n, z, ok := parseM48Sample(line); ok {
+			progress(n, z)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("M48: %w", err)
+	}
+	if resp.Find("Unknown command") != "" {
+		return nil, fmt.Errorf("firmware has no probe repeatability test (M48)")
+	}
+	return parseM48(resp.Lines)
+}
+
+func parseM48Sample(line string) (int, float64, bool) {
+	m := reM48Sample.FindStringSubmatch(line)
+	if m == nil {
+		return 0, 0, false
+	}
+	n, _ := strconv.Atoi(m[1])
+	z, err := strconv.ParseFloat(m[3], 64)
+	return n, z, err == nil
+}
+
+// parseM48 reads the samples and the summary Marlin prints after
+// "Finished!". The per-sample lines carry running statistics with the same
+// labels, so only lines after it count for the summary. Without one, the
+// statistics are computed from the samples.
+func parseM48(lines []string) (*Repeatability, error) {
+	r := &Repeatability{}
+	finished, summary := false, false
+	for _, line := range lines {
+		if _, z, ok := parseM48Sample(line); ok {
+			r.Samples = append(r.Samples, z)
+			continue
+		}
+		if strings.Contains(line, "Finished") {
+			finished = true
+			continue
+		}
+		if !finished {
+			continue
+		}
+		for _, m := range reM48Field.FindAllStringSubmatch(line, -1) {
+			v, _ := strconv.ParseFloat(m[2], 64)
+			summary = true
+			switch m[1] {
+			case "Mean":
+				r.Mean = v
+			case "Min":
+				r.Min = v
+			case "Max":
+				r.Max = v
+			case "Range":
+				r.Range = v
+			case "Standard Deviation":
+				r.StdDev = v
+			}
+		}
+	}
+	if len(r.Samples) == 0 {
+		return nil, fmt.Errorf("no samples in M48 report")
+	}
+	if !summary {
+		r.computeStats()
+	}
+	return r, nil
+}
+
+func (r *Repeatability) computeStats() {
+	r.Min, r.Max = math.Inf(1), math.Inf(-1)
+	sum := 0.0
+	for _, z := range r.Samples {
+		sum += z
+		r.Min, r.Max = math.Min(r.Min, z), math.Max(r.Max, z)
+	}
+	r.Mean = sum / float64(len(r.Samples))
+	dev := 0.0
+	for _, z := range r.Samples {
+		dev += (z - r.Mean) * (z - r.Mean)
+	}
+	r.StdDev = math.Sqrt(dev / float64(len(r.Samples)))
+	r.Range = r.Max - r.Min
+}
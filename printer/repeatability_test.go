@@ -0,0 +1,83 @@
+package printer
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseM48(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  Repeatability
+	}{
+		{
+			name: "with summary",
+			lines: []string{
+				"M48 Z-Probe Repeatability Test",
+				"Positioning the probe...",
+				"1 of 4: z: 0.100 Mean: 0.100000 Sigma: 0.000000 Min: 0.100 Max: 0.100 Range: 0.000",
+				"2 of 4: z: 0.200 Mean: 0.150000 Sigma: 0.050000 Min: 0.100 Max: 0.200 Range: 0.100",
+				"3 of 4: z: 0.300 Mean: 0.200000 Sigma: 0.081650 Min: 0.100 Max: 0.300 Range: 0.200",
+				"4 of 4: z: 0.400 Mean: 0.250000 Sigma: 0.111803 Min: 0.100 Max: 0.400 Range: 0.300",
+				"Finished!",
+				"Mean: 0.250000 Min: 0.100 Max: 0.400 Range: 0.300",
+				"Standard Deviation: 0.111803",
+			},
+			want: Repeatability{
+				Samples: []float64{0.1, 0.2, 0.3, 0.4},
+				Mean:    0.25, StdDev: 0.111803, Min: 0.1, Max: 0.4, Range: 0.3,
+			},
+		},
+		{
+			name: "without summary",
+			lines: []string{
+				"1 of 4: z: -0.010",
+				"2 of 4: z: 0.010",
+				"3 of 4: z: -0.010",
+				"4 of 4: z: 0.010",
+			},
+			want: Repeatability{
+				Samples: []float64{-0.01, 0.01, -0.01, 0.01},
+				Mean:    0, StdDev: 0.01, Min: -0.01, Max: 0.01, Range: 0.02,
+			},
+		},
+	}
+	for _, tt := range tests {
+		r, err := parseM48(tt.lines)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(r.Samples, tt.want.Samples) {
+			t.Errorf("%s: samples = %v, want %v", tt.name, r.Samples, tt.want.Samples)
+		}
+		for _, f := range []struct {
+			name      string
+			got, want float64
+		}{
+			{"mean", r.Mean, tt.want.Mean},
+			{"standard deviation", r.StdDev, tt.want.StdDev},
+			{"min", r.Min, tt.want.Min},
+			{"max", r.Max, tt.want.Max},
+			{"range", r.Range, tt.want.Range},
+		} {
+			if math.Abs(f.got-f.want) > 1e-6 {
+				t.Errorf("%s: %s = %g, want %g", tt.name, f.name, f.got, f.want)
+			}
+		}
+	}
+}
+
+func TestParseM48WithoutSamples(t *testing.T) {
+	for _, lines := range [][]string{
+		nil,
+		{"?Sample size not plausible (4-50)."},
+		{"Finished!", "Mean: 0.250000 Min: 0.100 Max: 0.400 Range: 0.300"},
+	} {
+		if r, err := parseM48(lines); err == nil {
+			t.Errorf("parseM48(%q) = %+v, want an error", lines, r)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Response is everything the firmware printed while processing a command.
+type Response struct {
+	Command string
+	// Lines holds the replies in order, excluding the final "ok" and
+	// temperature or position auto-reports and busy keepalives that
+	// happened to arrive meanwhile.
+	Lines []string
+}
+
+// Find returns the first line containing substr, or "" if there is none.
+func (r *Response) Find(substr string) string {
+	for _, line := range r.Lines {
+		if strings.Contains(line, substr) {
+			return line
+		}
+	}
+	return ""
+}
+
+// FirmwareError is returned when the printer answers a command with
+// "Error:".
+type FirmwareError struct {
+	Message string
+}
+
+func (e *FirmwareError) Error() string {
+	return "printer error: " + e.Message
+}
+
+// SendAndWait sends cmd and blocks until the firmware acknowledges it,
+// reports an error, or ctx is done. A command that has not been written yet
+// when ctx ends is withdrawn from the queue.
+func (c *Client) SendAndWait(ctx context.Context, cmd string) (*Response, error) {
+	return c.SendAndStream(ctx, cmd, nil)
+}
+
+// SendAndStream is SendAndWait for long-running commands that report
+// progress: onLine, if not nil, receives each reply line as it arrives.
+// It is called from the read loop and must not block.
+func (c *Client) SendAndStream(ctx context.Context, cmd string, onLine func(string)) (*Response, error) {
+	pc, err := c.enqueue(cmd, onLine, false)
+	if err != nil {
+		return nil, err
+	}
+	if pc == nil {
+		return nil, fmt.Errorf("empty command")
+	}
+	return c.await(ctx, pc)
+}
+
+// await blocks until pc is done or ctx is, withdrawing pc in the latter case
+// if it has not been written.
+func (c *Client) await(ctx context.Context, pc *command) (*Response, error) {
+	select {
+	case <-pc.done:
+		return &Response{Command: pc.line, Lines: pc.lines}, pc.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+		if conn != nil {
+			conn.queue.withdraw(pc)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// sendAll runs cmds one after another, stopping at the first failure.
+func (c *Client) sendAll(ctx context.Context, cmds ...string) error {
+	for _, cmd := range cmds {
+		if _, err := c.SendAndWait(ctx, cmd); err != nil {
+			return fmt.Errorf("%s: %w", cmd, err)
+		}
+	}
+	return nil
+}
+
+// isReplyNoise reports lines that are not part of any command's reply.
+func isReplyNoise(line string) bool {
+	return strings.HasPrefix(line, "T:") ||
+		strings.HasPrefix(line, "B:") ||
+		strings.Contains(line, "busy:")
+}
+
+// isProtocolError reports whether an "Error:" line is about line framing,
+// which the resend handling recovers from, rather than the command itself.
+func isProtocolError(line string) bool {
+	for _, s := range []string{"checksum mismatch", "Line Number is not", "No Checksum", "No Line Number"} {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
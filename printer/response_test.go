@@ -0,0 +1,152 @@
+package printer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFirmware answers commands the way Marlin does, with canned replies,
+// and records every command it receives.
+type fakeFirmware struct {
+	mu       sync.Mutex
+	received []string
+}
+
+func (f *fakeFirmware) serve(conn io.ReadWriter) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Strip "N<line> " and "*<checksum>" framing.
+		if strings.HasPrefix(line, "N") {
+			if sp := strings.IndexByte(line, ' '); sp >= 0 {
+				line = line[sp+1:]
+			}
+			if star := strings.LastIndexByte(line, '*'); star >= 0 {
+				line = line[:star]
+			}
+		}
+		f.mu.Lock()
+		f.received = append(f.received, line)
+		f.mu.Unlock()
+		var reply []string
+		switch strings.Fields(line)[0] {
+		case "M115":
+			reply = []string{"FIRMWARE_NAME:Marlin 2.1.2", "Cap:EEPROM:1"}
+		case "M114":
+			reply = []string{"X:0.00 Y:0.00 Z:10.00 E:0.00 Count X:0 Y:0 Z:4000"}
+		case "M421":
+			reply = []string{"Error:M421 incorrect parameter usage."}
+		case "G4":
+			time.Sleep(300 * time.Millisecond)
+		}
+		for _, r := range reply {
+			fmt.Fprintf(conn, "%s\n", r)
+		}
+		fmt.Fprintf(conn, "T:21.0 /0.0 B:20.5 /0.0 @:0 B@:0\nok\n")
+	}
+}
+
+func (f *fakeFirmware) saw(cmd string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, line := range f.received {
+		if line == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// connectFake connects a client to a fakeFirmware over an in-memory pipe.
+func connectFake(t *testing.T, checksums bool) (*Client, *fakeFirmware) {
+	t.Helper()
+	tr, device := NewPipe()
+	fw := &fakeFirmware{}
+	go fw.serve(device)
+	c := NewClient()
+	c.SetChecksums(checksums)
+	if err := c.ConnectTransport(tr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = c.Disconnect()
+	})
+	return c, fw
+}
+
+func TestSendAndWait(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		find string
+		err  bool
+	}{
+		{cmd: "M115", find: "FIRMWARE_NAME:Marlin 2.1.2"},
+		{cmd: "M114 ; where am I", find: "X:0.00"},
+		{cmd: "M421", find: "incorrect parameter", err: true},
+		{cmd: "M400"},
+	}
+	for _, checksums := range []bool{false, true} {
+		c, _ := connectFake(t, checksums)
+		for _, tt := range tests {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			resp, err := c.SendAndWait(ctx, tt.cmd)
+			cancel()
+			var fwErr *FirmwareError
+			if tt.err != errors.As(err, &fwErr) {
+				t.Errorf("checksums %v, %s: err = %v", checksums, tt.cmd, err)
+				continue
+			}
+			if resp == nil {
+				t.Errorf("checksums %v, %s: no response", checksums, tt.cmd)
+				continue
+			}
+			if tt.find != "" && resp.Find(tt.find) == "" {
+				t.Errorf("checksums %v, %s: reply %q has no %q", checksums, tt.cmd, resp.Lines, tt.find)
+			}
+			for _, line := range resp.Lines {
+				if strings.HasPrefix(line, "T:") || line == "ok" {
+					t.Errorf("checksums %v, %s: reply holds %q", checksums, tt.cmd, line)
+				}
+			}
+		}
+	}
+}
+
+func TestSendAndWaitCancel(t *testing.T) {
+	c, fw := connectFake(t, false)
+	// G4 keeps the firmware busy, so M400 is still waiting to be written
+	// when its context ends and is withdrawn.
+	if err := c.SendRaw("G4 P300"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err := c.SendAndWait(ctx, "M400")
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := c.SendAndWait(ctx, "M114")
+	if err != nil || resp.Find("X:") == "" {
+		t.Fatalf("M114 after a cancelled command = %v, %v", resp, err)
+	}
+	if fw.saw("M400") {
+		t.Error("the cancelled command was still sent")
+	}
+}
+
+func TestSendAndWaitNotConnected(t *testing.T) {
+	c := NewClient()
+	if _, err := c.SendAndWait(context.Background(), "M105"); err == nil {
+		t.Error("SendAndWait without a connection succeeded")
+	}
+}
@@ -0,0 +1,269 @@
+package printer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting is one G-code line of an M503 report, such as
+// "M92 X80.00 Y80.00 Z400.00 E93.00".
+type Setting struct {
+	// Section is the heading Marlin printed above the line.
+	Section string         `json:"section,omitempty"`
+	Code    string         `json:"code"`
+	Params  []SettingParam `json:"params,omitempty"`
+}
+
+// SettingParam is one parameter word. Flags such as the axes of M569 have
+// an empty Value.
+type SettingParam struct {
+	Letter string `json:"letter"`
+	Value  string `json:"value"`
+}
+
+// settingSelectors are the parameters that pick which instance of a
+// setting a line is for, e.g. the extruder of M301 or the preheat slot of
+// M145, rather than being values themselves. Mesh points (G29 S3 for mesh
+// bed leveling, M421 otherwise) are told apart by their I and J indices.
+var settingSelectors = map[string]string{
+	"G29":  "SIJ",
+	"M92":  "T",
+	"M145": "S",
+	"M201": "T",
+	"M203": "T",
+	"M301": "E",
+	"M421": "IJ",
+	"M569": "S",
+	"M900": "T",
+	"M906": "IT",
+	"M913": "IT",
+	"M914": "IT",
+}
+
+func (s Setting) isSelector(letter string) bool {
+	return strings.Contains(settingSelectors[s.Code], letter)
+}
+
+// Key identifies the setting across snapshots: the code plus any selector
+// parameters, such as "M301 E1".
+func (s Setting) Key() string {
+	key := s.Code
+	for _, p := range s.Params {
+		if s.isSelector(p.Letter) {
+			key += " " + p.Letter + p.Value
+		}
+	}
+	return key
+}
+
+func (s Setting) Value(letter string) (string, bool) {
+	for _, p := range s.Params {
+		if p.Letter == letter {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// Command is the G-code that sets the line's values again.
+func (s Setting) Command() string {
+	words := []string{s.Code}
+	for _, p := range s.Params {
+		words = append(words, p.Letter+p.Value)
+	}
+	return strings.Join(words, " ")
+}
+
+// ParseSettings reads the G-code lines of an M503 report. Comments,
+// "echo:" prefixes and lines that are not commands are dropped.
+func ParseSettings(lines []string) []Setting {
+	var settings []Setting
+	section := ""
+	for _, line := range lines {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "echo:"))
+		if strings.HasPrefix(line, ";") {
+			section = strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(line, ";")), ":")
+			continue
+		}
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !isSettingCode(fields[0]) {
+			continue
+		}
+		s := Setting{Section: section, Code: strings.ToUpper(fields[0])}
+		for _, f := range fields[1:] {
+			s.Params = append(s.Params, SettingParam{Letter: strings.ToUpper(f[:1]), Value: f[1:]})
+		}
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+func isSettingCode(word string) bool {
+	if len(word) < 2 || (word[0] != 'G' && word[0] != 'M') {
+		return false
+	}
+	_, err := strconv.Atoi(word[1:])
+	return err == nil
+}
+
+// SettingsSnapshot is a saved copy of a printer's settings.
+type SettingsSnapshot struct {
+	Time     time.Time `json:"time"`
+	Firmware string    `json:"firmware,omitempty"`
+	Settings []Setting `json:"settings"`
+}
+
+// Find returns the setting with the given key.
+func (s *SettingsSnapshot) Find(key string) (Setting, bool) {
+	for _, setting := range s.Settings {
+		if setting.Key() == key {
+			return setting, true
+		}
+	}
+	return Setting{}, false
+}
+
+func (s *SettingsSnapshot) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+func ReadSettingsJSON(r io.Reader) (*SettingsSnapshot, error) {
+	var s SettingsSnapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	if len(s.Settings) == 0 {
+		return nil, fmt.Errorf("settings file has no settings")
+	}
+	return &s, nil
+}
+
+// ReadSettings takes a snapshot of the printer's settings with M503.
+func (c *Client) ReadSettings(ctx context.Context) (*SettingsSnapshot, error) {
+	resp, err := c.SendAndWait(ctx, "M503")
+	if err != nil {
+		return nil, fmt.Errorf("M503: %w", err)
+	}
+	settings := ParseSettings(resp.Lines)
+	if len(settings) == 0 {
+		return nil, fmt.Errorf("no settings in M503 report")
+	}
+	return &SettingsSnapshot{
+		Time:     time.Now(),
+		Firmware: c.Capabilities().FirmwareName,
+		Settings: settings,
+	}, nil
+}
+
+// SettingChange is one parameter that differs between two snapshots.
+// HasOld and HasNew are false when the parameter is missing from that side.
+type SettingChange struct {
+	Key            string
+	Param          string
+	Old, New       string
+	HasOld, HasNew bool
+}
+
+// DiffSettings lists the parameters whose values differ from old to cur.
+// Numbers compare by value, so "80" equals "80.00".
+func DiffSettings(old, cur *SettingsSnapshot) []SettingChange {
+	var changes []SettingChange
+	seen := map[string]bool{}
+	compare := func(key string) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		a, _ := old.Find(key)
+		b, _ := cur.Find(key)
+		letters := map[string]bool{}
+		for _, s := range []Setting{a, b} {
+			for _, p := range s.Params {
+				if letters[p.Letter] || s.isSelector(p.Letter) {
+					continue
+				}
+				letters[p.Letter] = true
+				ov, hasOld := a.Value(p.Letter)
+				nv, hasNew := b.Value(p.Letter)
+				if hasOld == hasNew && sameValue(ov, nv) {
+					continue
+				}
+				changes = append(changes, SettingChange{
+					Key: key, Param: p.Letter,
+					Old: ov, New: nv, HasOld: hasOld, HasNew: hasNew,
+				})
+			}
+		}
+	}
+	for _, s := range cur.Settings {
+		compare(s.Key())
+	}
+	for _, s := range old.Settings {
+		compare(s.Key())
+	}
+	return changes
+}
+
+func sameValue(a, b string) bool {
+	if a == b {
+		return true
+	}
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	return errA == nil && errB == nil && fa == fb
+}
+
+// RestoreCommands builds the G-code that sets the old values of the given
+// changes again, one command per setting carrying its selectors and only
+// the chosen parameters. Parameters the old side lacks are skipped.
+func RestoreCommands(old *SettingsSnapshot, changes []SettingChange) []string {
+	var keys []string
+	chosen := map[string]map[string]bool{}
+	for _, ch := range changes {
+		if !ch.HasOld {
+			continue
+		}
+		if chosen[ch.Key] == nil {
+			chosen[ch.Key] = map[string]bool{}
+			keys = append(keys, ch.Key)
+		}
+		chosen[ch.Key][ch.Param] = true
+	}
+	var cmds []string
+	for _, key := range keys {
+		s, ok := old.Find(key)
+		if !ok {
+			continue
+		}
+		subset := Setting{Code: s.Code}
+		for _, p := range s.Params {
+			if s.isSelector(p.Letter) || chosen[key][p.Letter] {
+				subset.Params = append(subset.Params, p)
+			}
+		}
+		cmds = append(cmds, subset.Command())
+	}
+	return cmds
+}
+
+// RestoreSettings sends cmds and, if save is set, stores the result in
+// EEPROM with M500.
+func (c *Client) RestoreSettings(ctx context.Context, cmds []string, save bool) error {
+	if err := c.sendAll(ctx, cmds...); err != nil {
+		return err
+	}
+	if !save {
+		return nil
+	}
+	return c.SaveSettings(ctx)
+}
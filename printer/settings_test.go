@@ -0,0 +1,105 @@
+package printer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSettings(t *testing.T) {
+	lines := []string{
+		"echo:; Steps per unit:",
+		"echo:  M92 X80.00 Y80.00 Z400.00 E93.00",
+		"echo:; Hotend PID:",
+		"echo:  M301 E1 P22.20 I1.08 D114.00",
+		"echo:  M569 S1 X Y ; stepper driver",
+		"echo:Bed Leveling ON",
+		"ok",
+	}
+	want := []Setting{
+		{Section: "Steps per unit", Code: "M92", Params: []SettingParam{{"X", "80.00"}, {"Y", "80.00"}, {"Z", "400.00"}, {"E", "93.00"}}},
+		{Section: "Hotend PID", Code: "M301", Params: []SettingParam{{"E", "1"}, {"P", "22.20"}, {"I", "1.08"}, {"D", "114.00"}}},
+		{Section: "Hotend PID", Code: "M569", Params: []SettingParam{{"S", "1"}, {"X", ""}, {"Y", ""}}},
+	}
+	if got := ParseSettings(lines); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSettings =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestSettingKey(t *testing.T) {
+	tests := []struct {
+		line string
+		key  string
+	}{
+		{"M92 X80 Y80 Z400 E93", "M92"},
+		{"M92 T1 E415", "M92 T1"},
+		{"M301 E1 P22.2 I1.08 D114", "M301 E1"},
+		{"M145 S0 H180 B70 F0", "M145 S0"},
+		{"M906 I1 X800", "M906 I1"},
+		{"G29 S3 I1 J2 Z0.05", "G29 S3 I1 J2"},
+		{"M421 I0 J3 Z-0.125", "M421 I0 J3"},
+	}
+	for _, tt := range tests {
+		settings := ParseSettings([]string{tt.line})
+		if len(settings) != 1 {
+			t.Errorf("ParseSettings(%q) = %v", tt.line, settings)
+			continue
+		}
+		if got := settings[0].Key(); got != tt.key {
+			t.Errorf("Key(%q) = %q, want %q", tt.line, got, tt.key)
+		}
+	}
+}
+
+func TestDiffSettings(t *testing.T) {
+	old := &SettingsSnapshot{Settings: ParseSettings([]string{
+		"M92 X80.00 Y80.00 Z400.00 E93.00",
+		"M301 E0 P22.20 I1.08 D114.00",
+		"M301 E1 P20.00 I1.00 D100.00",
+		"G29 S3 I0 J0 Z0.100",
+		"G29 S3 I1 J0 Z0.200",
+		"M851 X-40.00 Y-10.00 Z-1.50",
+		"M900 K0.05",
+	})}
+	cur := &SettingsSnapshot{Settings: ParseSettings([]string{
+		"M92 X80 Y80 Z400 E100.00",
+		"M301 E0 P22.20 I1.08 D114.00",
+		"M301 E1 P21.00 I1.00 D100.00",
+		"G29 S3 I0 J0 Z0.100",
+		"G29 S3 I1 J0 Z0.250",
+		"M851 X-40.00 Y-10.00 Z-1.20",
+		"M200 D1.75",
+	})}
+	want := []SettingChange{
+		{Key: "M92", Param: "E", Old: "93.00", New: "100.00", HasOld: true, HasNew: true},
+		{Key: "M301 E1", Param: "P", Old: "20.00", New: "21.00", HasOld: true, HasNew: true},
+		{Key: "G29 S3 I1 J0", Param: "Z", Old: "0.200", New: "0.250", HasOld: true, HasNew: true},
+		{Key: "M851", Param: "Z", Old: "-1.50", New: "-1.20", HasOld: true, HasNew: true},
+		{Key: "M200", Param: "D", New: "1.75", HasNew: true},
+		{Key: "M900", Param: "K", Old: "0.05", HasOld: true},
+	}
+	changes := DiffSettings(old, cur)
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("DiffSettings =\n%+v\nwant\n%+v", changes, want)
+	}
+
+	cmds := RestoreCommands(old, changes)
+	wantCmds := []string{
+		"M92 E93.00",
+		"M301 E1 P20.00",
+		"G29 S3 I1 J0 Z0.200",
+		"M851 Z-1.50",
+		"M900 K0.05",
+	}
+	if !reflect.DeepEqual(cmds, wantCmds) {
+		t.Errorf("RestoreCommands =\n%q\nwant\n%q", cmds, wantCmds)
+	}
+}
+
+func TestDiffSettingsUnchanged(t *testing.T) {
+	lines := []string{"M92 X80.00 Y80.00 Z400.00 E93.00", "M569 S1 X Y"}
+	a := &SettingsSnapshot{Settings: ParseSettings(lines)}
+	b := &SettingsSnapshot{Settings: ParseSettings([]string{"M92 X80 Y80.0 Z400 E93", "M569 S1 X Y"})}
+	if changes := DiffSettings(a, b); len(changes) != 0 {
+		t.Errorf("DiffSettings = %+v, want no changes", changes)
+	}
+}
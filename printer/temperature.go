@@ -0,0 +1,157 @@
+package printer
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaterReading is one sensor from a temperature report. ID is the letter
+// Marlin uses: "T" for the active hotend, "T0", "T1"... for each extruder,
+// "B" bed, "C" chamber, "P" probe, and so on.
+type HeaterReading struct {
+	ID        string
+	Current   float64
+	Target    float64
+	HasTarget bool
+	// Power is the raw PWM value after "@:" or "B@:", usually 0-127.
+	Power    int
+	HasPower bool
+}
+
+// TempReport is a parsed M105 reply or M155 auto-report.
+type TempReport struct {
+	Time    time.Time
+	Heaters []HeaterReading
+}
+
+// Heater returns the reading with the given ID.
+func (r TempReport) Heater(id string) (HeaterReading, bool) {
+	for _, h := range r.Heaters {
+		if h.ID == id {
+			return h, true
+		}
+	}
+	return HeaterReading{}, false
+}
+
+// Hotend returns the active hotend, falling back to the first extruder.
+func (r TempReport) Hotend() (HeaterReading, bool) {
+	if h, ok := r.Heater("T"); ok {
+		return h, true
+	}
+	return r.Heater("T0")
+}
+
+func (r TempReport) Bed() (HeaterReading, bool) {
+	return r.Heater("B")
+}
+
+// Extruders returns the per-extruder readings (T0, T1...) of multi-extruder
+// machines, or nil if only the active hotend is reported.
+func (r TempReport) Extruders() []HeaterReading {
+	var out []HeaterReading
+	for _, h := range r.Heaters {
+		if len(h.ID) > 1 && h.ID[0] == 'T' {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// HeaterName is a human-readable name for a heater ID.
+func HeaterName(id string) string {
+	names := map[string]string{
+		"T": "Hotend",
+		"B": "Bed",
+		"C": "Chamber",
+		"P": "Probe",
+		"L": "Cooler",
+		"R": "Redundant",
+		"M": "Board",
+	}
+	if name, ok := names[id]; ok {
+		return name
+	}
+	if len(id) > 1 {
+		if name, ok := names[id[:1]]; ok {
+			return name + " " + id[1:]
+		}
+	}
+	return id
+}
+
+var reTempField = regexp.MustCompile(`(?:^|\s)([TBCPLRM]\d*|[BC]?@\d*)\s*:\s*(-?\d+(?:\.\d+)?)(?:\s*/\s*(-?\d+(?:\.\d+)?))?`)
+
+// ParseTempReport parses a temperature line such as
+//
+//	ok T:210.0 /210.0 B:60.1 /60.0 T0:210.0 /210.0 T1:24.8 /0.0 @:64 B@:30 @0:64 @1:0 C:30.2 /0.0
+//
+// It reports false if the line holds no temperatures.
+func ParseTempReport(line string, at time.Time) (TempReport, bool) {
+	report := TempReport{Time: at}
+	power := make(map[string]int)
+	for _, m := range reTempField.FindAllStringSubmatch(line, -1) {
+		id := m[1]
+		if i := strings.IndexByte(id, '@'); i >= 0 {
+			// "@:" is the active hotend, "@1:" extruder 1, "B@:" the bed.
+			heater := id[:i]
+			if heater == "" {
+				heater = "T" + id[i+1:]
+			}
+			if v, err := strconv.ParseFloat(m[2], 64); err == nil {
+				power[heater] = int(v)
+			}
+			continue
+		}
+		h := HeaterReading{ID: id}
+		h.Current, _ = strconv.ParseFloat(m[2], 64)
+		if m[3] != "" {
+			h.Target, _ = strconv.ParseFloat(m[3], 64)
+			h.HasTarget = true
+		}
+		report.Heaters = append(report.Heaters, h)
+	}
+	if len(report.Heaters) == 0 {
+		return TempReport{}, false
+	}
+	for i, h := range report.Heaters {
+		if p, ok := power[h.ID]; ok {
+			report.Heaters[i].Power, report.Heaters[i].HasPower = p, true
+		}
+	}
+	return report, true
+}
+
+type tempReportJSON struct {
+	Time    time.Time    `json:"time"`
+	Heaters []heaterJSON `json:"heaters"`
+}
+
+type heaterJSON struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Current float64  `json:"current"`
+	Target  *float64 `json:"target,omitempty"`
+	Power   *int     `json:"power,omitempty"`
+}
+
+// MarshalJSON encodes the report with lower-case keys, omitting targets and
+// power the firmware did not send.
+func (r TempReport) MarshalJSON() ([]byte, error) {
+	out := tempReportJSON{Time: r.Time, Heaters: []heaterJSON{}}
+	for _, h := range r.Heaters {
+		h := h
+		hj := heaterJSON{ID: h.ID, Name: HeaterName(h.ID), Current: h.Current}
+		if h.HasTarget {
+			hj.Target = &h.Target
+		}
+		if h.HasPower {
+			hj.Power = &h.Power
+		}
+		out.Heaters = append(out.Heaters, hj)
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,67 @@
+package printer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTempReport(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		line string
+		want []HeaterReading
+	}{
+		{
+			line: "ok T:210.0 /210.0 B:60.1 /60.0 @:64 B@:30",
+			want: []HeaterReading{
+				{ID: "T", Current: 210, Target: 210, HasTarget: true, Power: 64, HasPower: true},
+				{ID: "B", Current: 60.1, Target: 60, HasTarget: true, Power: 30, HasPower: true},
+			},
+		},
+		{
+			line: " T:25.0 /0.0 B:24.0 /0.0 T0:25.0 /0.0 T1:24.8 /0.0 @:0 B@:0 @0:0 @1:127",
+			want: []HeaterReading{
+				{ID: "T", Current: 25, HasTarget: true, HasPower: true},
+				{ID: "B", Current: 24, HasTarget: true, HasPower: true},
+				{ID: "T0", Current: 25, HasTarget: true, HasPower: true},
+				{ID: "T1", Current: 24.8, HasTarget: true, Power: 127, HasPower: true},
+			},
+		},
+		{
+			line: "T:21.5 C:30.2 /0.0",
+			want: []HeaterReading{
+				{ID: "T", Current: 21.5},
+				{ID: "C", Current: 30.2, HasTarget: true},
+			},
+		},
+		{
+			line: "T:-15.00 /0.00 B:22.00 /0.00",
+			want: []HeaterReading{
+				{ID: "T", Current: -15, HasTarget: true},
+				{ID: "B", Current: 22, HasTarget: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		report, ok := ParseTempReport(tt.line, at)
+		if !ok {
+			t.Errorf("ParseTempReport(%q) found no temperatures", tt.line)
+			continue
+		}
+		if !report.Time.Equal(at) {
+			t.Errorf("ParseTempReport(%q).Time = %v", tt.line, report.Time)
+		}
+		if !reflect.DeepEqual(report.Heaters, tt.want) {
+			t.Errorf("ParseTempReport(%q) =\n%+v\nwant\n%+v", tt.line, report.Heaters, tt.want)
+		}
+	}
+}
+
+func TestParseTempReportWithoutTemperatures(t *testing.T) {
+	for _, line := range []string{"", "ok", "echo:busy: processing", "X:0.00 Y:0.00 Z:0.00 E:0.00 Count X:0 Y:0 Z:0"} {
+		if report, ok := ParseTempReport(line, time.Now()); ok {
+			t.Errorf("ParseTempReport(%q) = %+v, want none", line, report.Heaters)
+		}
+	}
+}
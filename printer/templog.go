@@ -0,0 +1,121 @@
+package printer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var tempCSVHeader = []string{"time", "heater", "current", "target", "power"}
+
+// TempLog writes temperature reports as CSV, one line per heater per
+// report. Target and power are empty when the firmware did not send them.
+type TempLog struct {
+	mu sync.Mutex
+	w  io.Writer
+	cw *csv.Writer
+}
+
+// NewTempLog writes the CSV header to w and returns a log appending to it.
+// If w is an io.Closer, Close closes it.
+func NewTempLog(w io.Writer) (*TempLog, error) {
+	l := &TempLog{w: w, cw: csv.NewWriter(w)}
+	if err := l.cw.Write(tempCSVHeader); err != nil {
+		return nil, err
+	}
+	l.cw.Flush()
+	return l, l.cw.Error()
+}
+
+// Write appends report. Each report is flushed so the file stays useful if
+// the program dies mid-session.
+func (l *TempLog) Write(report TempReport) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at := report.Time.Format(time.RFC3339Nano)
+	for _, h := range report.Heaters {
+		rec := []string{at, h.ID, strconv.FormatFloat(h.Current, 'f', 2, 64), "", ""}
+		if h.HasTarget {
+			rec[3] = strconv.FormatFloat(h.Target, 'f', 2, 64)
+		}
+		if h.HasPower {
+			rec[4] = strconv.Itoa(h.Power)
+		}
+		if err := l.cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	l.cw.Flush()
+	return l.cw.Error()
+}
+
+func (l *TempLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cw.Flush()
+	err := l.cw.Error()
+	if c, ok := l.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ReadTempLog reads a CSV written by TempLog back into reports, in file
+// order.
+func ReadTempLog(r io.Reader) ([]TempReport, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || len(records[0]) != len(tempCSVHeader) || records[0][0] != tempCSVHeader[0] {
+		return nil, fmt.Errorf("not a temperature log")
+	}
+	var reports []TempReport
+	for n, rec := range records[1:] {
+		if len(rec) != len(tempCSVHeader) {
+			return nil, fmt.Errorf("line %d: expected %d fields, got %d", n+2, len(tempCSVHeader), len(rec))
+		}
+		at, err := time.Parse(time.RFC3339Nano, rec[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+2, err)
+		}
+		h := HeaterReading{ID: rec[1]}
+		if h.Current, err = strconv.ParseFloat(rec[2], 64); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+2, err)
+		}
+		if rec[3] != "" {
+			if h.Target, err = strconv.ParseFloat(rec[3], 64); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+2, err)
+			}
+			h.HasTarget = true
+		}
+		if rec[4] != "" {
+			if h.Power, err = strconv.Atoi(rec[4]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+2, err)
+			}
+			h.HasPower = true
+		}
+		if len(reports) == 0 || !reports[len(reports)-1].Time.Equal(at) {
+			reports = append(reports, TempReport{Time: at})
+		}
+		last := &reports[len(reports)-1]
+		last.Heaters = append(last.Heaters, h)
+	}
+	return reports, nil
+}
+
+// SetTempLog logs every temperature report the client receives to l,
+// whether or not monitoring listeners are active. Pass nil to stop; the
+// previous log is returned so the caller can close it.
+func (c *Client) SetTempLog(l *TempLog) *TempLog {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev := c.tempLog
+	c.tempLog = l
+	return prev
+}
@@ -0,0 +1,324 @@
+package printer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Test print patterns.
+const (
+	// PatternSquares prints a filled square in each region of a Count x
+	// Count grid over the bed, so each part of a mesh can be judged.
+	PatternSquares = "squares"
+	// PatternRings prints Count concentric rings around the bed centre.
+	PatternRings = "rings"
+	// PatternLines prints Count strips across the bed, front to back.
+	PatternLines = "lines"
+)
+
+// TestPrint describes a first-layer test print generated as plain G-code,
+// so it works on firmware built without G26.
+type TestPrint struct {
+	Pattern string  `json:"pattern"`
+	Count   int     `json:"count"`
+	BedX    float64 `json:"bed_x"`
+	BedY    float64 `json:"bed_y"`
+	Nozzle  float64 `json:"nozzle"`
+	// LayerHeight is the height of the single layer in mm.
+	LayerHeight float64 `json:"layer_height"`
+	Hotend      float64 `json:"hotend"`
+	Bed         float64 `json:"bed"`
+	// Speed is the print speed in mm/s.
+	Speed float64 `json:"speed"`
+	// Leveling enables the saved mesh with M420 S1 after homing.
+	Leveling bool `json:"leveling"`
+}
+
+var DefaultTestPrint = TestPrint{
+	Pattern:     PatternSquares,
+	Count:       3,
+	BedX:        220,
+	BedY:        220,
+	Nozzle:      0.4,
+	LayerHeight: 0.2,
+	Hotend:      210,
+	Bed:         60,
+	Speed:       25,
+	Leveling:    true,
+}
+
+const (
+	testPrintMargin   = 15.0
+	filamentDiameter  = 1.75
+	travelFeedrate    = 6000.0
+	zFeedrate         = 600.0
+	retractLength     = 0.8
+	retractFeedrate   = 2400.0
+	zHop              = 0.4
+	maxSquareSide     = 30.0
+	ringSegmentLength = 2.0
+)
+
+func (p TestPrint) validate() error {
+	switch {
+	case p.Pattern != PatternSquares && p.Pattern != PatternRings && p.Pattern != PatternLines:
+		return fmt.Errorf("unknown test print pattern %q", p.Pattern)
+	case p.Count < 1 || p.Count > 10:
+		return fmt.Errorf("count must be 1 to 10, not %d", p.Count)
+	case p.BedX < 4*testPrintMargin || p.BedY < 4*testPrintMargin:
+		return fmt.Errorf("bed %gx%g mm is too small", p.BedX, p.BedY)
+	case p.Nozzle <= 0:
+		return fmt.Errorf("nozzle diameter must be positive")
+	case p.LayerHeight <= 0 || p.LayerHeight > 0.8*p.Nozzle:
+		return fmt.Errorf("layer height must be above 0 and at most 80%% of the nozzle, %.2f mm", 0.8*p.Nozzle)
+	case p.Hotend < 170:
+		return fmt.Errorf("hotend temperature %.0f is too cold to extrude", p.Hotend)
+	case p.Bed < 0:
+		return fmt.Errorf("bed temperature must not be negative")
+	case p.Speed <= 0:
+		return fmt.Errorf("speed must be positive")
+	}
+	return nil
+}
+
+// gcodeWriter tracks the nozzle so moves can be written as extrusions of
+// the right length. Extrusion is relative (M83).
+type gcodeWriter struct {
+	lines     []string
+	x, y      float64
+	layer     float64
+	ePerMM    float64
+	feedrate  float64
+	retracted bool
+}
+
+func (w *gcodeWriter) emit(format string, args ...interface{}) {
+	w.lines = append(w.lines, fmt.Sprintf(format, args...))
+}
+
+// travel moves to x, y without extruding, retracted and lifted.
+func (w *gcodeWriter) travel(x, y float64) {
+	if !w.retracted {
+		w.emit("G1 E%.3f F%.0f", -retractLength, retractFeedrate)
+		w.retracted = true
+	}
+	w.emit("G0 Z%.3f F%.0f", w.layer+zHop, zFeedrate)
+	w.emit("G0 X%.3f Y%.3f F%.0f", x, y, travelFeedrate)
+	w.emit("G0 Z%.3f F%.0f", w.layer, zFeedrate)
+	w.x, w.y = x, y
+}
+
+// extrude prints a straight line to x, y.
+func (w *gcodeWriter) extrude(x, y float64) {
+	if w.retracted {
+		w.emit("G1 E%.3f F%.0f", retractLength, retractFeedrate)
+		w.retracted = false
+	}
+	length := math.Hypot(x-w.x, y-w.y)
+	w.emit("G1 X%.3f Y%.3f E%.4f F%.0f", x, y, length*w.ePerMM, w.feedrate)
+	w.x, w.y = x, y
+}
+
+// GCode returns the test print as G-code lines: heating, homing, a prime
+// line, the pattern and a cooldown.
+func (p TestPrint) GCode() ([]string, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	width := 1.2 * p.Nozzle
+	filament := math.Pi * filamentDiameter * filamentDiameter / 4
+	w := &gcodeWriter{
+		layer:    p.LayerHeight,
+		ePerMM:   width * p.LayerHeight / filament,
+		feedrate: p.Speed * 60,
+	}
+	w.emit("; first-layer test: %s x%d, %.2f mm nozzle, %.2f mm layer", p.Pattern, p.Count, p.Nozzle, p.LayerHeight)
+	w.emit("M140 S%.0f", p.Bed)
+	w.emit("M104 S%.0f", p.Hotend)
+	w.emit("G28")
+	if p.Leveling {
+		w.emit("M420 S1")
+	}
+	w.emit("M190 S%.0f", p.Bed)
+	w.emit("M109 S%.0f", p.Hotend)
+	w.emit("G90")
+	w.emit("M83")
+
+	// Prime along the front edge.
+	primeY := testPrintMargin / 2
+	w.emit("G0 Z%.3f F%.0f", 5.0, zFeedrate)
+	w.emit("G0 X%.3f Y%.3f F%.0f", testPrintMargin, primeY, travelFeedrate)
+	w.emit("G0 Z%.3f F%.0f", p.LayerHeight, zFeedrate)
+	w.x, w.y = testPrintMargin, primeY
+	w.extrude(math.Min(p.BedX-testPrintMargin, testPrintMargin+100), primeY)
+	w.extrude(w.x, primeY+width)
+	w.extrude(testPrintMargin, primeY+width)
+
+	minX, minY := testPrintMargin, testPrintMargin
+	maxX, maxY := p.BedX-testPrintMargin, p.BedY-testPrintMargin
+	switch p.Pattern {
+	case PatternSquares:
+		cellW := (maxX - minX) / float64(p.Count)
+		cellH := (maxY - minY) / float64(p.Count)
+		side := math.Min(math.Min(cellW, cellH)*0.6, maxSquareSide)
+		for row := 0; row < p.Count; row++ {
+			for col := 0; col < p.Count; col++ {
+				cx := minX + cellW*(float64(col)+0.5)
+				cy := minY + cellH*(float64(row)+0.5)
+				square(w, cx-side/2, cy-side/2, side, width)
+			}
+		}
+	case PatternRings:
+		cx, cy := p.BedX/2, p.BedY/2
+		maxR := math.Min(maxX-cx, maxY-cy)
+		for i := 1; i <= p.Count; i++ {
+			r := maxR * float64(i) / float64(p.Count)
+			// Two loops side by side make a ring wide enough to judge.
+			ring(w, cx, cy, r)
+			ring(w, cx, cy, r-width)
+		}
+	case PatternLines:
+		pitch := (maxX - minX) / float64(p.Count)
+		for i := 0; i < p.Count; i++ {
+			x := minX + pitch*(float64(i)+0.5)
+			// Three passes, there and back, make one strip.
+			w.travel(x-width, minY)
+			w.extrude(x-width, maxY)
+			w.extrude(x, maxY)
+			w.extrude(x, minY)
+			w.extrude(x+width, minY)
+			w.extrude(x+width, maxY)
+		}
+	}
+
+	w.emit("G1 E%.3f F%.0f", -retractLength, retractFeedrate)
+	w.emit("G0 Z%.3f F%.0f", p.LayerHeight+10, zFeedrate)
+	w.emit("M104 S0")
+	w.emit("M140 S0")
+	w.emit("M82")
+	w.emit("M84")
+	return w.lines, nil
+}
+
+// square prints an outline and a zigzag fill along X.
+func square(w *gcodeWriter, x, y, side, width float64) {
+	w.travel(x, y)
+	w.extrude(x+side, y)
+	w.extrude(x+side, y+side)
+	w.extrude(x, y+side)
+	w.extrude(x, y)
+	inner := side - 2*width
+	if inner <= 0 {
+		return
+	}
+	x0, x1 := x+width, x+side-width
+	w.travel(x0, y+width)
+	for fy, n := y+width, 0; fy <= y+side-width+1e-9; fy, n = fy+width, n+1 {
+		if n > 0 {
+			w.extrude(w.x, fy)
+		}
+		if n%2 == 0 {
+			w.extrude(x1, fy)
+		} else {
+			w.extrude(x0, fy)
+		}
+	}
+}
+
+func ring(w *gcodeWriter, cx, cy, r float64) {
+	segments := max(int(math.Ceil(2*math.Pi*r/ringSegmentLength)), 12)
+	w.travel(cx+r, cy)
+	for i := 1; i <= segments; i++ {
+		a := 2 * math.Pi * float64(i) / float64(segments)
+		w.extrude(cx+r*math.Cos(a), cy+r*math.Sin(a))
+	}
+}
+
+// AddPrintListener registers f to hear when StreamGCode starts and stops
+// a print, the time babysteps take effect promptly.
+func (c *Client) AddPrintListener(f func(printing bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.printListeners = append(c.printListeners, f)
+}
+
+func (c *Client) broadcastPrinting(printing bool) {
+	c.mu.Lock()
+	listeners := append([]func(bool){}, c.printListeners...)
+	c.mu.Unlock()
+	for _, f := range listeners {
+		f(printing)
+	}
+}
+
+// StreamGCode sends lines one at a time, each once the previous one is
+// acknowledged, skipping comments and blank lines. progress, if not nil,
+// is called after each line with the number sent so far.
+func (c *Client) StreamGCode(ctx context.Context, lines []string, progress func(sent, total int)) error {
+	c.broadcastPrinting(true)
+	defer c.broadcastPrinting(false)
+	var cmds []string
+	for _, line := range lines {
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			cmds = append(cmds, line)
+		}
+	}
+	for i, cmd := range cmds {
+		resp, err := c.SendAndWait(ctx, cmd)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cmd, err)
+		}
+		if resp.Find("cold extrusion prevented") != "" {
+			return fmt.Errorf("hotend too cold to extrude")
+		}
+		if progress != nil {
+			progress(i+1, len(cmds))
+		}
+	}
+	return nil
+}
+
+// PrintTestPattern generates p and streams it. If the print fails or ctx
+// is cancelled, the print is aborted with abortPrint and any error from
+// that is returned along with the print's.
+func (c *Client) PrintTestPattern(ctx context.Context, p TestPrint, progress func(sent, total int)) error {
+	lines, err := p.GCode()
+	if err != nil {
+		return err
+	}
+	err = c.StreamGCode(ctx, lines, progress)
+	if err == nil {
+		return nil
+	}
+	if aerr := c.abortPrint(); aerr != nil {
+		return fmt.Errorf("%w; aborting the print: %w", err, aerr)
+	}
+	return err
+}
+
+// abortPrint breaks any M109/M190 wait with M108, stops motion and turns the
+// heaters off. The commands go ahead of anything still pending, and every
+// one is tried even if an earlier one fails. A heating wait can take minutes
+// to give up, so the timeout is generous.
+func (c *Client) abortPrint() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	var errs []error
+	for _, cmd := range []string{"M108", "M410", "M104 S0", "M140 S0", "M82"} {
+		pc, err := c.enqueue(cmd, nil, true)
+		if err == nil {
+			_, err = c.await(ctx, pc)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cmd, err))
+		}
+	}
+	return errors.Join(errs...)
+}
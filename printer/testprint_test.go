@@ -0,0 +1,74 @@
+package printer
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTestPrintValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(p *TestPrint)
+		err    string
+	}{
+		{"defaults", func(p *TestPrint) {}, ""},
+		{"rings", func(p *TestPrint) { p.Pattern = PatternRings }, ""},
+		{"lines", func(p *TestPrint) { p.Pattern = PatternLines; p.Count = 10 }, ""},
+		{"pattern", func(p *TestPrint) { p.Pattern = "spiral" }, "unknown test print pattern"},
+		{"no count", func(p *TestPrint) { p.Count = 0 }, "count must be 1 to 10"},
+		{"count", func(p *TestPrint) { p.Count = 11 }, "count must be 1 to 10"},
+		{"bed", func(p *TestPrint) { p.BedX = 50 }, "too small"},
+		{"nozzle", func(p *TestPrint) { p.Nozzle = 0 }, "nozzle diameter"},
+		{"no layer", func(p *TestPrint) { p.LayerHeight = 0 }, "layer height"},
+		{"layer", func(p *TestPrint) { p.LayerHeight = 0.35 }, "layer height"},
+		{"hotend", func(p *TestPrint) { p.Hotend = 150 }, "too cold"},
+		{"bed temperature", func(p *TestPrint) { p.Bed = -1 }, "bed temperature"},
+		{"speed", func(p *TestPrint) { p.Speed = 0 }, "speed"},
+	}
+	for _, tt := range tests {
+		p := DefaultTestPrint
+		tt.change(&p)
+		lines, err := p.GCode()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err == "" && len(lines) == 0:
+			t.Errorf("%s: no G-code", tt.name)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: err = %v, want one about %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestTestPrintGCode(t *testing.T) {
+	for _, leveling := range []bool{true, false} {
+		p := DefaultTestPrint
+		p.Leveling = leveling
+		lines, err := p.GCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := slices.Contains(lines, "M420 S1"); got != leveling {
+			t.Errorf("leveling %v: M420 S1 present = %v", leveling, got)
+		}
+		// Heaters and homing come before any extrusion, and the print ends
+		// with the heaters off.
+		home := slices.Index(lines, "G28")
+		first := slices.IndexFunc(lines, func(l string) bool {
+			return strings.HasPrefix(l, "G1 ") && strings.Contains(l, " E")
+		})
+		heat := slices.Index(lines, "M109 S210")
+		if home < 0 || heat < 0 || first < home || first < heat {
+			t.Errorf("leveling %v: extrudes before homing and heating", leveling)
+		}
+		if !slices.Contains(lines, "M104 S0") || lines[len(lines)-1] != "M84" {
+			t.Errorf("leveling %v: does not end with the heaters and motors off", leveling)
+		}
+		for _, line := range lines {
+			if strings.Contains(line, "NaN") || strings.Contains(line, "Inf") {
+				t.Errorf("leveling %v: bad line %q", leveling, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+var reProbeResult = regexp.MustCompile(`Bed X:\s*(-?[0-9.]+)\s+Y:\s*(-?[0-9.]+)\s+Z:\s*(-?[0-9.]+)`)
+
+// Screw is a bed adjustment knob at bed coordinates X, Y.
+type Screw struct {
+	Name string
+	X, Y float64
+}
+
+// Common thread pitches of bed screws, in mm per turn.
+const (
+	PitchM3 = 0.5
+	PitchM4 = 0.7
+	PitchM5 = 0.8
+)
+
+// TrammingConfig describes the bed's adjusters. The first screw is the
+// reference the others are brought level with.
+type TrammingConfig struct {
+	Screws []Screw
+	Pitch  float64
+	// ClockwiseRaises is set when turning a knob clockwise raises that
+	// corner of the bed; on most printers with knobs under the bed it
+	// lowers it.
+	ClockwiseRaises bool
+	Tolerance       float64
+}
+
+// ScrewAdjustment is the turn needed at one screw.
+type ScrewAdjustment struct {
+	Screw
+	Z float64
+	// Delta is how far this corner sits above the reference screw.
+	Delta float64
+	// Degrees to turn, positive clockwise.
+	Degrees float64
+}
+
+// Level reports whether the screw is within tolerance of the reference.
+func (a ScrewAdjustment) Level(tolerance float64) bool {
+	return math.Abs(a.Delta) <= tolerance
+}
+
+// Instruction phrases the adjustment for a technician.
+func (a ScrewAdjustment) Instruction(tolerance float64) string {
+	if a.Level(tolerance) {
+		return fmt.Sprintf("%s: OK (%+.3f mm)", a.Name, a.Delta)
+	}
+	dir := "clockwise"
+	if a.Degrees < 0 {
+		dir = "counter-clockwise"
+	}
+	deg := math.Abs(a.Degrees)
+	return fmt.Sprintf("%s: turn %.0f degrees %s (%.2f turns, %+.3f mm)", a.Name, deg, dir, deg/360, a.Delta)
+}
+
+// Adjustments turns measured heights at each screw, in the order of
+// cfg.Screws, into per-screw instructions.
+func (cfg TrammingConfig) Adjustments(heights []float64) ([]ScrewAdjustment, error) {
+	if len(heights) != len(cfg.Screws) || len(heights) == 0 {
+		return nil, fmt.Errorf("need one height per screw")
+	}
+	if cfg.Pitch <= 0 {
+		return nil, fmt.Errorf("thread pitch must be positive")
+	}
+	ref := heights[0]
+	adj := make([]ScrewAdjustment, len(heights))
+	for i, z := range heights {
+		delta := z - ref
+		// A high corner has to come down.
+		degrees := -delta / cfg.Pitch * 360
+		if !cfg.ClockwiseRaises {
+			degrees = -degrees
+		}
+		adj[i] = ScrewAdjustment{Screw: cfg.Screws[i], Z: z, Delta: delta, Degrees: degrees}
+	}
+	return adj, nil
+}
+
+// Level reports whether every screw is within tolerance.
+func (cfg TrammingConfig) Level(adj []ScrewAdjustment) bool {
+	for _, a := range adj {
+		if !a.Level(cfg.Tolerance) {
+			return false
+		}
+	}
+	return true
+}
+
+// HeightAt interpolates the mesh bilinearly at bed position x, y, clamping
+// to the edge of the grid. Unprobed neighbours make the result unknown.
+func (m *Mesh) HeightAt(x, y float64) (float64, bool) {
+	if !m.HasBounds() || m.Rows() == 0 || m.Cols() == 0 {
+		return 0, false
+	}
+	fx := gridFraction(x, m.MinX, m.MaxX, m.Cols())
+	fy := gridFraction(y, m.MinY, m.MaxY, m.Rows())
+	i0, j0 := int(math.Floor(fx)), int(math.Floor(fy))
+	i1, j1 := min(i0+1, m.Cols()-1), min(j0+1, m.Rows()-1)
+	tx, ty := fx-float64(i0), fy-float64(j0)
+	z := (m.Z[j0][i0]*(1-tx)+m.Z[j0][i1]*tx)*(1-ty) +
+		(m.Z[j1][i0]*(1-tx)+m.Z[j1][i1]*tx)*ty
+	return z, !math.IsNaN(z)
+}
+
+func gridFraction(v, lo, hi float64, n int) float64 {
+	if n < 2 {
+		return 0
+	}
+	f := (v - lo) / (hi - lo) * float64(n-1)
+	return math.Max(0, math.Min(float64(n-1), f))
+}
+
+// ProbeAt probes the bed once with G30 at x, y and returns the height.
+func (c *Client) ProbeAt(ctx context.Context, x, y float64) (float64, error) {
+	cmd := fmt.Sprintf("G30 X%.2f Y%.2f", x, y)
+	resp, err := c.SendAndWait(ctx, cmd)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", cmd, err)
+	}
+	m := reProbeResult.FindStringSubmatch(resp.Find("Bed X:"))
+	if m == nil {
+		return 0, fmt.Errorf("%s: no probe result reported", cmd)
+	}
+	return strconv.ParseFloat(m[3], 64)
+}
+
+// ProbeScrews probes only the screw positions, in order.
+func (c *Client) ProbeScrews(ctx context.Context, screws []Screw) ([]float64, error) {
+	heights := make([]float64, len(screws))
+	for i, s := range screws {
+		z, err := c.ProbeAt(ctx, s.X, s.Y)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Name, err)
+		}
+		heights[i] = z
+	}
+	return heights, nil
+}
@@ -0,0 +1,61 @@
+package printer
+
+import (
+	"io"
+	"net"
+	"time"
+
+	goserial "go.bug.st/serial"
+)
+
+// readTimeout bounds each Read so the read loop can notice Disconnect.
+const readTimeout = 500 * time.Millisecond
+
+// Transport is the byte stream to a printer. Reads must return periodically
+// even when the printer is silent, either with (0, nil) or with an error
+// whose Timeout method reports true.
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// OpenSerial opens a local serial port.
+func OpenSerial(portName string, baud int) (Transport, error) {
+	port, err := goserial.Open(portName, &goserial.Mode{BaudRate: baud})
+	if err != nil {
+		return nil, err
+	}
+	if err := port.SetReadTimeout(readTimeout); err != nil {
+		port.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+// DialTCP connects to a printer exposed as a raw TCP socket, such as ser2net
+// or an ESP3D board.
+func DialTCP(addr string) (Transport, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &deadlineConn{conn}, nil
+}
+
+// NewPipe returns an in-memory transport together with the device end of
+// the pipe, which sees the client's writes and answers through its own.
+func NewPipe() (Transport, io.ReadWriteCloser) {
+	host, device := net.Pipe()
+	return &deadlineConn{host}, device
+}
+
+// deadlineConn gives a net.Conn the periodic read timeout Transport needs.
+type deadlineConn struct {
+	net.Conn
+}
+
+func (d *deadlineConn) Read(p []byte) (int, error) {
+	if err := d.Conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+		return 0, err
+	}
+	return d.Conn.Read(p)
+}
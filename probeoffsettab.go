@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andlabs/ui"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+)
+
+// probeOffsetTab measures the probe's X/Y offset from the nozzle: the
+// nozzle marks a spot, the probe is jogged over it, and the difference
+// between the two positions is the offset.
+type probeOffsetTab struct {
+	client    *printer.Client
+	hint      *ui.Label
+	markX     *ui.Entry
+	markY     *ui.Entry
+	moveBtn   *ui.Button
+	markedBtn *ui.Button
+	jogBtns   []*ui.Button
+	pinBtns   []*ui.Button
+	posLabel  *ui.Label
+	result    *ui.Label
+	applyBtn  *ui.Button
+	mark      printer.Position
+	probeAt   printer.Position
+	old       printer.ProbeOffset
+	busy      bool
+	stage     int
+	connected bool
+	hasProbe  bool
+	canSave   bool
+}
+
+func newProbeOffsetTab(client *printer.Client) *probeOffsetTab {
+	return &probeOffsetTab{client: client, hasProbe: true, canSave: true}
+}
+
+func (t *probeOffsetTab) Build() ui.Control {
+	vbox := ui.NewVerticalBox()
+	vbox.SetPadded(true)
+	t.hint = ui.NewLabel("")
+	vbox.Append(t.hint, false)
+
+	// Stage 1
+	stage1 := ui.NewGroup("Stage 1")
+	stage1.SetMargined(true)
+	stage1Box := ui.NewVerticalBox()
+	stage1Box.SetPadded(true)
+	row := ui.NewHorizontalBox()
+	row.SetPadded(true)
+	row.Append(ui.NewLabel("Mark at X"), false)
+	t.markX = ui.NewEntry()
+	t.markX.SetText("110")
+	row.Append(t.markX, false)
+	row.Append(ui.NewLabel("Y"), false)
+	t.markY = ui.NewEntry()
+	t.markY.SetText("110")
+	row.Append(t.markY, false)
+	t.moveBtn = ui.NewButton("Home and Move Nozzle There")
+	t.moveBtn.OnClicked(func(*ui.Button) {
+		t.moveToMark()
+	})
+	row.Append(t.moveBtn, false)
+	stage1Box.Append(row, false)
+	stage1Box.Append(ui.NewLabel("Put tape on the bed and mark the spot right under the nozzle tip."), false)
+	t.markedBtn = ui.NewButton("Marked, Raise Nozzle")
+	t.markedBtn.OnClicked(func(*ui.Button) {
+		t.recordMark()
+	})
+	stage1Box.Append(t.markedBtn, false)
+	stage1.SetChild(stage1Box)
+	vbox.Append(stage1, false)
+
+	// Stage 2
+	stage2 := ui.NewGroup("Stage 2")
+	stage2.SetMargined(true)
+	stage2Box := ui.NewVerticalBox()
+	stage2Box.SetPadded(true)
+	stage2Box.Append(ui.NewLabel("Jog until the probe is centred over the mark"), false)
+	for _, axis := range []string{"X", "Y"} {
+		row := ui.NewHorizontalBox()
+		row.SetPadded(true)
+		for _, d := range []float64{-10, -1, -0.1, 0.1, 1, 10} {
+			delta, ax := d, axis
+			btn := ui.NewButton(fmt.Sprintf("%s %+g", ax, delta))
+			btn.OnClicked(func(*ui.Button) {
+				if ax == "X" {
+					t.jog(delta, 0)
+				} else {
+					t.jog(0, delta)
+				}
+			})
+			row.Append(btn, false)
+			t.jogBtns = append(t.jogBtns, btn)
+		}
+		stage2Box.Append(row, false)
+	}
+	pinRow := ui.NewHorizontalBox()
+	pinRow.SetPadded(true)
+	deploy := ui.NewButton("Deploy Pin")
+	deploy.OnClicked(func(*ui.Button) {
+		t.run(t.client.DeployProbe)
+	})
+	stow := ui.NewButton("Stow Pin")
+	stow.OnClicked(func(*ui.Button) {
+		t.run(t.client.StowProbe)
+	})
+	t.pinBtns = []*ui.Button{deploy, stow}
+	pinRow.Append(deploy, false)
+	pinRow.Append(stow, false)
+	t.posLabel = ui.NewLabel("")
+	pinRow.Append(t.posLabel, true)
+	stage2Box.Append(pinRow, false)
+	stage2.SetChild(stage2Box)
+	vbox.Append(stage2, false)
+
+	// Stage 3
+	stage3 := ui.NewGroup("Stage 3")
+	stage3.SetMargined(true)
+	stage3Box := ui.NewVerticalBox()
+	stage3Box.SetPadded(true)
+	t.result = ui.NewLabel("")
+	stage3Box.Append(t.result, false)
+	t.applyBtn = ui.NewButton("Apply")
+	t.applyBtn.OnClicked(func(*ui.Button) {
+		t.apply()
+	})
+	stage3Box.Append(t.applyBtn, false)
+	stage3.SetChild(stage3Box)
+	vbox.Append(stage3, false)
+
+	t.OnConnectionChanged(false)
+	return vbox
+}
+
+// offset is the measured probe offset: where the nozzle was when it made
+// the mark minus where it is with the probe over the mark.
+func (t *probeOffsetTab) offset() (x, y float64) {
+	return t.mark.X - t.probeAt.X, t.mark.Y - t.probeAt.Y
+}
+
+func (t *probeOffsetTab) moveToMark() {
+	x, errX := parseEntry(t.markX)
+	y, errY := parseEntry(t.markY)
+	if errX != nil || errY != nil {
+		t.hint.SetText("Enter the mark position in mm.")
+		return
+	}
+	t.setStage(0)
+	t.hint.SetText("Homing...")
+	t.run(func(ctx context.Context) error {
+		old, err := t.client.ReadProbeOffset(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := t.client.SendAndWait(ctx, "G28"); err != nil {
+			return err
+		}
+		if err := t.client.MoveTo(ctx, x, y, 0.5); err != nil {
+			return err
+		}
+		ui.QueueMain(func() {
+			t.old = old
+			t.hint.SetText("")
+			t.setStage(1)
+		})
+		return nil
+	})
+}
+
+func (t *probeOffsetTab) recordMark() {
+	t.run(func(ctx context.Context) error {
+		mark, err := t.client.ReadPosition(ctx)
+		if err != nil {
+			return err
+		}
+		if err := t.client.MoveTo(ctx, mark.X, mark.Y, 10); err != nil {
+			return err
+		}
+		ui.QueueMain(func() {
+			t.mark, t.probeAt = mark, mark
+			t.setStage(2)
+			t.showOffset()
+		})
+		return nil
+	})
+}
+
+func (t *probeOffsetTab) jog(dx, dy float64) {
+	t.run(func(ctx context.Context) error {
+		if err := t.client.JogXY(ctx, dx, dy); err != nil {
+			return err
+		}
+		pos, err := t.client.ReadPosition(ctx)
+		if err != nil {
+			return err
+		}
+		ui.QueueMain(func() {
+			t.probeAt = pos
+			t.showOffset()
+		})
+		return nil
+	})
+}
+
+func (t *probeOffsetTab) showOffset() {
+	x, y := t.offset()
+	t.posLabel.SetText(fmt.Sprintf("Nozzle at X%.2f Y%.2f", t.probeAt.X, t.probeAt.Y))
+	t.result.SetText(fmt.Sprintf("Current offset: X%.2f Y%.2f\nMeasured offset: X%.2f Y%.2f", t.old.X, t.old.Y, x, y))
+}
+
+func (t *probeOffsetTab) apply() {
+	x, y := t.offset()
+	save := t.canSave
+	t.run(func(ctx context.Context) error {
+		if err := t.client.ApplyProbeXYOffset(ctx, x, y); err != nil {
+			return err
+		}
+		if save {
+			if err := t.client.SaveSettings(ctx); err != nil {
+				return err
+			}
+		}
+		_ = t.client.StowProbe(ctx)
+		ui.QueueMain(func() {
+			if save {
+				t.hint.SetText(fmt.Sprintf("Probe offset X%.2f Y%.2f applied and saved.", x, y))
+			} else {
+				t.hint.SetText(fmt.Sprintf("Probe offset X%.2f Y%.2f applied; firmware has no EEPROM, so it is lost on reset.", x, y))
+			}
+			t.setStage(0)
+		})
+		return nil
+	})
+}
+
+// run does f off the UI thread with the wizard's buttons disabled and
+// shows any error in the hint.
+func (t *probeOffsetTab) run(f func(ctx context.Context) error) {
+	t.busy = true
+	t.updateButtons()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		err := f(ctx)
+		ui.QueueMain(func() {
+			t.busy = false
+			if err != nil {
+				t.hint.SetText("Failed: " + err.Error())
+			}
+			t.updateButtons()
+		})
+	}()
+}
+
+// setStage enables the wizard up to stage n; 0 means only stage 1's first
+// step is available. Call on the UI thread.
+func (t *probeOffsetTab) setStage(n int) {
+	t.stage = n
+	if n < 2 {
+		t.posLabel.SetText("")
+		t.result.SetText("")
+	}
+	t.updateButtons()
+}
+
+func (t *probeOffsetTab) updateButtons() {
+	ready := t.connected && t.hasProbe && !t.busy
+	enable := func(b *ui.Button, on bool) {
+		if on {
+			b.Enable()
+		} else {
+			b.Disable()
+		}
+	}
+	enable(t.moveBtn, ready)
+	enable(t.markedBtn, ready && t.stage >= 1)
+	for _, b := range append(t.jogBtns, t.pinBtns...) {
+		enable(b, ready && t.stage >= 2)
+	}
+	enable(t.applyBtn, ready && t.stage >= 2)
+}
+
+func (t *probeOffsetTab) OnCapabilities(caps printer.Capabilities) {
+	ui.QueueMain(func() {
+		t.canSave = caps.Supports(printer.CapEEPROM)
+		t.hasProbe = caps.Supports(printer.CapZProbe)
+		if !t.hasProbe {
+			t.hint.SetText("Firmware reports no Z probe; there is no probe offset to measure.")
+		}
+		t.updateButtons()
+	})
+}
+
+func (t *probeOffsetTab) OnConnectionChanged(connected bool) {
+	ui.QueueMain(func() {
+		t.connected = connected
+		t.hasProbe = true
+		if connected {
+			t.hint.SetText("")
+		} else {
+			t.hint.SetText("Connect first to measure the probe offset.")
+		}
+		t.setStage(0)
+	})
+}
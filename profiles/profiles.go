@@ -0,0 +1,200 @@
+// Package profiles stores named printer profiles: how to reach a printer
+// and the settings the calibration steps should start from.
+package profiles
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"go.bug.st/serial/enumerator"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+)
+
+// Transports a profile can use.
+const (
+	TransportSerial      = "serial"
+	TransportTCP         = "tcp"
+	TransportSimUBL      = "sim-ubl"
+	TransportSimBilinear = "sim-bilinear"
+	TransportSimManual   = "sim-mbl"
+)
+
+// Preset is a named pair of temperatures, e.g. for a filament type.
+type Preset struct {
+	Name   string  `json:"name"`
+	Hotend float64 `json:"hotend"`
+	Bed    float64 `json:"bed"`
+}
+
+type Profile struct {
+	Name      string `json:"name"`
+	Transport string `json:"transport"`
+	Port      string `json:"port,omitempty"`
+	// USBSerial, when set, finds the printer by the USB adapter's serial
+	// number, so the profile survives the port name changing.
+	USBSerial string `json:"usb_serial,omitempty"`
+	Baud      int    `json:"baud,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Checksums bool   `json:"checksums,omitempty"`
+	// Firmware is the firmware name last reported by M115.
+	Firmware    string              `json:"firmware,omitempty"`
+	Preheat     []Preset            `json:"preheat"`
+	BedX        float64             `json:"bed_x"`
+	BedY        float64             `json:"bed_y"`
+	ProbeOffset printer.ProbeOffset `json:"probe_offset"`
+	// Leveling is "", "ubl", "bilinear" or "mbl"; empty follows the firmware.
+	Leveling string            `json:"leveling,omitempty"`
+	MeshSlot int               `json:"mesh_slot,omitempty"`
+	G26      printer.G26Params `json:"g26"`
+	// TestPrint is the generated first-layer test, for firmware without G26.
+	TestPrint printer.TestPrint `json:"test_print"`
+}
+
+// New returns a profile with common defaults for a 220 mm bed.
+func New(name string) Profile {
+	return Profile{
+		Name:      name,
+		Transport: TransportSerial,
+		Baud:      115200,
+		Preheat: []Preset{
+			{Name: "PLA", Hotend: 210, Bed: 60},
+			{Name: "PETG", Hotend: 240, Bed: 80},
+			{Name: "ABS", Hotend: 250, Bed: 100},
+		},
+		BedX:      220,
+		BedY:      220,
+		G26:       printer.DefaultG26Params,
+		TestPrint: printer.DefaultTestPrint,
+	}
+}
+
+// ResolvePort returns the serial port to open, looking the printer up by
+// USB serial number when the profile has one.
+func (p Profile) ResolvePort() (string, error) {
+	if p.USBSerial == "" {
+		return p.Port, nil
+	}
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return "", err
+	}
+	for _, d := range ports {
+		if d.IsUSB && d.SerialNumber == p.USBSerial {
+			return d.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no USB device with serial number %s", p.USBSerial)
+}
+
+// USBSerialOf returns the USB serial number of port, or "" if it has none.
+func USBSerialOf(port string) string {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return ""
+	}
+	for _, d := range ports {
+		if d.Name == port && d.IsUSB {
+			return d.SerialNumber
+		}
+	}
+	return ""
+}
+
+// Store is the profiles file.
+type Store struct {
+	LastUsed string    `json:"last_used,omitempty"`
+	Profiles []Profile `json:"profiles"`
+
+	path string
+}
+
+// DefaultPath is profiles.json in the user's configuration directory.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "printer-calibration-utility", "profiles.json"), nil
+}
+
+// Load reads the store at path. A missing file gives an empty store that
+// Save will create.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Save writes the store back, replacing the file only once the new one is
+// complete.
+func (s *Store) Save() error {
+	if s.path == "" {
+		return errors.New("profiles were not loaded from a file")
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *Store) Get(name string) (Profile, bool) {
+	for _, p := range s.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Put adds p, replacing any profile with the same name.
+func (s *Store) Put(p Profile) {
+	for i := range s.Profiles {
+		if s.Profiles[i].Name == p.Name {
+			s.Profiles[i] = p
+			return
+		}
+	}
+	s.Profiles = append(s.Profiles, p)
+}
+
+func (s *Store) Delete(name string) {
+	for i := range s.Profiles {
+		if s.Profiles[i].Name == name {
+			s.Profiles = append(s.Profiles[:i], s.Profiles[i+1:]...)
+			break
+		}
+	}
+	if s.LastUsed == name {
+		s.LastUsed = ""
+	}
+}
+
+func (s *Store) Names() []string {
+	names := make([]string, len(s.Profiles))
+	for i, p := range s.Profiles {
+		names[i] = p.Name
+	}
+	return names
+}
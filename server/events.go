@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+)
+
+// event is one message on the /api/events stream.
+type event struct {
+	Type         string              `json:"type"`
+	Text         string              `json:"text,omitempty"`
+	Temperature  *printer.TempReport `json:"temperature,omitempty"`
+	Capabilities *capsJSON           `json:"capabilities,omitempty"`
+}
+
+// subscriberBuffer is how many events a slow WebSocket client may fall
+// behind before further events are dropped for it.
+const subscriberBuffer = 256
+
+// hub fans events out to every connected WebSocket.
+type hub struct {
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[chan []byte]struct{})}
+}
+
+func (h *hub) subscribe() chan []byte {
+	ch := make(chan []byte, subscriberBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *hub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// publish never blocks: it is called from the client's read loop.
+func (h *hub) publish(e event) {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.originAllowed}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied.
+		return
+	}
+	defer conn.Close()
+
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	// The stream is one-way; reading only notices the peer going away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case msg := <-ch:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
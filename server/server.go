@@ -0,0 +1,467 @@
+// Package server exposes a printer.Client over a local HTTP API so other
+// tools, such as a print farm dashboard, can drive calibrations.
+//
+// All endpoints take and return JSON:
+//
+//	GET  /api/status        connection state and firmware capabilities
+//	POST /api/connect       {"transport": "serial"|"tcp"|"sim", "port", "baud", "addr", "sim", "checksums"}
+//	POST /api/disconnect
+//	POST /api/command       {"command": "M503"} returns the reply lines
+//	POST /api/zoffset       {"z": -1.2, "save": true}
+//	POST /api/preheat       {"hotend": 210, "bed": 60}; either may be omitted
+//	POST /api/monitor       {"enabled": true} turns temperature reports on or off
+//	POST /api/level         {"strategy": "auto"|"ubl"|"bilinear", "slot": 0}
+//	GET  /api/mesh          the active mesh; ?format=csv for CSV
+//	GET  /api/events        WebSocket stream of log, temperature and capabilities events
+//
+// Errors are returned as {"error": "..."} with a 4xx or 5xx status.
+//
+// Requests must name the server by a loopback address or one listed in
+// AllowHosts, so a page that rebinds its own domain to 127.0.0.1 cannot
+// reach the API.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+	"github.com/nulldozer/printer-calibration-utility/simulator"
+)
+
+// Server serves the API for one client. Create it with New.
+type Server struct {
+	client *printer.Client
+	// AllowOrigins lists browser origins, e.g. "http://dashboard.local:3000",
+	// that may call the API and open the event stream. Requests without an
+	// Origin header, such as from scripts, are always allowed.
+	AllowOrigins []string
+	// AllowHosts lists host names or IPs, besides loopback ones, that
+	// requests may address the server by in their Host header.
+	AllowHosts []string
+
+	events *hub
+	mux    *http.ServeMux
+}
+
+// New returns a server for client and subscribes to its listeners. The
+// client may already be connected.
+func New(client *printer.Client) *Server {
+	s := &Server{client: client, events: newHub(), mux: http.NewServeMux()}
+	client.AddLogListener(func(text string) {
+		s.events.publish(event{Type: "log", Text: text})
+	})
+	client.AddTempListener(func(r printer.TempReport) {
+		s.events.publish(event{Type: "temperature", Temperature: &r})
+	})
+	client.AddCapabilitiesListener(func(caps printer.Capabilities) {
+		s.events.publish(event{Type: "capabilities", Capabilities: toCapsJSON(caps)})
+	})
+
+	s.mux.HandleFunc("GET /api/status", s.handleStatus)
+	s.mux.HandleFunc("POST /api/connect", s.handleConnect)
+	s.mux.HandleFunc("POST /api/disconnect", s.handleDisconnect)
+	s.mux.HandleFunc("POST /api/command", s.handleCommand)
+	s.mux.HandleFunc("POST /api/zoffset", s.handleZOffset)
+	s.mux.HandleFunc("POST /api/preheat", s.handlePreheat)
+	s.mux.HandleFunc("POST /api/monitor", s.handleMonitor)
+	s.mux.HandleFunc("POST /api/level", s.handleLevel)
+	s.mux.HandleFunc("GET /api/mesh", s.handleMesh)
+	s.mux.HandleFunc("GET /api/events", s.handleEvents)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.hostAllowed(r.Host) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("host %q not allowed", r.Host))
+		return
+	}
+	origin := r.Header.Get("Origin")
+	if origin != "" {
+		if !s.originAllowed(r) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("origin %s not allowed", origin))
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	// Insisting on a JSON body makes browsers preflight every POST, so a
+	// page on another origin cannot send G-code with a plain form.
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, errors.New("expected application/json"))
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// hostAllowed reports whether a Host header names the server by a loopback
+// address or one in AllowHosts. The port is not checked.
+func (s *Server) hostAllowed(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "" {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, h := range s.AllowHosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// originAllowed reports whether r comes from a script or an origin listed
+// in AllowOrigins. The server serves no pages of its own, so no origin is
+// trusted for matching the Host header.
+func (s *Server) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range s.AllowOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+type capsJSON struct {
+	FirmwareName  string          `json:"firmware_name"`
+	MachineType   string          `json:"machine_type"`
+	ExtruderCount int             `json:"extruder_count"`
+	Leveling      string          `json:"leveling"`
+	Caps          map[string]bool `json:"caps"`
+}
+
+func toCapsJSON(caps printer.Capabilities) *capsJSON {
+	return &capsJSON{
+		FirmwareName:  caps.FirmwareName,
+		MachineType:   caps.MachineType,
+		ExtruderCount: caps.ExtruderCount,
+		Leveling:      string(caps.Leveling),
+		Caps:          caps.Caps,
+	}
+}
+
+type statusJSON struct {
+	Connected    bool      `json:"connected"`
+	Capabilities *capsJSON `json:"capabilities,omitempty"`
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	st := statusJSON{Connected: s.client.IsConnected()}
+	if st.Connected {
+		st.Capabilities = toCapsJSON(s.client.Capabilities())
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+type connectRequest struct {
+	Transport string `json:"transport"`
+	Port      string `json:"port"`
+	Baud      int    `json:"baud"`
+	Addr      string `json:"addr"`
+	Sim       string `json:"sim"`
+	Checksums bool   `json:"checksums"`
+}
+
+// handleConnect returns once the transport is open. Capabilities follow
+// as an event when detection finishes.
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	var req connectRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var (
+		transport printer.Transport
+		err       error
+	)
+	switch req.Transport {
+	case "serial":
+		if req.Baud == 0 {
+			req.Baud = 115200
+		}
+		transport, err = printer.OpenSerial(req.Port, req.Baud)
+	case "tcp":
+		transport, err = printer.DialTCP(req.Addr)
+	case "sim":
+		sim := simulator.New()
+		switch req.Sim {
+		case "", "ubl":
+		case "bilinear":
+			sim.Leveling = simulator.Bilinear
+		case "mbl":
+			sim.Leveling = simulator.Manual
+			sim.Probe = false
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown simulator %q", req.Sim))
+			return
+		}
+		var device io.ReadWriteCloser
+		transport, device = printer.NewPipe()
+		go func() {
+			_ = sim.Serve(device)
+		}()
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown transport %q", req.Transport))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	s.client.SetChecksums(req.Checksums)
+	if err := s.client.ConnectTransport(transport); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statusJSON{Connected: true})
+}
+
+func (s *Server) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	if err := s.client.Disconnect(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statusJSON{Connected: false})
+}
+
+type commandJSON struct {
+	Command string   `json:"command"`
+	Lines   []string `json:"lines"`
+}
+
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
+	var req commandJSON
+	if !readJSON(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+	resp, err := s.client.SendAndWait(ctx, req.Command)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	lines := resp.Lines
+	if lines == nil {
+		lines = []string{}
+	}
+	writeJSON(w, http.StatusOK, commandJSON{Command: resp.Command, Lines: lines})
+}
+
+type zOffsetRequest struct {
+	Z    *float64 `json:"z"`
+	Save bool     `json:"save"`
+}
+
+func (s *Server) handleZOffset(w http.ResponseWriter, r *http.Request) {
+	var req zOffsetRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Z == nil {
+		writeError(w, http.StatusBadRequest, errors.New("z is required"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	if err := s.client.ApplyZOffset(ctx, *req.Z); err != nil {
+		writeClientError(w, err)
+		return
+	}
+	if req.Save {
+		if err := s.client.SaveSettings(ctx); err != nil {
+			writeClientError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+type preheatRequest struct {
+	Hotend *float64 `json:"hotend"`
+	Bed    *float64 `json:"bed"`
+}
+
+func (s *Server) handlePreheat(w http.ResponseWriter, r *http.Request) {
+	var req preheatRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Hotend != nil {
+		if err := s.client.PreheatHotend(*req.Hotend); err != nil {
+			writeClientError(w, err)
+			return
+		}
+	}
+	if req.Bed != nil {
+		if err := s.client.PreheatBed(*req.Bed); err != nil {
+			writeClientError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+type monitorRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (s *Server) handleMonitor(w http.ResponseWriter, r *http.Request) {
+	var req monitorRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var err error
+	if req.Enabled {
+		err = s.client.StartTempMonitoring()
+	} else {
+		err = s.client.StopTempMonitoring()
+	}
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+type levelRequest struct {
+	Strategy string `json:"strategy"`
+	Slot     int    `json:"slot"`
+}
+
+type levelJSON struct {
+	Strategy string `json:"strategy"`
+	System   string `json:"system"`
+	printer.MeshStats
+}
+
+// handleLevel blocks until leveling finishes, which takes minutes. Manual
+// mesh leveling needs someone at the printer and is not offered.
+func (s *Server) handleLevel(w http.ResponseWriter, r *http.Request) {
+	var req levelRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var strategy printer.LevelingStrategy
+	switch req.Strategy {
+	case "", "auto":
+		strategy = printer.StrategyFor(s.client.Capabilities())
+	case "ubl":
+		strategy = printer.UBLStrategy{}
+	case "bilinear":
+		strategy = printer.BilinearStrategy{}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown strategy %q", req.Strategy))
+		return
+	}
+	if _, manual := strategy.(*printer.ManualMeshStrategy); manual {
+		writeError(w, http.StatusBadRequest, errors.New("manual mesh leveling needs the GUI or CLI"))
+		return
+	}
+	if ubl, ok := strategy.(printer.UBLStrategy); ok {
+		ubl.Slot = req.Slot
+		strategy = ubl
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Minute)
+	defer cancel()
+	if err := s.client.RunBedLevelingRoutine(ctx, strategy); err != nil {
+		writeClientError(w, err)
+		return
+	}
+	mesh, err := s.client.ReadMesh(ctx, strategy.System())
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, levelJSON{
+		Strategy:  strategy.Name(),
+		System:    string(strategy.System()),
+		MeshStats: mesh.Stats(),
+	})
+}
+
+func (s *Server) handleMesh(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	system := printer.StrategyFor(s.client.Capabilities()).System()
+	mesh, err := s.client.ReadMesh(ctx, system)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		mesh.WriteCSV(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	mesh.WriteJSON(w)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeClientError maps client errors to a status: firmware errors are the
+// printer refusing, timeouts are the printer not answering.
+func writeClientError(w http.ResponseWriter, err error) {
+	var fwErr *printer.FirmwareError
+	switch {
+	case !errors.Is(err, context.Canceled) && errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, err)
+	case errors.As(err, &fwErr):
+		writeError(w, http.StatusUnprocessableEntity, err)
+	case errors.Is(err, printer.ErrDisconnected), errors.Is(err, printer.ErrNotConnected):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusBadGateway, err)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andlabs/ui"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+)
+
+type settingsTab struct {
+	client        *printer.Client
+	window        *ui.Window
+	hint          *ui.Label
+	status        *ui.Label
+	readBtn       *ui.Button
+	saveBtn       *ui.Button
+	compareBtn    *ui.Button
+	compareFiles  *ui.Button
+	restoreBtn    *ui.Button
+	saveEEPROM    *ui.Checkbox
+	current       *ui.MultilineEntry
+	diff          *settingsDiff
+	live          *printer.SettingsSnapshot
+	baseName      string
+	busy          bool
+	connected     bool
+	canSaveEEPROM bool
+}
+
+func newSettingsTab(client *printer.Client, window *ui.Window) *settingsTab {
+	return &settingsTab{client: client, window: window, canSaveEEPROM: true}
+}
+
+func (t *settingsTab) Build() ui.Control {
+	vbox := ui.NewVerticalBox()
+	vbox.SetPadded(true)
+
+	t.hint = ui.NewLabel("")
+	vbox.Append(t.hint, false)
+
+	row := ui.NewHorizontalBox()
+	row.SetPadded(true)
+	t.readBtn = ui.NewButton("Read from Printer")
+	t.readBtn.OnClicked(func(*ui.Button) {
+		t.readLive()
+	})
+	t.saveBtn = ui.NewButton("Save Snapshot...")
+	t.saveBtn.OnClicked(func(*ui.Button) {
+		t.saveSnapshot()
+	})
+	t.compareBtn = ui.NewButton("Compare Snapshot with Printer...")
+	t.compareBtn.OnClicked(func(*ui.Button) {
+		t.compareWithPrinter()
+	})
+	t.compareFiles = ui.NewButton("Compare Two Snapshots...")
+	t.compareFiles.OnClicked(func(*ui.Button) {
+		t.compareSnapshots()
+	})
+	row.Append(t.readBtn, false)
+	row.Append(t.saveBtn, false)
+	row.Append(t.compareBtn, false)
+	row.Append(t.compareFiles, false)
+	vbox.Append(row, false)
+
+	t.status = ui.NewLabel("")
+	vbox.Append(t.status, false)
+
+	currentGroup := ui.NewGroup("Printer Settings")
+	currentGroup.SetMargined(true)
+	t.current = ui.NewNonWrappingMultilineEntry()
+	t.current.SetReadOnly(true)
+	currentGroup.SetChild(t.current)
+	vbox.Append(currentGroup, true)
+
+	diffGroup := ui.NewGroup("Differences")
+	diffGroup.SetMargined(true)
+	diffBox := ui.NewVerticalBox()
+	diffBox.SetPadded(true)
+	t.diff = newSettingsDiff()
+	diffBox.Append(t.diff.table, true)
+	restoreRow := ui.NewHorizontalBox()
+	restoreRow.SetPadded(true)
+	t.restoreBtn = ui.NewButton("Restore Selected")
+	t.restoreBtn.OnClicked(func(*ui.Button) {
+		t.restoreSelected()
+	})
+	t.saveEEPROM = ui.NewCheckbox("Save to EEPROM (M500)")
+	t.saveEEPROM.SetChecked(true)
+	restoreRow.Append(t.restoreBtn, false)
+	restoreRow.Append(t.saveEEPROM, false)
+	diffBox.Append(restoreRow, false)
+	diffGroup.SetChild(diffBox)
+	vbox.Append(diffGroup, true)
+
+	t.OnConnectionChanged(false)
+	return vbox
+}
+
+func (t *settingsTab) setStatus(text string) {
+	ui.QueueMain(func() {
+		t.status.SetText(text)
+	})
+}
+
+func (t *settingsTab) readLive() {
+	t.setBusy(true)
+	go func() {
+		defer t.setBusy(false)
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		snap, err := t.client.ReadSettings(ctx)
+		if err != nil {
+			t.setStatus("Could not read settings: " + err.Error())
+			return
+		}
+		ui.QueueMain(func() {
+			t.showLive(snap)
+			t.status.SetText(fmt.Sprintf("Read %d settings at %s.", len(snap.Settings), snap.Time.Format("15:04:05")))
+		})
+	}()
+}
+
+// showLive lists snap grouped under the firmware's section headings.
+func (t *settingsTab) showLive(snap *printer.SettingsSnapshot) {
+	t.live = snap
+	var b strings.Builder
+	section := ""
+	for _, s := range snap.Settings {
+		if s.Section != section {
+			section = s.Section
+			fmt.Fprintf(&b, "; %s\n", section)
+		}
+		fmt.Fprintf(&b, "%s\n", s.Command())
+	}
+	t.current.SetText(b.String())
+	t.updateButtons()
+}
+
+func (t *settingsTab) saveSnapshot() {
+	if t.live == nil {
+		t.status.SetText("Read the settings from the printer before saving them.")
+		return
+	}
+	path := ui.SaveFile(t.window)
+	if path == "" {
+		return
+	}
+	if filepath.Ext(path) == "" {
+		path += ".json"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.status.SetText("Save failed: " + err.Error())
+		return
+	}
+	err = t.live.WriteJSON(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.status.SetText("Save failed: " + err.Error())
+		return
+	}
+	t.status.SetText("Snapshot saved to " + path)
+}
+
+func (t *settingsTab) openSnapshot() (*printer.SettingsSnapshot, string) {
+	path := ui.OpenFile(t.window)
+	if path == "" {
+		return nil, ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.status.SetText("Could not open snapshot: " + err.Error())
+		return nil, ""
+	}
+	defer f.Close()
+	snap, err := printer.ReadSettingsJSON(f)
+	if err != nil {
+		t.status.SetText(fmt.Sprintf("Could not read %s: %v", filepath.Base(path), err))
+		return nil, ""
+	}
+	return snap, filepath.Base(path)
+}
+
+// compareWithPrinter diffs a saved snapshot against freshly read settings;
+// differences can then be restored from the snapshot.
+func (t *settingsTab) compareWithPrinter() {
+	base, name := t.openSnapshot()
+	if base == nil {
+		return
+	}
+	t.setBusy(true)
+	go func() {
+		defer t.setBusy(false)
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		live, err := t.client.ReadSettings(ctx)
+		if err != nil {
+			t.setStatus("Could not read settings: " + err.Error())
+			return
+		}
+		ui.QueueMain(func() {
+			t.showLive(live)
+			t.showDiff(base, live, name, "the printer", true)
+		})
+	}()
+}
+
+func (t *settingsTab) compareSnapshots() {
+	old, oldName := t.openSnapshot()
+	if old == nil {
+		return
+	}
+	cur, curName := t.openSnapshot()
+	if cur == nil {
+		return
+	}
+	t.showDiff(old, cur, oldName, curName, false)
+}
+
+func (t *settingsTab) showDiff(base, cur *printer.SettingsSnapshot, baseName, curName string, restorable bool) {
+	changes := printer.DiffSettings(base, cur)
+	t.baseName = baseName
+	t.diff.Set(base, changes, restorable)
+	switch {
+	case len(changes) == 0:
+		t.status.SetText(fmt.Sprintf("%s and %s match.", baseName, curName))
+	case restorable:
+		t.status.SetText(fmt.Sprintf("%d values differ between %s (Snapshot) and the printer (Current); tick the ones to restore.", len(changes), baseName))
+	default:
+		t.status.SetText(fmt.Sprintf("%d values differ between %s (Snapshot) and %s (Current).", len(changes), baseName, curName))
+	}
+	t.updateButtons()
+}
+
+func (t *settingsTab) restoreSelected() {
+	selected := t.diff.Selected()
+	cmds := printer.RestoreCommands(t.diff.base, selected)
+	if len(cmds) == 0 {
+		t.status.SetText("Tick the values to restore first.")
+		return
+	}
+	save := t.canSaveEEPROM && t.saveEEPROM.Checked()
+	base, baseName := t.diff.base, t.baseName
+	t.setBusy(true)
+	go func() {
+		defer t.setBusy(false)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := t.client.RestoreSettings(ctx, cmds, save); err != nil {
+			t.setStatus("Restore failed: " + err.Error())
+			return
+		}
+		live, err := t.client.ReadSettings(ctx)
+		if err != nil {
+			t.setStatus("Restored, but could not read the settings back: " + err.Error())
+			return
+		}
+		ui.QueueMain(func() {
+			t.showLive(live)
+			t.showDiff(base, live, baseName, "the printer", true)
+			msg := fmt.Sprintf("Restored %d settings", len(cmds))
+			if save {
+				msg += " and saved them to EEPROM"
+			}
+			t.status.SetText(msg + ". " + t.status.Text())
+		})
+	}()
+}
+
+func (t *settingsTab) setBusy(busy bool) {
+	ui.QueueMain(func() {
+		t.busy = busy
+		t.updateButtons()
+	})
+}
+
+func (t *settingsTab) updateButtons() {
+	live := t.connected && !t.busy
+	for _, btn := range []*ui.Button{t.readBtn, t.compareBtn} {
+		if live {
+			btn.Enable()
+		} else {
+			btn.Disable()
+		}
+	}
+	if live && t.diff.restorable {
+		t.restoreBtn.Enable()
+	} else {
+		t.restoreBtn.Disable()
+	}
+	if t.live != nil {
+		t.saveBtn.Enable()
+	} else {
+		t.saveBtn.Disable()
+	}
+	if t.canSaveEEPROM {
+		t.saveEEPROM.Enable()
+	} else {
+		t.saveEEPROM.Disable()
+	}
+}
+
+func (t *settingsTab) OnCapabilities(caps printer.Capabilities) {
+	ui.QueueMain(func() {
+		t.canSaveEEPROM = caps.Supports(printer.CapEEPROM)
+		if !t.canSaveEEPROM {
+			t.saveEEPROM.SetChecked(false)
+		}
+		t.updateButtons()
+	})
+}
+
+func (t *settingsTab) OnConnectionChanged(connected bool) {
+	ui.QueueMain(func() {
+		t.connected = connected
+		if connected {
+			t.hint.SetText("Take a snapshot before calibrating so any change can be undone.")
+		} else {
+			t.hint.SetText("Connect first to read settings; saved snapshots can still be compared.")
+		}
+		t.updateButtons()
+	})
+}
+
+// settingsDiff is the table of differing values. Its rows can be ticked
+// for restoring when the comparison is against the live printer.
+type settingsDiff struct {
+	table      *ui.Table
+	model      *ui.TableModel
+	base       *printer.SettingsSnapshot
+	changes    []printer.SettingChange
+	ticked     []bool
+	restorable bool
+}
+
+// Model columns of settingsDiff.
+const (
+	diffColTicked = iota
+	diffColKey
+	diffColParam
+	diffColOld
+	diffColNew
+	diffColEditable
+)
+
+func newSettingsDiff() *settingsDiff {
+	d := &settingsDiff{}
+	d.model = ui.NewTableModel(d)
+	d.table = ui.NewTable(&ui.TableParams{Model: d.model, RowBackgroundColorModelColumn: -1})
+	d.table.AppendCheckboxColumn("Restore", diffColTicked, diffColEditable)
+	d.table.AppendTextColumn("Setting", diffColKey, ui.TableModelColumnNeverEditable, nil)
+	d.table.AppendTextColumn("Parameter", diffColParam, ui.TableModelColumnNeverEditable, nil)
+	d.table.AppendTextColumn("Snapshot", diffColOld, ui.TableModelColumnNeverEditable, nil)
+	d.table.AppendTextColumn("Current", diffColNew, ui.TableModelColumnNeverEditable, nil)
+	return d
+}
+
+// Set replaces the rows. Call on the UI thread.
+func (d *settingsDiff) Set(base *printer.SettingsSnapshot, changes []printer.SettingChange, restorable bool) {
+	for i := len(d.changes) - 1; i >= 0; i-- {
+		d.changes = d.changes[:i]
+		d.model.RowDeleted(i)
+	}
+	d.base, d.restorable = base, restorable
+	d.ticked = make([]bool, len(changes))
+	for i := range changes {
+		d.changes = append(d.changes, changes[i])
+		d.model.RowInserted(i)
+	}
+}
+
+func (d *settingsDiff) Selected() []printer.SettingChange {
+	var out []printer.SettingChange
+	for i, ch := range d.changes {
+		if d.ticked[i] {
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+func (d *settingsDiff) ColumnTypes(*ui.TableModel) []ui.TableValue {
+	return []ui.TableValue{ui.TableInt(0), ui.TableString(""), ui.TableString(""), ui.TableString(""), ui.TableString(""), ui.TableInt(0)}
+}
+
+func (d *settingsDiff) NumRows(*ui.TableModel) int {
+	return len(d.changes)
+}
+
+func (d *settingsDiff) CellValue(_ *ui.TableModel, row, column int) ui.TableValue {
+	ch := d.changes[row]
+	switch column {
+	case diffColTicked:
+		return boolCell(d.ticked[row])
+	case diffColKey:
+		return ui.TableString(ch.Key)
+	case diffColParam:
+		return ui.TableString(ch.Param)
+	case diffColOld:
+		return ui.TableString(diffValue(ch.Old, ch.HasOld))
+	case diffColNew:
+		return ui.TableString(diffValue(ch.New, ch.HasNew))
+	default:
+		// only values the snapshot has can be restored
+		return boolCell(d.restorable && ch.HasOld)
+	}
+}
+
+func (d *settingsDiff) SetCellValue(_ *ui.TableModel, row, column int, value ui.TableValue) {
+	if column == diffColTicked {
+		d.ticked[row] = value.(ui.TableInt) != 0
+	}
+}
+
+func boolCell(b bool) ui.TableInt {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func diffValue(v string, ok bool) string {
+	if !ok {
+		return "(missing)"
+	}
+	return v
+}
@@ -0,0 +1,139 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const ambient = 22.0
+
+// heater is a first-order thermal model with a little sensor noise.
+type heater struct {
+	current float64
+	target  float64
+	power   float64
+}
+
+func newHeater() heater {
+	return heater{current: ambient}
+}
+
+func (h *heater) step(dt time.Duration, rng *rand.Rand) {
+	secs := dt.Seconds()
+	h.power = 0
+	if h.target > 0 {
+		// Proportional control plus enough power to hold against the
+		// losses, so the heater settles on the target.
+		hold := (h.current - ambient) * 0.01 / 3
+		h.power = math.Max(0, math.Min(1, (h.target-h.current)/10+hold))
+	}
+	// Heats at up to 3 C/s and loses heat in proportion to the difference
+	// from ambient.
+	h.current += (h.power*3 - (h.current-ambient)*0.01) * secs
+	h.current += (rng.Float64() - 0.5) * 0.1
+}
+
+func (h *heater) settled() bool {
+	return math.Abs(h.current-h.target) < 1
+}
+
+// tempReport formats heater state like Marlin's M105 and auto-report.
+// Callers hold p.mu.
+func (p *Printer) tempReport() string {
+	return fmt.Sprintf("T:%.2f /%.2f B:%.2f /%.2f @:%d B@:%d",
+		p.hotend.current, p.hotend.target,
+		p.bed.current, p.bed.target,
+		int(p.hotend.power*127), int(p.bed.power*127))
+}
+
+// pid holds the gains set by M301/M304. The thermal model ignores them.
+type pid struct {
+	kp, ki, kd float64
+}
+
+// m303 fakes a PID autotune: it heats to the target, reports one oscillation
+// per cycle and prints gains scattered around a plausible result.
+func (p *Printer) m303(args args) {
+	e, _ := args.get('E')
+	temp, _ := args.get('S')
+	cycles := 5
+	if c, ok := args.get('C'); ok {
+		cycles = int(math.Max(3, c))
+	}
+	var h *heater
+	var base pid
+	maxTemp := 0.0
+	switch int(e) {
+	case 0:
+		h, base, maxTemp = &p.hotend, pid{22.2, 1.08, 114}, 275
+	case -1:
+		h, base, maxTemp = &p.bed, pid{10, 0.023, 305.4}, 120
+	default:
+		p.println("PID Autotune failed! Bad extruder number")
+		return
+	}
+	if temp >= maxTemp {
+		p.println("PID Autotune failed! Temperature too high")
+		return
+	}
+
+	p.println("PID Autotune start")
+	p.mu.Lock()
+	h.target = temp
+	p.mu.Unlock()
+	for {
+		p.sleep(time.Second)
+		p.mu.Lock()
+		done := h.settled()
+		p.mu.Unlock()
+		if done {
+			break
+		}
+	}
+
+	for i := 1; i <= cycles; i++ {
+		p.sleep(10 * time.Second)
+		p.mu.Lock()
+		swing := 2 + p.rng.Float64()*2
+		ku := 40 + p.rng.Float64()*10
+		tu := 18 + p.rng.Float64()*4
+		p.mu.Unlock()
+		bias := 100 + int(swing*3)
+		line := fmt.Sprintf(" bias: %d d: %d min: %.2f max: %.2f", bias, bias, temp-swing, temp+swing)
+		if i > 2 {
+			line += fmt.Sprintf(" Ku: %.2f Tu: %.2f", ku, tu)
+		}
+		p.println("%s", line)
+	}
+
+	p.mu.Lock()
+	jitter := 0.9 + p.rng.Float64()*0.2
+	result := pid{base.kp * jitter, base.ki * jitter, base.kd * jitter}
+	h.target = 0
+	if int(e) == 0 {
+		p.hotendPID = result
+	} else {
+		p.bedPID = result
+	}
+	p.mu.Unlock()
+	p.println(" Classic PID ")
+	p.println(" Kp: %.2f Ki: %.2f Kd: %.2f", result.kp, result.ki, result.kd)
+	p.println("PID Autotune finished! Put the last Kp, Ki and Kd constants from below into Configuration.h")
+}
+
+// setPID handles M301 and M304.
+func (p *Printer) setPID(dst *pid, args args) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v, ok := args.get('P'); ok {
+		dst.kp = v
+	}
+	if v, ok := args.get('I'); ok {
+		dst.ki = v
+	}
+	if v, ok := args.get('D'); ok {
+		dst.kd = v
+	}
+}
@@ -0,0 +1,448 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// g29 dispatches to the leveling system the printer was built with.
+func (p *Printer) g29(args args) {
+	switch p.Leveling {
+	case Bilinear:
+		p.g29Bilinear()
+	case Manual:
+		p.g29Manual(args)
+	default:
+		p.g29UBL(args)
+	}
+}
+
+// g29UBL implements the Unified Bed Leveling subset the app uses.
+func (p *Printer) g29UBL(args args) {
+	switch {
+	case args.has('P'):
+		phase, _ := args.get('P')
+		switch int(phase) {
+		case 1:
+			p.probeMesh()
+		case 3:
+			p.println("echo:Mesh is already filled.")
+		default:
+			p.println("echo:?Invalid phase value.")
+		}
+	case args.has('S'):
+		slot, _ := args.get('S')
+		p.saveMesh(int(slot))
+	case args.has('L'):
+		slot, _ := args.get('L')
+		p.loadMesh(int(slot))
+	case args.has('T'):
+		p.reportTopography()
+	case args.has('I'):
+		p.mu.Lock()
+		p.mesh = make([][]float64, gridSize)
+		for y := range p.mesh {
+			p.mesh[y] = make([]float64, gridSize)
+			for x := range p.mesh[y] {
+				p.mesh[y][x] = math.NaN()
+			}
+		}
+		p.mu.Unlock()
+		p.println("Locations invalidated.")
+	case args.has('W'):
+		p.println("Unified Bed Leveling System v1.01")
+		p.println("Active Mesh Slot %d", p.activeSlot)
+		p.println("EEPROM can hold %d meshes.", slots)
+	default:
+		p.println("echo:?(P)hase, (S)ave, (L)oad or (T)opography required.")
+	}
+}
+
+func (p *Printer) probeMesh() {
+	if !p.checkHomed() {
+		return
+	}
+	p.println("Mesh invalidated. Probing mesh.")
+	mesh := make([][]float64, gridSize)
+	total := gridSize * gridSize
+	for y := 0; y < gridSize; y++ {
+		mesh[y] = make([]float64, gridSize)
+		for x := 0; x < gridSize; x++ {
+			p.println("Probing mesh point %d/%d.", y*gridSize+x+1, total)
+			p.sleep(700 * time.Millisecond)
+			mesh[y][x] = p.bedHeight(gridPos(x, gridSize), gridPos(y, gridSize))
+		}
+	}
+	p.mu.Lock()
+	p.mesh = mesh
+	p.levelingActive = false
+	p.mu.Unlock()
+	p.println("Mesh probing done.")
+}
+
+func (p *Printer) checkHomed() bool {
+	p.mu.Lock()
+	homed := p.homed == [3]bool{true, true, true}
+	p.mu.Unlock()
+	if !homed {
+		p.println("echo:Home XYZ first")
+	}
+	return homed
+}
+
+// g29Bilinear probes the whole grid in one go, like bilinear ABL.
+func (p *Printer) g29Bilinear() {
+	if !p.Probe {
+		p.println("Error:No probe configured.")
+		return
+	}
+	if !p.checkHomed() {
+		return
+	}
+	mesh := make([][]float64, gridSize)
+	for y := 0; y < gridSize; y++ {
+		mesh[y] = make([]float64, gridSize)
+		for x := 0; x < gridSize; x++ {
+			p.sleep(700 * time.Millisecond)
+			mesh[y][x] = p.bedHeight(gridPos(x, gridSize), gridPos(y, gridSize))
+			p.println("Bed X: %.3f Y: %.3f Z: %.3f", gridPos(x, gridSize), gridPos(y, gridSize), mesh[y][x])
+		}
+	}
+	p.mu.Lock()
+	p.mesh = mesh
+	p.levelingActive = true
+	p.mu.Unlock()
+	p.reportBilinear()
+}
+
+// g29Manual implements Mesh Bed Leveling's step-through: S1 starts at the
+// first point, each S2 stores the current Z and moves to the next one.
+func (p *Printer) g29Manual(args args) {
+	state, _ := args.get('S')
+	switch int(state) {
+	case 0:
+		p.reportManual()
+	case 1:
+		p.home(nil)
+		p.mu.Lock()
+		p.manualIndex = 0
+		p.manualMesh = make([][]float64, manualGridSize)
+		for y := range p.manualMesh {
+			p.manualMesh[y] = make([]float64, manualGridSize)
+		}
+		p.mu.Unlock()
+		p.nextManualPoint()
+	case 2:
+		p.mu.Lock()
+		idx := p.manualIndex
+		if idx < 1 {
+			p.mu.Unlock()
+			p.println("echo:Start mesh probing with \"G29 S1\" first.")
+			return
+		}
+		x, y := (idx-1)%manualGridSize, (idx-1)/manualGridSize
+		p.manualMesh[y][x] = math.Round(p.pos[2]*1000) / 1000
+		p.mu.Unlock()
+		p.nextManualPoint()
+	default:
+		p.println("echo:S out of range (0-5).")
+	}
+}
+
+func (p *Printer) nextManualPoint() {
+	p.mu.Lock()
+	total := manualGridSize * manualGridSize
+	if p.manualIndex >= total {
+		p.mesh = p.manualMesh
+		p.levelingActive = true
+		p.manualIndex = -1
+		p.mu.Unlock()
+		p.println("echo:Mesh probing done.")
+		return
+	}
+	p.manualIndex++
+	idx := p.manualIndex
+	x, y := (idx-1)%manualGridSize, (idx-1)/manualGridSize
+	p.pos[0], p.pos[1] = gridPos(x, manualGridSize), gridPos(y, manualGridSize)
+	// MANUAL_PROBE_START_Z
+	p.pos[2] = 0.2
+	p.mu.Unlock()
+	p.println("echo:MBL G29 point %d of %d", idx, total)
+}
+
+func (p *Printer) saveMesh(slot int) {
+	if slot < 0 || slot >= slots {
+		p.println("?Invalid slot.")
+		return
+	}
+	p.mu.Lock()
+	mesh := p.mesh
+	if mesh != nil {
+		p.eeprom.slots[slot] = cloneMesh(mesh)
+	}
+	p.mu.Unlock()
+	if mesh == nil {
+		p.println("?No mesh to save.")
+		return
+	}
+	p.println("Mesh saved in slot %d.", slot)
+}
+
+func (p *Printer) loadMesh(slot int) {
+	p.mu.Lock()
+	mesh, ok := p.eeprom.slots[slot]
+	if ok {
+		p.mesh = cloneMesh(mesh)
+		p.activeSlot = slot
+	}
+	p.mu.Unlock()
+	if !ok {
+		p.println("?Invalid slot.")
+		return
+	}
+	p.println("Mesh loaded from slot %d.", slot)
+	p.println("Done.")
+}
+
+func (p *Printer) m420(args args) {
+	p.mu.Lock()
+	if s, ok := args.get('S'); ok {
+		p.levelingActive = s != 0 && p.mesh != nil
+	}
+	active := p.levelingActive
+	p.mu.Unlock()
+	if args.has('V') {
+		switch p.Leveling {
+		case Bilinear:
+			p.reportBilinear()
+		case Manual:
+			p.reportManual()
+		default:
+			p.reportTopography()
+		}
+	}
+	if active {
+		p.println("echo:Bed Leveling ON")
+	} else {
+		p.println("echo:Bed Leveling OFF")
+	}
+}
+
+// reportTopography prints the mesh the way UBL's G29 T does, back row first.
+func (p *Printer) reportTopography() {
+	p.mu.Lock()
+	mesh := cloneMesh(p.mesh)
+	p.mu.Unlock()
+	if mesh == nil {
+		p.println("echo:Mesh not valid.")
+		return
+	}
+	p.println("")
+	p.println("Bed Topography Report:")
+	p.println("")
+	p.println("(%3.0f,%3.0f)%s(%3.0f,%3.0f)", 0.0, bedSize, strings.Repeat(" ", gridSize*8-10), bedSize, bedSize)
+	var header strings.Builder
+	header.WriteString("    ")
+	for x := 0; x < gridSize; x++ {
+		fmt.Fprintf(&header, "%8d", x)
+	}
+	p.println("%s", header.String())
+	for y := gridSize - 1; y >= 0; y-- {
+		var row strings.Builder
+		fmt.Fprintf(&row, "%2d |", y)
+		for x := 0; x < gridSize; x++ {
+			if math.IsNaN(mesh[y][x]) {
+				row.WriteString("    .   ")
+				continue
+			}
+			fmt.Fprintf(&row, " %+.3f ", mesh[y][x])
+		}
+		p.println("%s", row.String())
+	}
+	p.println("(%3.0f,%3.0f)%s(%3.0f,%3.0f)", 0.0, 0.0, strings.Repeat(" ", gridSize*8-10), bedSize, 0.0)
+	p.println("")
+}
+
+// m48 probes one spot repeatedly and reports statistics like Marlin's
+// probe repeatability test at verbosity 4.
+func (p *Printer) m48(args args) {
+	if !p.Probe {
+		p.println("echo:Unknown command: \"M48\"")
+		return
+	}
+	if !p.checkHomed() {
+		return
+	}
+	n := 10
+	if v, ok := args.get('P'); ok {
+		n = int(v)
+	}
+	if n < 4 || n > 50 {
+		p.println("?Sample size not plausible (4-50).")
+		return
+	}
+	x, y := bedSize/2, bedSize/2
+	if v, ok := args.get('X'); ok {
+		x = v
+	}
+	if v, ok := args.get('Y'); ok {
+		y = v
+	}
+	p.println("M48 Z-Probe Repeatability Test")
+	p.println("Positioning the probe...")
+	var samples []float64
+	mean, sigma, lo, hi := 0.0, 0.0, math.Inf(1), math.Inf(-1)
+	for i := 0; i < n; i++ {
+		p.sleep(300 * time.Millisecond)
+		z := p.bedHeight(x, y)
+		samples = append(samples, z)
+		lo, hi = math.Min(lo, z), math.Max(hi, z)
+		sum := 0.0
+		for _, s := range samples {
+			sum += s
+		}
+		mean = sum / float64(len(samples))
+		dev := 0.0
+		for _, s := range samples {
+			dev += (s - mean) * (s - mean)
+		}
+		sigma = math.Sqrt(dev / float64(len(samples)))
+		p.println("%d of %d: z: %.3f Mean: %.6f Sigma: %.6f Min: %.3f Max: %.3f Range: %.3f",
+			i+1, n, z, mean, sigma, lo, hi, hi-lo)
+	}
+	p.println("Finished!")
+	p.println("Mean: %.6f Min: %.3f Max: %.3f Range: %.3f", mean, lo, hi, hi-lo)
+	p.println("Standard Deviation: %.6f", sigma)
+	p.println("")
+}
+
+// g30 probes a single point, defaulting to the current position.
+func (p *Printer) g30(args args) {
+	if !p.Probe {
+		p.println("Error:No probe configured.")
+		return
+	}
+	if !p.checkHomed() {
+		return
+	}
+	p.mu.Lock()
+	x, y := p.pos[0], p.pos[1]
+	p.mu.Unlock()
+	if v, ok := args.get('X'); ok {
+		x = v
+	}
+	if v, ok := args.get('Y'); ok {
+		y = v
+	}
+	p.sleep(time.Second)
+	p.println("Bed X: %.2f Y: %.2f Z: %.3f", x, y, p.bedHeight(x, y))
+}
+
+// m421 sets a single mesh point by index.
+func (p *Printer) m421(args args) {
+	i, okI := args.get('I')
+	j, okJ := args.get('J')
+	z, okZ := args.get('Z')
+	if !okI || !okJ || !(okZ || args.has('N')) {
+		p.println("Error:M421 incorrect parameter usage.")
+		return
+	}
+	if args.has('N') {
+		z = math.NaN()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mesh == nil {
+		size := gridSize
+		if p.Leveling == Manual {
+			size = manualGridSize
+		}
+		p.mesh = make([][]float64, size)
+		for y := range p.mesh {
+			p.mesh[y] = make([]float64, size)
+		}
+	}
+	if int(j) < 0 || int(j) >= len(p.mesh) || int(i) < 0 || int(i) >= len(p.mesh[0]) {
+		p.println("Error:Mesh point out of range")
+		return
+	}
+	p.mesh[int(j)][int(i)] = z
+}
+
+// reportBilinear prints the grid like bilinear ABL, front row first.
+func (p *Printer) reportBilinear() {
+	p.mu.Lock()
+	mesh := cloneMesh(p.mesh)
+	p.mu.Unlock()
+	if mesh == nil {
+		p.println("echo:Invalid mesh.")
+		return
+	}
+	p.println("Bilinear Leveling Grid:")
+	p.printGrid(mesh, 6, 3)
+}
+
+func (p *Printer) reportManual() {
+	p.mu.Lock()
+	mesh := cloneMesh(p.mesh)
+	p.mu.Unlock()
+	if mesh == nil {
+		p.println("Mesh bed leveling has no data.")
+		return
+	}
+	p.println("Mesh Bed Level data:")
+	p.println("Num X,Y: %d,%d", len(mesh[0]), len(mesh))
+	p.println("Z offset: %.5f", 0.0)
+	p.println("Measured points:")
+	p.printGrid(mesh, 9, 5)
+}
+
+// printGrid writes a column header and one "row | values" line per row,
+// front row first, as Marlin's print_2d_array does.
+func (p *Printer) printGrid(mesh [][]float64, width, decimals int) {
+	var header strings.Builder
+	header.WriteString(" ")
+	for x := range mesh[0] {
+		fmt.Fprintf(&header, "%*d", width+1, x)
+	}
+	p.println("%s", header.String())
+	for y, row := range mesh {
+		var line strings.Builder
+		fmt.Fprintf(&line, "%2d", y)
+		for _, z := range row {
+			fmt.Fprintf(&line, " %+*.*f", width, decimals, z)
+		}
+		p.println("%s", line.String())
+	}
+}
+
+// bedHeight is the simulated warp of the bed: a slight tilt plus a dome.
+func (p *Printer) bedHeight(x, y float64) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bedHeightLocked(x, y)
+}
+
+func (p *Printer) bedHeightLocked(x, y float64) float64 {
+	cx, cy := x/bedSize-0.5, y/bedSize-0.5
+	z := 0.08*cx - 0.05*cy + 0.12*(0.5-(cx*cx+cy*cy)*2)
+	z += (p.rng.Float64() - 0.5) * 0.01
+	return math.Round(z*1000) / 1000
+}
+
+func gridPos(i, size int) float64 {
+	return bedSize * float64(i) / float64(size-1)
+}
+
+func cloneMesh(m [][]float64) [][]float64 {
+	if m == nil {
+		return nil
+	}
+	out := make([][]float64, len(m))
+	for i, row := range m {
+		out[i] = append([]float64(nil), row...)
+	}
+	return out
+}
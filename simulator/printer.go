@@ -0,0 +1,593 @@
+// Package simulator implements a virtual Marlin printer that speaks the same
+// line protocol as the real firmware, for running the app without hardware.
+package simulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bedSize        = 220.0
+	gridSize       = 5
+	manualGridSize = 3
+	slots          = 3
+	minExtrudeTemp = 170.0
+)
+
+// Leveling systems the simulator can be built with.
+const (
+	UBL      = "UBL"
+	Bilinear = "Bilinear"
+	Manual   = "MBL"
+)
+
+// Printer is a simulated Marlin 2.x printer. Create one with New, adjust the
+// exported fields and attach it with Serve.
+type Printer struct {
+	// TimeScale speeds up every simulated delay; 10 runs ten times faster
+	// than real time. Zero means real time.
+	TimeScale float64
+	// Leveling is UBL, Bilinear or Manual.
+	Leveling string
+	// Probe reports whether a Z probe is fitted.
+	Probe bool
+
+	mu             sync.Mutex
+	hotend         heater
+	bed            heater
+	report         time.Duration
+	posReport      time.Duration
+	pos            [4]float64
+	relative       bool
+	relativeE      bool
+	steps          [4]float64
+	homed          [3]bool
+	softEndstops   bool
+	probeOffset    [3]float64
+	mesh           [][]float64
+	manualMesh     [][]float64
+	manualIndex    int
+	levelingActive bool
+	babystep       float64
+	activeSlot     int
+	hotendPID      pid
+	bedPID         pid
+	motion         []motionSetting
+	eeprom         eeprom
+	lastLine       int
+	rng            *rand.Rand
+
+	wmu sync.Mutex
+	w   io.Writer
+}
+
+// eeprom is what M500 stores and M501 restores.
+type eeprom struct {
+	steps       [4]float64
+	probeOffset [3]float64
+	hotendPID   pid
+	bedPID      pid
+	motion      []motionSetting
+	slots       map[int][][]float64
+}
+
+// New returns a cold, unhomed printer with a probe and UBL.
+func New() *Printer {
+	p := &Printer{
+		Leveling:     UBL,
+		Probe:        true,
+		manualIndex:  -1,
+		softEndstops: true,
+		hotend:       newHeater(),
+		bed:          newHeater(),
+		steps:        [4]float64{80, 80, 400, 93},
+		probeOffset:  [3]float64{-40, -10, -1.5},
+		hotendPID:    pid{22.2, 1.08, 114},
+		bedPID:       pid{10, 0.023, 305.4},
+		motion:       defaultMotion(),
+		rng:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	p.eeprom = eeprom{
+		steps:       p.steps,
+		probeOffset: p.probeOffset,
+		hotendPID:   p.hotendPID,
+		bedPID:      p.bedPID,
+		motion:      copyMotion(p.motion),
+		slots:       make(map[int][][]float64),
+	}
+	return p
+}
+
+// Serve runs the printer on rw until it is closed, answering every line the
+// host writes.
+func (p *Printer) Serve(rw io.ReadWriteCloser) error {
+	defer rw.Close()
+	p.wmu.Lock()
+	p.w = rw
+	p.wmu.Unlock()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.tick(stop)
+
+	p.println("echo:Marlin 2.1.2 (simulated)")
+	p.println("echo: Last Updated: 2023-01-01 | Author: (simulator)")
+
+	scanner := bufio.NewScanner(rw)
+	for scanner.Scan() {
+		p.handleLine(strings.TrimSpace(scanner.Text()))
+	}
+	return scanner.Err()
+}
+
+func (p *Printer) println(format string, args ...interface{}) {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	if p.w == nil {
+		return
+	}
+	fmt.Fprintf(p.w, format+"\n", args...)
+}
+
+// sleep waits for d of simulated time.
+func (p *Printer) sleep(d time.Duration) {
+	if p.TimeScale > 0 {
+		d = time.Duration(float64(d) / p.TimeScale)
+	}
+	time.Sleep(d)
+}
+
+// tick advances the heaters and sends temperature auto-reports.
+func (p *Printer) tick(stop <-chan struct{}) {
+	const step = 100 * time.Millisecond
+	var sinceReport, sincePos time.Duration
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		p.sleep(step)
+
+		p.mu.Lock()
+		p.hotend.step(step, p.rng)
+		p.bed.step(step, p.rng)
+		report := ""
+		if p.report > 0 {
+			sinceReport += step
+			if sinceReport >= p.report {
+				sinceReport = 0
+				report = p.tempReport()
+			}
+		}
+		pos := ""
+		if p.posReport > 0 {
+			sincePos += step
+			if sincePos >= p.posReport {
+				sincePos = 0
+				pos = p.positionReport()
+			}
+		}
+		p.mu.Unlock()
+
+		if report != "" {
+			p.println(" %s", report)
+		}
+		if pos != "" {
+			p.println("%s", pos)
+		}
+	}
+}
+
+// handleLine checks line numbering and checksums the way Marlin does before
+// dispatching the command.
+func (p *Printer) handleLine(line string) {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	if line == "" {
+		return
+	}
+	if strings.HasPrefix(line, "N") {
+		star := strings.LastIndexByte(line, '*')
+		sp := strings.IndexByte(line, ' ')
+		if star < 0 || sp < 0 {
+			p.requestResend("No Checksum with line number")
+			return
+		}
+		n, err := strconv.Atoi(line[1:sp])
+		if err != nil {
+			p.requestResend("Line Number is not Last Line Number+1")
+			return
+		}
+		want, err := strconv.Atoi(line[star+1:])
+		if err != nil || checksum(line[:star]) != want {
+			p.requestResend("checksum mismatch")
+			return
+		}
+		cmd := strings.TrimSpace(line[sp+1 : star])
+		if !strings.HasPrefix(cmd, "M110") && n != p.lastLine+1 {
+			p.requestResend("Line Number is not Last Line Number+1")
+			return
+		}
+		p.lastLine = n
+		line = cmd
+	}
+	p.println("ok%s", p.execute(line))
+}
+
+func (p *Printer) requestResend(reason string) {
+	p.println("Error:%s, Last Line: %d", reason, p.lastLine)
+	p.println("Resend: %d", p.lastLine+1)
+	p.println("ok")
+}
+
+func checksum(s string) int {
+	var cs byte
+	for i := 0; i < len(s); i++ {
+		cs ^= s[i]
+	}
+	return int(cs)
+}
+
+// execute runs one command and returns anything Marlin appends to its "ok".
+func (p *Printer) execute(line string) string {
+	fields := strings.Fields(line)
+	code := strings.ToUpper(fields[0])
+	args := parseArgs(fields[1:])
+
+	switch code {
+	case "M110":
+		if n, ok := args.get('N'); ok {
+			p.lastLine = int(n)
+		}
+	case "M115":
+		p.reportFirmware()
+	case "M105":
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return " " + p.tempReport()
+	case "M155":
+		s, _ := args.get('S')
+		p.mu.Lock()
+		p.report = time.Duration(s) * time.Second
+		p.mu.Unlock()
+	case "M154":
+		s, _ := args.get('S')
+		p.mu.Lock()
+		p.posReport = time.Duration(s) * time.Second
+		p.mu.Unlock()
+	case "M104", "M109":
+		p.setTarget(&p.hotend, args, code == "M109")
+	case "M140", "M190":
+		p.setTarget(&p.bed, args, code == "M190")
+	case "M303":
+		p.m303(args)
+	case "M301":
+		p.setPID(&p.hotendPID, args)
+	case "M304":
+		p.setPID(&p.bedPID, args)
+	case "G90":
+		p.relative, p.relativeE = false, false
+	case "G91":
+		p.relative, p.relativeE = true, true
+	case "M82":
+		p.relativeE = false
+	case "M83":
+		p.relativeE = true
+	case "M92":
+		p.m92(args)
+	case "M290":
+		p.mu.Lock()
+		if z, ok := args.get('Z'); ok {
+			p.babystep += z
+		} else {
+			p.println("echo:Babystep Z%.3f", p.babystep)
+		}
+		p.mu.Unlock()
+	case "M211":
+		p.m211(args)
+	case "M400":
+		// Moves already finish before their "ok".
+	case "M84", "M108", "M410":
+		// There are no motors to release, waits to break or moves to abort.
+	case "G0", "G1":
+		p.move(args)
+	case "G28":
+		p.home(args)
+	case "M114":
+		p.mu.Lock()
+		report := p.positionReport()
+		p.mu.Unlock()
+		p.println("%s", report)
+	case "M851":
+		p.probeOffsetCmd(args)
+	case "G30":
+		p.g30(args)
+	case "M48":
+		p.m48(args)
+	case "M401", "M402":
+		// The simulated probe has no pin to move.
+		if !p.Probe {
+			p.println("echo:Unknown command: \"%s\"", line)
+		}
+	case "G29":
+		p.g29(args)
+	case "M421":
+		p.m421(args)
+	case "M420":
+		p.m420(args)
+	case "G26":
+		p.g26()
+	case "M500":
+		p.mu.Lock()
+		p.eeprom.steps = p.steps
+		p.eeprom.probeOffset = p.probeOffset
+		p.eeprom.hotendPID = p.hotendPID
+		p.eeprom.bedPID = p.bedPID
+		p.eeprom.motion = copyMotion(p.motion)
+		p.mu.Unlock()
+		p.println("echo:Settings Stored (742 bytes; crc 31877)")
+	case "M503":
+		p.reportSettings()
+	case "M501":
+		p.mu.Lock()
+		p.steps = p.eeprom.steps
+		p.probeOffset = p.eeprom.probeOffset
+		p.hotendPID = p.eeprom.hotendPID
+		p.bedPID = p.eeprom.bedPID
+		p.motion = copyMotion(p.eeprom.motion)
+		p.mu.Unlock()
+		p.println("echo:V86 stored settings retrieved (742 bytes; crc 31877)")
+	default:
+		if !p.setMotion(code, args) {
+			p.println("echo:Unknown command: \"%s\"", line)
+		}
+	}
+	return ""
+}
+
+func (p *Printer) setTarget(h *heater, args args, wait bool) {
+	s, _ := args.get('S')
+	p.mu.Lock()
+	h.target = s
+	p.mu.Unlock()
+	if !wait {
+		return
+	}
+	for {
+		p.sleep(time.Second)
+		p.mu.Lock()
+		done := h.settled()
+		report := p.tempReport()
+		p.mu.Unlock()
+		p.println(" %s W:?", report)
+		if done {
+			return
+		}
+	}
+}
+
+func (p *Printer) move(args args) {
+	p.mu.Lock()
+	if e, ok := args.get('E'); ok {
+		if p.hotend.current < minExtrudeTemp {
+			p.mu.Unlock()
+			p.println("echo: cold extrusion prevented")
+			return
+		}
+		length := e
+		if !p.relativeE {
+			length = e - p.pos[3]
+		}
+		feed := 300.0
+		if f, ok := args.get('F'); ok && f > 0 {
+			feed = f
+		}
+		p.mu.Unlock()
+		// Only extrusions take long enough to matter; travel is instant.
+		p.sleep(time.Duration(math.Abs(length) / feed * float64(time.Minute)))
+		p.mu.Lock()
+	}
+	defer p.mu.Unlock()
+	for i, axis := range []byte{'X', 'Y', 'Z', 'E'} {
+		if v, ok := args.get(axis); ok {
+			relative := p.relative
+			if axis == 'E' {
+				relative = p.relativeE
+			}
+			if relative {
+				p.pos[i] += v
+			} else {
+				p.pos[i] = v
+			}
+		}
+	}
+}
+
+func (p *Printer) m92(args args) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(args) == 0 {
+		p.println("echo: M92 X%.2f Y%.2f Z%.2f E%.2f", p.steps[0], p.steps[1], p.steps[2], p.steps[3])
+		return
+	}
+	for i, axis := range []byte{'X', 'Y', 'Z', 'E'} {
+		if v, ok := args.get(axis); ok && v > 0 {
+			p.steps[i] = v
+		}
+	}
+}
+
+// m211 only reports the soft endstop state; the simulated axes have no
+// limits to enforce.
+func (p *Printer) m211(args args) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := args.get('S'); ok {
+		p.softEndstops = s != 0
+	}
+	state := "Off"
+	if p.softEndstops {
+		state = "On"
+	}
+	p.println("echo:Soft endstops: %s  Min: X0.00 Y0.00 Z0.00  Max: X%.2f Y%.2f Z250.00", state, bedSize, bedSize)
+}
+
+// home homes the axes named in args, or all of them when none are.
+func (p *Printer) home(args args) {
+	all := !args.has('X') && !args.has('Y') && !args.has('Z')
+	p.sleep(3 * time.Second)
+	p.mu.Lock()
+	for i, axis := range []byte{'X', 'Y', 'Z'} {
+		if all || args.has(axis) {
+			p.pos[i] = 0
+			p.homed[i] = true
+		}
+	}
+	if all || args.has('Z') {
+		p.babystep = 0
+	}
+	p.mu.Unlock()
+}
+
+// positionReport is an M114 reply. The caller holds p.mu.
+func (p *Printer) positionReport() string {
+	return fmt.Sprintf("X:%.2f Y:%.2f Z:%.2f E:%.2f Count X:%d Y:%d Z:%d",
+		p.pos[0], p.pos[1], p.pos[2], p.pos[3],
+		int(p.pos[0]*p.steps[0]), int(p.pos[1]*p.steps[1]), int(p.pos[2]*p.steps[2]))
+}
+
+func (p *Printer) probeOffsetCmd(args args) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(args) == 0 {
+		p.println("echo:Probe Offset X%.2f Y%.2f Z%.2f", p.probeOffset[0], p.probeOffset[1], p.probeOffset[2])
+		return
+	}
+	for i, axis := range []byte{'X', 'Y', 'Z'} {
+		if v, ok := args.get(axis); ok {
+			p.probeOffset[i] = v
+		}
+	}
+}
+
+func (p *Printer) g26() {
+	p.mu.Lock()
+	ok := p.mesh != nil && p.levelingActive
+	p.mu.Unlock()
+	if !ok {
+		p.println("echo:?Bed Leveling must be active.")
+		return
+	}
+	p.println("echo:G26 Mesh Validation Pattern starting.")
+	p.sleep(20 * time.Second)
+	p.println("echo:G26 Mesh Validation Pattern complete.")
+}
+
+func (p *Printer) reportFirmware() {
+	p.println("FIRMWARE_NAME:Marlin 2.1.2 (simulated) SOURCE_CODE_URL:github.com/MarlinFirmware/Marlin PROTOCOL_VERSION:1.0 MACHINE_TYPE:Simulated Printer EXTRUDER_COUNT:1 UUID:cede2a2f-41a2-4748-9b12-c55c62f367ff")
+	for _, c := range []string{
+		"SERIAL_XON_XOFF:0",
+		"BINARY_FILE_TRANSFER:0",
+		"EEPROM:1",
+		"VOLUMETRIC:1",
+		"AUTOREPORT_POS:1",
+		"AUTOREPORT_TEMP:1",
+		"PROGRESS:0",
+		"PRINT_JOB:1",
+		"AUTOLEVEL:1",
+		"RUNOUT:0",
+		"Z_PROBE:" + flag(p.Probe),
+		"LEVELING_DATA:1",
+		"BUILD_PERCENT:1",
+		"SOFTWARE_POWER:0",
+		"TOGGLE_LIGHTS:0",
+		"CASE_LIGHT_BRIGHTNESS:0",
+		"EMERGENCY_PARSER:1",
+		"HOST_ACTION_COMMANDS:0",
+		"PROMPT_SUPPORT:0",
+		"SDCARD:0",
+		"AUTOREPORT_SD_STATUS:0",
+		"LONG_FILENAME:0",
+		"THERMAL_PROTECTION:1",
+		"MOTION_MODES:0",
+		"ARCS:1",
+		"BABYSTEPPING:1",
+		"CHAMBER_TEMPERATURE:0",
+		"COOLER_TEMPERATURE:0",
+		"MEATPACK:0",
+		"CONFIG_EXPORT:0",
+	} {
+		p.println("Cap:%s", c)
+	}
+}
+
+func flag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// reportSettings prints an M503 subset with Marlin's section headings.
+func (p *Printer) reportSettings() {
+	heading := map[string]string{
+		UBL:      "Unified Bed Leveling",
+		Bilinear: "Auto Bed Leveling",
+		Manual:   "Mesh Bed Leveling",
+	}[p.Leveling]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.println("echo:; Linear Units:")
+	p.println("echo:  G21 ; (mm)")
+	p.println("echo:; Steps per unit:")
+	p.println("echo:  M92 X%.2f Y%.2f Z%.2f E%.2f", p.steps[0], p.steps[1], p.steps[2], p.steps[3])
+	p.reportMotion()
+	p.println("echo:; %s:", heading)
+	p.println("echo:  M420 S%s Z10.00", flag(p.levelingActive))
+	p.println("echo:; PID settings:")
+	p.println("echo:  M301 P%.2f I%.2f D%.2f", p.hotendPID.kp, p.hotendPID.ki, p.hotendPID.kd)
+	p.println("echo:  M304 P%.2f I%.2f D%.2f", p.bedPID.kp, p.bedPID.ki, p.bedPID.kd)
+	if p.Probe {
+		p.println("echo:; Z-Probe Offset:")
+		p.println("echo:  M851 X%.2f Y%.2f Z%.2f ; (mm)", p.probeOffset[0], p.probeOffset[1], p.probeOffset[2])
+	}
+}
+
+// args holds a command's parameters by letter.
+type args map[byte]float64
+
+func parseArgs(words []string) args {
+	a := make(args)
+	for _, w := range words {
+		if w == "" {
+			continue
+		}
+		letter := strings.ToUpper(w[:1])[0]
+		v, err := strconv.ParseFloat(w[1:], 64)
+		if err != nil {
+			v = 0
+		}
+		a[letter] = v
+	}
+	return a
+}
+
+func (a args) get(letter byte) (float64, bool) {
+	v, ok := a[letter]
+	return v, ok
+}
+
+func (a args) has(letter byte) bool {
+	_, ok := a[letter]
+	return ok
+}
@@ -0,0 +1,66 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// motionSetting is an M503 line the simulator only stores and reports back,
+// such as feedrate and acceleration limits.
+type motionSetting struct {
+	heading string
+	code    string
+	letters string
+	values  []float64
+}
+
+func defaultMotion() []motionSetting {
+	return []motionSetting{
+		{"Maximum feedrates (units/s)", "M203", "XYZE", []float64{500, 500, 5, 25}},
+		{"Maximum Acceleration (units/s2)", "M201", "XYZE", []float64{500, 500, 100, 5000}},
+		{"Acceleration (units/s2): P<print_accel> R<retract_accel> T<travel_accel>", "M204", "PRT", []float64{500, 500, 1000}},
+		{"Advanced: B<min_segment_time_us> S<min_feedrate> T<min_travel_feedrate> J<junc_dev>", "M205", "BSTJ", []float64{20000, 0, 0, 0.08}},
+		{"Home offset", "M206", "XYZ", []float64{0, 0, 0}},
+		{"Linear Advance", "M900", "K", []float64{0}},
+	}
+}
+
+func copyMotion(src []motionSetting) []motionSetting {
+	dst := make([]motionSetting, len(src))
+	for i, m := range src {
+		dst[i] = m
+		dst[i].values = append([]float64(nil), m.values...)
+	}
+	return dst
+}
+
+// setMotion handles a command for one of the stored settings and reports
+// whether code was one.
+func (p *Printer) setMotion(code string, args args) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.motion {
+		if m.code != code {
+			continue
+		}
+		for i := range m.letters {
+			if v, ok := args.get(m.letters[i]); ok {
+				m.values[i] = v
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// reportMotion prints the stored settings. The caller holds p.mu.
+func (p *Printer) reportMotion() {
+	for _, m := range p.motion {
+		words := []string{m.code}
+		for i := range m.letters {
+			words = append(words, fmt.Sprintf("%c%.2f", m.letters[i], m.values[i]))
+		}
+		p.println("echo:; %s:", m.heading)
+		p.println("echo:  %s", strings.Join(words, " "))
+	}
+}
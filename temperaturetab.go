@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/andlabs/ui"
 
 	"github.com/nulldozer/printer-calibration-utility/printer"
+	"github.com/nulldozer/printer-calibration-utility/profiles"
 )
 
 type tempTab struct {
 	client     *printer.Client
+	window     *ui.Window
 	hint       *ui.Label
 	hotLabel   *ui.Label
 	bedLabel   *ui.Label
@@ -21,11 +27,45 @@ type tempTab struct {
 	stopBtn    *ui.Button
 	hotBtn     *ui.Button
 	bedBtn     *ui.Button
+	otherLabel *ui.Label
+	presetBox  *ui.Box
+	presets    []profiles.Preset
+	graph      *tempGraph
+	colours    map[string]int
 	monitoring bool
+
+	logBtn       *ui.Button
+	replayBtn    *ui.Button
+	replaySpeed  *ui.Combobox
+	logStatus    *ui.Label
+	logging      bool
+	replaying    bool
+	replayShown  bool
+	cancelReplay context.CancelFunc
+
+	pidHeater   *ui.Combobox
+	pidTemp     *ui.Entry
+	pidCycles   *ui.Spinbox
+	pidRunBtn   *ui.Button
+	pidSaveBtn  *ui.Button
+	pidProgress *ui.ProgressBar
+	pidStatus   *ui.Label
+	pidRunning  bool
+	canSave     bool
+}
+
+// replaySpeeds are the playback rates offered for recorded logs.
+var replaySpeeds = []struct {
+	label string
+	speed float64
+}{
+	{"1x", 1},
+	{"10x", 10},
+	{"60x", 60},
 }
 
-func newTempTab(client *printer.Client) *tempTab {
-	t := &tempTab{client: client}
+func newTempTab(client *printer.Client, window *ui.Window) *tempTab {
+	t := &tempTab{client: client, window: window, canSave: true, presets: profiles.New("").Preheat}
 	client.AddTempListener(t.onTempUpdate)
 	return t
 }
@@ -50,9 +90,19 @@ func (t *tempTab) Build() ui.Control {
 		_ = t.client.StopTempMonitoring()
 		t.resetLabels()
 	})
+	clearBtn := ui.NewButton("Clear Graph")
+	clearBtn.OnClicked(func(*ui.Button) {
+		if t.replaying {
+			t.cancelReplay()
+		}
+		t.replayShown = false
+		t.graph.Clear()
+	})
 	btnRow.Append(t.startBtn, false)
 	btnRow.Append(t.stopBtn, false)
+	btnRow.Append(clearBtn, false)
 	vbox.Append(btnRow, false)
+	vbox.Append(t.buildLogRow(), false)
 
 	grid := ui.NewGrid()
 	grid.SetPadded(true)
@@ -83,26 +133,372 @@ func (t *tempTab) Build() ui.Control {
 	grid.Append(t.bedEntry, 2, 1, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
 	grid.Append(t.bedBtn, 3, 1, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
 
+	t.otherLabel = ui.NewLabel("")
+	grid.Append(ui.NewLabel("Other"), 0, 2, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.otherLabel, 1, 2, 3, 1, false, ui.AlignStart, false, ui.AlignFill)
+
+	t.presetBox = ui.NewHorizontalBox()
+	grid.Append(ui.NewLabel("Preset"), 0, 3, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.presetBox, 2, 3, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+	t.presetBox.Append(t.makePresetDrop(), true)
+
 	vbox.Append(grid, false)
 
+	t.graph = newTempGraph()
+	vbox.Append(t.graph.area, true)
+
+	vbox.Append(t.buildPIDGroup(), false)
+
 	t.OnConnectionChanged(false)
 	return vbox
 }
 
-func (t *tempTab) onTempUpdate(hCurrent, hTarget, bCurrent, bTarget string) {
+func (t *tempTab) buildPIDGroup() ui.Control {
+	group := ui.NewGroup("PID Autotune")
+	group.SetMargined(true)
+	vbox := ui.NewVerticalBox()
+	vbox.SetPadded(true)
+
+	row := ui.NewHorizontalBox()
+	row.SetPadded(true)
+	t.pidHeater = ui.NewCombobox()
+	t.pidHeater.Append("Hotend")
+	t.pidHeater.Append("Bed")
+	t.pidHeater.SetSelected(0)
+	t.pidHeater.OnSelected(func(cb *ui.Combobox) {
+		if cb.Selected() == 1 {
+			t.pidTemp.SetText("60")
+		} else {
+			t.pidTemp.SetText("210")
+		}
+	})
+	t.pidTemp = ui.NewEntry()
+	t.pidTemp.SetText("210")
+	t.pidCycles = ui.NewSpinbox(3, 20)
+	t.pidCycles.SetValue(8)
+	t.pidRunBtn = ui.NewButton("Run Autotune")
+	t.pidRunBtn.OnClicked(func(*ui.Button) {
+		t.runPIDAutotune()
+	})
+	row.Append(t.pidHeater, false)
+	row.Append(ui.NewLabel("Target"), false)
+	row.Append(t.pidTemp, true)
+	row.Append(ui.NewLabel("Cycles"), false)
+	row.Append(t.pidCycles, false)
+	row.Append(t.pidRunBtn, false)
+	vbox.Append(row, false)
+
+	t.pidProgress = ui.NewProgressBar()
+	vbox.Append(t.pidProgress, false)
+
+	resultRow := ui.NewHorizontalBox()
+	resultRow.SetPadded(true)
+	t.pidStatus = ui.NewLabel("")
+	t.pidSaveBtn = ui.NewButton("Save to EEPROM")
+	t.pidSaveBtn.OnClicked(func(*ui.Button) {
+		t.savePID()
+	})
+	t.pidSaveBtn.Disable()
+	resultRow.Append(t.pidStatus, true)
+	resultRow.Append(t.pidSaveBtn, false)
+	vbox.Append(resultRow, false)
+
+	group.SetChild(vbox)
+	return group
+}
+
+func (t *tempTab) runPIDAutotune() {
+	if t.pidRunning {
+		return
+	}
+	temp, err := strconv.ParseFloat(strings.TrimSpace(t.pidTemp.Text()), 64)
+	if err != nil || temp <= 0 {
+		t.pidStatus.SetText("Enter a target temperature.")
+		return
+	}
+	heater := 0
+	if t.pidHeater.Selected() == 1 {
+		heater = printer.BedHeater
+	}
+	cycles := t.pidCycles.Value()
+
+	t.pidRunning = true
+	t.pidRunBtn.Disable()
+	t.pidSaveBtn.Disable()
+	t.pidProgress.SetValue(0)
+	t.pidStatus.SetText("Heating up...")
+
+	go func() {
+		// Bed autotunes in particular can take well over ten minutes.
+		ctx, cancel := context.WithTimeout(context.Background(), 45*time.Minute)
+		defer cancel()
+		pid, err := t.client.PIDAutotune(ctx, heater, temp, cycles, func(c printer.PIDCycle) {
+			ui.QueueMain(func() {
+				t.pidProgress.SetValue(c.Cycle * 100 / cycles)
+				t.pidStatus.SetText(fmt.Sprintf("Cycle %d of %d: %.1f to %.1f C", c.Cycle, cycles, c.Min, c.Max))
+			})
+		})
+		if err == nil {
+			err = t.client.ApplyPID(ctx, heater, pid)
+		}
+		ui.QueueMain(func() {
+			t.pidRunning = false
+			if t.client.IsConnected() {
+				t.pidRunBtn.Enable()
+			}
+			if err != nil {
+				t.pidProgress.SetValue(0)
+				t.pidStatus.SetText("Autotune failed: " + err.Error())
+				return
+			}
+			t.pidProgress.SetValue(100)
+			if !t.canSave {
+				t.pidStatus.SetText(pid.String() + " applied; firmware has no EEPROM, so it is lost on reset.")
+				return
+			}
+			t.pidStatus.SetText(pid.String() + " applied.")
+			t.pidSaveBtn.Enable()
+		})
+	}()
+}
+
+func (t *tempTab) savePID() {
+	t.pidSaveBtn.Disable()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := t.client.SaveSettings(ctx)
+		ui.QueueMain(func() {
+			if err != nil {
+				t.pidStatus.SetText("Save failed: " + err.Error())
+				t.pidSaveBtn.Enable()
+				return
+			}
+			t.pidStatus.SetText("PID settings saved to EEPROM.")
+		})
+	}()
+}
+
+func (t *tempTab) onTempUpdate(report printer.TempReport) {
 	if !t.monitoring {
 		return
 	}
 	ui.QueueMain(func() {
+		hot, _ := report.Hotend()
+		bed, _ := report.Bed()
 		if t.hotLabel != nil {
-			t.hotLabel.SetText(fmt.Sprintf("%s / %s", hCurrent, hTarget))
+			t.hotLabel.SetText(formatReading(hot))
 		}
 		if t.bedLabel != nil {
-			t.bedLabel.SetText(fmt.Sprintf("%s / %s", bCurrent, bTarget))
+			t.bedLabel.SetText(formatReading(bed))
+		}
+		var others []string
+		for _, h := range t.graphedHeaters(report) {
+			if h.ID != "T" && h.ID != "B" {
+				others = append(others, printer.HeaterName(h.ID)+" "+formatReading(h))
+			}
+		}
+		if t.otherLabel != nil {
+			t.otherLabel.SetText(strings.Join(others, "   "))
+		}
+		if !t.replayShown {
+			t.plot(report)
 		}
 	})
 }
 
+// plot adds report to the graph. Call on the UI thread.
+func (t *tempTab) plot(report printer.TempReport) {
+	if t.graph == nil {
+		return
+	}
+	for _, h := range t.graphedHeaters(report) {
+		name := printer.HeaterName(h.ID)
+		colour := t.colourFor(h.ID)
+		t.graph.Add(name, colour, false, report.Time, h.Current)
+		if h.HasTarget && h.ID != "P" {
+			t.graph.Add(name+" target", colour, true, report.Time, h.Target)
+		}
+	}
+	t.graph.Redraw()
+}
+
+func (t *tempTab) buildLogRow() ui.Control {
+	row := ui.NewHorizontalBox()
+	row.SetPadded(true)
+	t.logBtn = ui.NewButton("Log to CSV...")
+	t.logBtn.OnClicked(func(*ui.Button) {
+		t.toggleLog()
+	})
+	t.replayBtn = ui.NewButton("Replay CSV...")
+	t.replayBtn.OnClicked(func(*ui.Button) {
+		t.toggleReplay()
+	})
+	t.replaySpeed = ui.NewCombobox()
+	for _, s := range replaySpeeds {
+		t.replaySpeed.Append(s.label)
+	}
+	t.replaySpeed.SetSelected(1)
+	t.logStatus = ui.NewLabel("")
+	row.Append(t.logBtn, false)
+	row.Append(t.replayBtn, false)
+	row.Append(t.replaySpeed, false)
+	row.Append(t.logStatus, true)
+	return row
+}
+
+func (t *tempTab) toggleLog() {
+	if t.logging {
+		t.logging = false
+		t.logBtn.SetText("Log to CSV...")
+		if prev := t.client.SetTempLog(nil); prev != nil {
+			if err := prev.Close(); err != nil {
+				t.logStatus.SetText("Log incomplete: " + err.Error())
+				return
+			}
+		}
+		t.logStatus.SetText("Logging stopped.")
+		return
+	}
+	path := ui.SaveFile(t.window)
+	if path == "" {
+		return
+	}
+	if filepath.Ext(path) == "" {
+		path += ".csv"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.logStatus.SetText("Cannot log: " + err.Error())
+		return
+	}
+	log, err := printer.NewTempLog(f)
+	if err != nil {
+		f.Close()
+		t.logStatus.SetText("Cannot log: " + err.Error())
+		return
+	}
+	t.client.SetTempLog(log)
+	t.logging = true
+	t.logBtn.SetText("Stop Logging")
+	t.logStatus.SetText("Logging to " + filepath.Base(path))
+}
+
+func (t *tempTab) toggleReplay() {
+	if t.replaying {
+		t.cancelReplay()
+		return
+	}
+	path := ui.OpenFile(t.window)
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.logStatus.SetText("Cannot replay: " + err.Error())
+		return
+	}
+	reports, err := printer.ReadTempLog(f)
+	f.Close()
+	if err != nil {
+		t.logStatus.SetText("Cannot replay: " + err.Error())
+		return
+	}
+	if len(reports) == 0 {
+		t.logStatus.SetText("Log is empty.")
+		return
+	}
+	speed := replaySpeeds[1].speed
+	if idx := t.replaySpeed.Selected(); idx >= 0 && idx < len(replaySpeeds) {
+		speed = replaySpeeds[idx].speed
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancelReplay = cancel
+	t.replaying = true
+	t.replayShown = true
+	t.replayBtn.SetText("Stop Replay")
+	t.graph.Clear()
+	t.logStatus.SetText(fmt.Sprintf("Replaying %s from %s", filepath.Base(path), reports[0].Time.Format("2006-01-02 15:04")))
+
+	go func() {
+		defer cancel()
+		for i, report := range reports {
+			if i > 0 {
+				// Cap gaps so a paused session does not stall the replay.
+				wait := time.Duration(float64(report.Time.Sub(reports[i-1].Time)) / speed)
+				if wait > time.Second {
+					wait = time.Second
+				}
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+				}
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			report := report
+			ui.QueueMain(func() {
+				if !t.replayShown {
+					return
+				}
+				t.graph.SetNow(report.Time)
+				t.plot(report)
+			})
+		}
+		ui.QueueMain(func() {
+			t.replaying = false
+			t.replayBtn.SetText("Replay CSV...")
+			if t.replayShown {
+				t.logStatus.SetText("Replay finished; clear the graph to return to live data.")
+			}
+		})
+	}()
+}
+
+// graphedHeaters drops the active hotend "T" when each extruder is also
+// reported on its own, as it duplicates one of them.
+func (t *tempTab) graphedHeaters(report printer.TempReport) []printer.HeaterReading {
+	if len(report.Extruders()) == 0 {
+		return report.Heaters
+	}
+	var out []printer.HeaterReading
+	for _, h := range report.Heaters {
+		if h.ID != "T" {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// colourFor keeps each heater on the same graph colour for the session.
+func (t *tempTab) colourFor(id string) int {
+	if c, ok := t.colours[id]; ok {
+		return c
+	}
+	if t.colours == nil {
+		t.colours = make(map[string]int)
+	}
+	c := len(t.colours)
+	t.colours[id] = c
+	return c
+}
+
+func formatReading(h printer.HeaterReading) string {
+	if h.ID == "" {
+		return "? / ?"
+	}
+	text := fmt.Sprintf("%.1f", h.Current)
+	if h.HasTarget {
+		text += fmt.Sprintf(" / %.1f", h.Target)
+	}
+	if h.HasPower {
+		text += fmt.Sprintf(" (%d%%)", h.Power*100/127)
+	}
+	return text
+}
+
 func (t *tempTab) resetLabels() {
 	ui.QueueMain(func() {
 		if t.hotLabel != nil {
@@ -111,7 +507,47 @@ func (t *tempTab) resetLabels() {
 		if t.bedLabel != nil {
 			t.bedLabel.SetText("? / ?")
 		}
+		if t.otherLabel != nil {
+			t.otherLabel.SetText("")
+		}
+	})
+}
+
+func (t *tempTab) makePresetDrop() *ui.Combobox {
+	drop := ui.NewCombobox()
+	for _, p := range t.presets {
+		drop.Append(fmt.Sprintf("%s (%.0f / %.0f)", p.Name, p.Hotend, p.Bed))
+	}
+	drop.OnSelected(func(cb *ui.Combobox) {
+		t.usePreset(cb.Selected())
 	})
+	return drop
+}
+
+// usePreset fills the temperature entries from preset i, if there is one.
+func (t *tempTab) usePreset(i int) {
+	if i >= 0 && i < len(t.presets) {
+		t.hotEntry.SetText(fmt.Sprintf("%.0f", t.presets[i].Hotend))
+		t.bedEntry.SetText(fmt.Sprintf("%.0f", t.presets[i].Bed))
+	}
+}
+
+// ApplyProfile lists the profile's preheat presets and fills the entries
+// from the first. Call on the UI thread.
+func (t *tempTab) ApplyProfile(p profiles.Profile) {
+	t.presets = p.Preheat
+	// rebuild the dropdown to replace its items
+	drop := t.makePresetDrop()
+	t.presetBox.Delete(0)
+	t.presetBox.Append(drop, true)
+	if len(t.presets) > 0 {
+		drop.SetSelected(0)
+		t.usePreset(0)
+	}
+}
+
+func (t *tempTab) SaveProfile(p *profiles.Profile) {
+	p.Preheat = t.presets
 }
 
 func (t *tempTab) preheatHotend() {
@@ -138,6 +574,20 @@ func (t *tempTab) preheatBed() {
 	_ = t.client.PreheatBed(temp)
 }
 
+func (t *tempTab) OnCapabilities(caps printer.Capabilities) {
+	t.canSave = caps.Supports(printer.CapEEPROM)
+	if caps.Supports(printer.CapAutoreportTemp) {
+		return
+	}
+	ui.QueueMain(func() {
+		t.startBtn.Disable()
+		t.stopBtn.Disable()
+		if t.hint != nil {
+			t.hint.SetText("Firmware has no temperature auto-report (M155); monitoring is unavailable.")
+		}
+	})
+}
+
 func (t *tempTab) OnConnectionChanged(connected bool) {
 	ui.QueueMain(func() {
 		if t.hint != nil {
@@ -147,15 +597,18 @@ func (t *tempTab) OnConnectionChanged(connected bool) {
 				t.hint.SetText("Connect first to control temperatures.")
 			}
 		}
-		for _, btn := range []*ui.Button{t.startBtn, t.stopBtn, t.hotBtn, t.bedBtn} {
+		for _, btn := range []*ui.Button{t.startBtn, t.stopBtn, t.hotBtn, t.bedBtn, t.pidRunBtn} {
 			if btn == nil {
 				continue
 			}
-			if connected {
+			if connected && !(btn == t.pidRunBtn && t.pidRunning) {
 				btn.Enable()
 			} else {
 				btn.Disable()
 			}
 		}
+		if !connected && t.pidSaveBtn != nil {
+			t.pidSaveBtn.Disable()
+		}
 	})
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/andlabs/ui"
+)
+
+// graphWindow is how much history the temperature graph shows.
+const graphWindow = 5 * time.Minute
+
+type graphPoint struct {
+	at time.Time
+	v  float64
+}
+
+type graphSeries struct {
+	label  string
+	colour int
+	dashed bool
+	points []graphPoint
+}
+
+// graphPalette holds the series colours; a heater's target shares its
+// colour and is drawn dashed.
+var graphPalette = [][3]float64{
+	{0.85, 0.15, 0.1},
+	{0.1, 0.35, 0.85},
+	{0.1, 0.6, 0.2},
+	{0.6, 0.2, 0.7},
+	{0.85, 0.55, 0},
+	{0.3, 0.3, 0.3},
+}
+
+// tempGraph is a scrolling chart of the last graphWindow of temperature
+// readings with an auto-scaled Y axis. All methods run on the UI thread.
+type tempGraph struct {
+	area   *ui.Area
+	series []*graphSeries
+	// now pins the right edge of the graph for replays; zero follows the
+	// clock.
+	now time.Time
+}
+
+func newTempGraph() *tempGraph {
+	g := &tempGraph{}
+	g.area = ui.NewArea(g)
+	return g
+}
+
+// Add records v for the series called label, creating it on first use.
+func (g *tempGraph) Add(label string, colour int, dashed bool, at time.Time, v float64) {
+	var s *graphSeries
+	for _, existing := range g.series {
+		if existing.label == label {
+			s = existing
+			break
+		}
+	}
+	if s == nil {
+		s = &graphSeries{label: label, colour: colour, dashed: dashed}
+		g.series = append(g.series, s)
+	}
+	s.points = append(s.points, graphPoint{at: at, v: v})
+	// Keep one point beyond the window so the line runs off the left edge.
+	cutoff := at.Add(-graphWindow)
+	drop := 0
+	for drop < len(s.points)-1 && s.points[drop+1].at.Before(cutoff) {
+		drop++
+	}
+	s.points = s.points[drop:]
+}
+
+func (g *tempGraph) Redraw() {
+	g.area.QueueRedrawAll()
+}
+
+// SetNow pins the right edge of the graph to now, for replaying a log.
+func (g *tempGraph) SetNow(now time.Time) {
+	g.now = now
+}
+
+// Clear removes all data and returns the graph to the live clock.
+func (g *tempGraph) Clear() {
+	g.series = nil
+	g.now = time.Time{}
+	g.area.QueueRedrawAll()
+}
+
+func (g *tempGraph) Draw(a *ui.Area, dp *ui.AreaDrawParams) {
+	const (
+		left   = 44.0
+		right  = 8.0
+		top    = 20.0
+		bottom = 18.0
+	)
+	fillRect(dp, 0, 0, dp.AreaWidth, dp.AreaHeight, 1, 1, 1)
+	plotW := dp.AreaWidth - left - right
+	plotH := dp.AreaHeight - top - bottom
+	if plotW <= 0 || plotH <= 0 {
+		return
+	}
+
+	now := g.now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range g.series {
+		for _, p := range s.points {
+			lo, hi = math.Min(lo, p.v), math.Max(hi, p.v)
+		}
+	}
+	if math.IsInf(lo, 0) {
+		drawText(dp, "No temperature data yet", left, top+plotH/2-7, plotW)
+		return
+	}
+	step := niceStep((hi - lo) / 5)
+	lo = math.Floor(lo/step) * step
+	hi = math.Ceil(hi/step) * step
+	if hi <= lo {
+		lo, hi = lo-step, hi+step
+	}
+	xOf := func(at time.Time) float64 {
+		return left + plotW*(1-now.Sub(at).Seconds()/graphWindow.Seconds())
+	}
+	yOf := func(v float64) float64 {
+		return top + plotH*(1-(v-lo)/(hi-lo))
+	}
+
+	grid := &ui.DrawBrush{Type: ui.DrawBrushTypeSolid, R: 0.85, G: 0.85, B: 0.85, A: 1}
+	for v := lo; v <= hi+step/2; v += step {
+		y := yOf(v)
+		strokeLine(dp, grid, false, left, y, left+plotW, y)
+		drawText(dp, fmt.Sprintf("%.0f", v), 0, y-7, left-4)
+	}
+	for m := 0; m <= int(graphWindow/time.Minute); m++ {
+		x := xOf(now.Add(-time.Duration(m) * time.Minute))
+		strokeLine(dp, grid, false, x, top, x, top+plotH)
+		label := "now"
+		if m > 0 {
+			label = fmt.Sprintf("-%dm", m)
+		}
+		drawText(dp, label, x-20, top+plotH+2, 40)
+	}
+
+	// Lines may start left of the plot; clip them to it.
+	dp.Context.Save()
+	clip := ui.DrawNewPath(ui.DrawFillModeWinding)
+	clip.AddRectangle(left, top, plotW, plotH)
+	clip.End()
+	dp.Context.Clip(clip)
+	clip.Free()
+	for _, s := range g.series {
+		if len(s.points) < 2 {
+			continue
+		}
+		c := graphPalette[s.colour%len(graphPalette)]
+		brush := &ui.DrawBrush{Type: ui.DrawBrushTypeSolid, R: c[0], G: c[1], B: c[2], A: 1}
+		path := ui.DrawNewPath(ui.DrawFillModeWinding)
+		path.NewFigure(xOf(s.points[0].at), yOf(s.points[0].v))
+		for _, p := range s.points[1:] {
+			path.LineTo(xOf(p.at), yOf(p.v))
+		}
+		path.End()
+		dp.Context.Stroke(path, brush, strokeParams(s.dashed))
+		path.Free()
+	}
+	dp.Context.Restore()
+
+	// Legend across the top.
+	x := left
+	for _, s := range g.series {
+		c := graphPalette[s.colour%len(graphPalette)]
+		brush := &ui.DrawBrush{Type: ui.DrawBrushTypeSolid, R: c[0], G: c[1], B: c[2], A: 1}
+		strokeLine(dp, brush, s.dashed, x, top/2, x+16, top/2)
+		drawText(dp, s.label, x+18, top/2-7, 80)
+		x += 100
+	}
+}
+
+// niceStep rounds raw up to 1, 2 or 5 times a power of ten, at least 1.
+func niceStep(raw float64) float64 {
+	if raw <= 1 {
+		return 1
+	}
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+func strokeParams(dashed bool) *ui.DrawStrokeParams {
+	sp := &ui.DrawStrokeParams{
+		Cap:        ui.DrawLineCapFlat,
+		Join:       ui.DrawLineJoinRound,
+		Thickness:  1.5,
+		MiterLimit: ui.DrawDefaultMiterLimit,
+	}
+	if dashed {
+		sp.Dashes = []float64{4, 3}
+	}
+	return sp
+}
+
+func strokeLine(dp *ui.AreaDrawParams, brush *ui.DrawBrush, dashed bool, x1, y1, x2, y2 float64) {
+	path := ui.DrawNewPath(ui.DrawFillModeWinding)
+	path.NewFigure(x1, y1)
+	path.LineTo(x2, y2)
+	path.End()
+	dp.Context.Stroke(path, brush, strokeParams(dashed))
+	path.Free()
+}
+
+func (g *tempGraph) MouseEvent(a *ui.Area, me *ui.AreaMouseEvent) {}
+
+func (g *tempGraph) MouseCrossed(a *ui.Area, left bool) {}
+
+func (g *tempGraph) DragBroken(a *ui.Area) {}
+
+func (g *tempGraph) KeyEvent(a *ui.Area, ke *ui.AreaKeyEvent) bool {
+	return false
+}
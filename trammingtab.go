@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andlabs/ui"
+
+	"github.com/nulldozer/printer-calibration-utility/printer"
+	"github.com/nulldozer/printer-calibration-utility/profiles"
+)
+
+var screwPitches = []struct {
+	label string
+	pitch float64
+}{
+	{"M3 (0.5 mm)", printer.PitchM3},
+	{"M4 (0.7 mm)", printer.PitchM4},
+	{"M5 (0.8 mm)", printer.PitchM5},
+}
+
+const defaultScrews = `Front Left, 30, 30
+Front Right, 190, 30
+Back Right, 190, 190
+Back Left, 30, 190`
+
+type trammingTab struct {
+	client      *printer.Client
+	hint        *ui.Label
+	screwsEntry *ui.MultilineEntry
+	pitchDrop   *ui.Combobox
+	cwRaises    *ui.Checkbox
+	tolEntry    *ui.Entry
+	bedWEntry   *ui.Entry
+	bedDEntry   *ui.Entry
+	meshBtn     *ui.Button
+	loopBtn     *ui.Button
+	againBtn    *ui.Button
+	stopBtn     *ui.Button
+	status      *ui.Label
+	results     *ui.MultilineEntry
+	active      bool
+	cancel      context.CancelFunc
+	againCh     chan struct{}
+}
+
+func newTrammingTab(client *printer.Client) *trammingTab {
+	return &trammingTab{client: client}
+}
+
+func (t *trammingTab) Build() ui.Control {
+	vbox := ui.NewVerticalBox()
+	vbox.SetPadded(true)
+
+	t.hint = ui.NewLabel("")
+	vbox.Append(t.hint, false)
+
+	setup := ui.NewGroup("Bed Screws")
+	setup.SetMargined(true)
+	grid := ui.NewGrid()
+	grid.SetPadded(true)
+
+	t.screwsEntry = ui.NewNonWrappingMultilineEntry()
+	t.screwsEntry.SetText(defaultScrews)
+	grid.Append(ui.NewLabel("Name, X, Y per line\n(first is the reference)"), 0, 0, 1, 1, false, ui.AlignFill, false, ui.AlignStart)
+	grid.Append(t.screwsEntry, 1, 0, 3, 1, true, ui.AlignFill, true, ui.AlignFill)
+
+	t.pitchDrop = ui.NewCombobox()
+	for _, p := range screwPitches {
+		t.pitchDrop.Append(p.label)
+	}
+	t.pitchDrop.SetSelected(0)
+	grid.Append(ui.NewLabel("Thread"), 0, 1, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.pitchDrop, 1, 1, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+	t.cwRaises = ui.NewCheckbox("Clockwise raises the bed")
+	grid.Append(t.cwRaises, 2, 1, 2, 1, false, ui.AlignFill, false, ui.AlignFill)
+
+	t.tolEntry = ui.NewEntry()
+	t.tolEntry.SetText("0.02")
+	grid.Append(ui.NewLabel("Tolerance (mm)"), 0, 2, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.tolEntry, 1, 2, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+
+	t.bedWEntry = ui.NewEntry()
+	t.bedWEntry.SetText("220")
+	t.bedDEntry = ui.NewEntry()
+	t.bedDEntry.SetText("220")
+	grid.Append(ui.NewLabel("Bed W x D (mm)"), 0, 3, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.bedWEntry, 1, 3, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+	grid.Append(t.bedDEntry, 2, 3, 1, 1, true, ui.AlignFill, false, ui.AlignFill)
+
+	setup.SetChild(grid)
+	vbox.Append(setup, false)
+
+	actions := ui.NewHorizontalBox()
+	actions.SetPadded(true)
+	t.meshBtn = ui.NewButton("From Current Mesh")
+	t.meshBtn.OnClicked(func(*ui.Button) {
+		t.fromMesh()
+	})
+	t.loopBtn = ui.NewButton("Probe Screws Until Level")
+	t.loopBtn.OnClicked(func(*ui.Button) {
+		t.startLoop()
+	})
+	t.againBtn = ui.NewButton("Adjusted, Probe Again")
+	t.againBtn.OnClicked(func(*ui.Button) {
+		select {
+		case t.againCh <- struct{}{}:
+		default:
+		}
+	})
+	t.againBtn.Disable()
+	t.stopBtn = ui.NewButton("Stop")
+	t.stopBtn.OnClicked(func(*ui.Button) {
+		if t.cancel != nil {
+			t.cancel()
+		}
+	})
+	t.stopBtn.Disable()
+	for _, btn := range []*ui.Button{t.meshBtn, t.loopBtn, t.againBtn, t.stopBtn} {
+		actions.Append(btn, false)
+	}
+	vbox.Append(actions, false)
+
+	t.status = ui.NewLabel("")
+	vbox.Append(t.status, false)
+
+	t.results = ui.NewNonWrappingMultilineEntry()
+	t.results.SetReadOnly(true)
+	vbox.Append(t.results, true)
+
+	t.OnConnectionChanged(false)
+	return vbox
+}
+
+// config reads the form. Call on the UI thread.
+func (t *trammingTab) config() (printer.TrammingConfig, error) {
+	cfg := printer.TrammingConfig{ClockwiseRaises: t.cwRaises.Checked()}
+	screws, err := parseScrews(t.screwsEntry.Text())
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Screws = screws
+	bedW, errW := strconv.ParseFloat(strings.TrimSpace(t.bedWEntry.Text()), 64)
+	bedD, errD := strconv.ParseFloat(strings.TrimSpace(t.bedDEntry.Text()), 64)
+	if errW != nil || errD != nil || bedW <= 0 || bedD <= 0 {
+		return cfg, fmt.Errorf("invalid bed size")
+	}
+	for _, s := range screws {
+		if s.X < 0 || s.X > bedW || s.Y < 0 || s.Y > bedD {
+			return cfg, fmt.Errorf("screw %s is off the bed", s.Name)
+		}
+	}
+	if idx := t.pitchDrop.Selected(); idx >= 0 && idx < len(screwPitches) {
+		cfg.Pitch = screwPitches[idx].pitch
+	}
+	cfg.Tolerance, err = strconv.ParseFloat(strings.TrimSpace(t.tolEntry.Text()), 64)
+	if err != nil || cfg.Tolerance < 0 {
+		return cfg, fmt.Errorf("invalid tolerance")
+	}
+	return cfg, nil
+}
+
+func parseScrews(text string) ([]printer.Screw, error) {
+	var screws []printer.Screw
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("screw line %d: want name, x, y", n+1)
+		}
+		x, errX := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		y, errY := strconv.ParseFloat(strings.TrimSpace(parts[2]), 64)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("screw line %d: invalid position", n+1)
+		}
+		screws = append(screws, printer.Screw{Name: strings.TrimSpace(parts[0]), X: x, Y: y})
+	}
+	if len(screws) < 2 {
+		return nil, fmt.Errorf("enter at least two screws")
+	}
+	return screws, nil
+}
+
+// fromMesh derives the adjustments from the printer's current mesh without
+// probing again. Bilinear and manual mesh reports omit the area the grid
+// spans, which depends on probing margins the firmware does not report, so
+// for those the screws are probed instead.
+func (t *trammingTab) fromMesh() {
+	cfg, err := t.config()
+	if err != nil {
+		t.status.SetText(err.Error())
+		return
+	}
+	system := printer.StrategyFor(t.client.Capabilities()).System()
+	t.setStatus("Reading mesh...")
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		mesh, err := t.client.ReadMesh(ctx, system)
+		if err != nil {
+			t.setStatus("Could not read mesh: " + err.Error())
+			return
+		}
+		if !mesh.HasBounds() {
+			ui.QueueMain(func() {
+				t.results.SetText("The mesh report has no bed coordinates, so the screws are probed instead.\n")
+				t.startLoop()
+			})
+			return
+		}
+		heights := make([]float64, len(cfg.Screws))
+		for i, s := range cfg.Screws {
+			z, ok := mesh.HeightAt(s.X, s.Y)
+			if !ok {
+				t.setStatus(fmt.Sprintf("Mesh has no data near %s", s.Name))
+				return
+			}
+			heights[i] = z
+		}
+		t.showAdjustments(cfg, heights, "From mesh")
+	}()
+}
+
+// startLoop probes the screws, shows the turns and waits for the user to
+// adjust, until every screw is within tolerance.
+func (t *trammingTab) startLoop() {
+	if t.active {
+		return
+	}
+	cfg, err := t.config()
+	if err != nil {
+		t.status.SetText(err.Error())
+		return
+	}
+	t.active = true
+	t.againCh = make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.meshBtn.Disable()
+	t.loopBtn.Disable()
+	t.stopBtn.Enable()
+
+	go func() {
+		defer cancel()
+		err := t.runLoop(ctx, cfg)
+		switch {
+		case errors.Is(err, context.Canceled):
+			t.finishLoop("Tramming stopped.")
+		case err != nil:
+			t.finishLoop("Tramming failed: " + err.Error())
+		default:
+			t.finishLoop("All screws are within tolerance.")
+		}
+	}()
+}
+
+func (t *trammingTab) runLoop(ctx context.Context, cfg printer.TrammingConfig) error {
+	t.setStatus("Homing...")
+	if _, err := t.client.SendAndWait(ctx, "G28"); err != nil {
+		return err
+	}
+	for pass := 1; ; pass++ {
+		t.setStatus(fmt.Sprintf("Probing screws (pass %d)...", pass))
+		heights, err := t.client.ProbeScrews(ctx, cfg.Screws)
+		if err != nil {
+			return err
+		}
+		if t.showAdjustments(cfg, heights, fmt.Sprintf("Pass %d", pass)) {
+			return nil
+		}
+		t.setStatus("Turn the screws as shown, then click \"Adjusted, Probe Again\".")
+		t.enableAgain(true)
+		select {
+		case <-t.againCh:
+			t.enableAgain(false)
+		case <-ctx.Done():
+			t.enableAgain(false)
+			return ctx.Err()
+		}
+	}
+}
+
+// showAdjustments prints the instructions and reports whether the bed is
+// level.
+func (t *trammingTab) showAdjustments(cfg printer.TrammingConfig, heights []float64, title string) bool {
+	adj, err := cfg.Adjustments(heights)
+	if err != nil {
+		t.setStatus(err.Error())
+		return false
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s, relative to %s:\n", title, cfg.Screws[0].Name)
+	for _, a := range adj[1:] {
+		b.WriteString(a.Instruction(cfg.Tolerance) + "\n")
+	}
+	level := cfg.Level(adj)
+	text := b.String()
+	ui.QueueMain(func() {
+		t.results.SetText(text)
+		if level {
+			t.status.SetText("All screws are within tolerance.")
+		} else if !t.active {
+			t.status.SetText("Turn the screws as shown.")
+		}
+	})
+	return level
+}
+
+func (t *trammingTab) finishLoop(msg string) {
+	t.active = false
+	t.cancel = nil
+	ui.QueueMain(func() {
+		t.status.SetText(msg)
+		t.stopBtn.Disable()
+		t.againBtn.Disable()
+		if t.client.IsConnected() {
+			t.meshBtn.Enable()
+			t.loopBtn.Enable()
+		}
+	})
+}
+
+func (t *trammingTab) enableAgain(enable bool) {
+	ui.QueueMain(func() {
+		if enable {
+			t.againBtn.Enable()
+		} else {
+			t.againBtn.Disable()
+		}
+	})
+}
+
+// ApplyProfile fills in the profile's bed size. Call on the UI
+// thread.
+func (t *trammingTab) ApplyProfile(p profiles.Profile) {
+	t.bedWEntry.SetText(strconv.FormatFloat(p.BedX, 'f', -1, 64))
+	t.bedDEntry.SetText(strconv.FormatFloat(p.BedY, 'f', -1, 64))
+}
+
+func (t *trammingTab) SaveProfile(p *profiles.Profile) {
+	if w, err := strconv.ParseFloat(strings.TrimSpace(t.bedWEntry.Text()), 64); err == nil {
+		p.BedX = w
+	}
+	if d, err := strconv.ParseFloat(strings.TrimSpace(t.bedDEntry.Text()), 64); err == nil {
+		p.BedY = d
+	}
+}
+
+func (t *trammingTab) setStatus(text string) {
+	ui.QueueMain(func() {
+		t.status.SetText(text)
+	})
+}
+
+func (t *trammingTab) OnCapabilities(caps printer.Capabilities) {
+	if caps.Supports(printer.CapZProbe) {
+		return
+	}
+	ui.QueueMain(func() {
+		t.loopBtn.Disable()
+		t.hint.SetText("Firmware reports no Z probe; screws can only be derived from an existing mesh.")
+	})
+}
+
+func (t *trammingTab) OnConnectionChanged(connected bool) {
+	if !connected && t.cancel != nil {
+		t.cancel()
+	}
+	ui.QueueMain(func() {
+		if connected {
+			t.hint.SetText("")
+		} else {
+			t.hint.SetText("Connect first to tram the bed.")
+		}
+		for _, btn := range []*ui.Button{t.meshBtn, t.loopBtn} {
+			if connected && !t.active {
+				btn.Enable()
+			} else {
+				btn.Disable()
+			}
+		}
+	})
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/andlabs/ui"
 
@@ -13,17 +17,44 @@ type zOffsetTab struct {
 	hint       *ui.Label
 	resetBtn   *ui.Button
 	applyBtn   *ui.Button
+	cancelBtn  *ui.Button
 	buttons    []*ui.Button
-	currentZ   float64
+	stepDrop   *ui.EditableCombobox
+	keyPad     *keyPad
+	posLabel   *ui.Label
+	oldLabel   *ui.Label
+	newLabel   *ui.Label
+	old        printer.ProbeOffset
+	touch      printer.Position
+	jogging    bool
 	stageReady bool
+	canSave    bool
+	// endstopsOff is set while stage 1 has soft endstops off, so they can
+	// be turned back on, if need be on the next connection.
+	endstopsOff bool
+
+	babyBtns  []*ui.Button
+	foldBtn   *ui.Button
+	babyLabel *ui.Label
+	babyTotal float64
+	folding   bool
+	printing  bool
+	connected bool
+	canStep   bool
 }
 
 func newZOffsetTab(client *printer.Client) *zOffsetTab {
-	return &zOffsetTab{client: client}
+	t := &zOffsetTab{client: client, canSave: true, canStep: true}
+	client.AddPrintListener(func(printing bool) {
+		ui.QueueMain(func() {
+			t.printing = printing
+			t.updateBabyButtons()
+		})
+	})
+	return t
 }
 
 func (t *zOffsetTab) Build() ui.Control {
-	t.currentZ = 0
 	t.stageReady = false
 
 	vbox := ui.NewVerticalBox()
@@ -36,13 +67,13 @@ func (t *zOffsetTab) Build() ui.Control {
 	stage1.SetMargined(true)
 	stage1Box := ui.NewVerticalBox()
 	stage1Box.SetPadded(true)
-	t.resetBtn = ui.NewButton("Reset Z Offset")
+	t.resetBtn = ui.NewButton("Home and Read Z Offset")
 	t.resetBtn.OnClicked(func(*ui.Button) {
 		go t.runStage1()
 	})
 	t.resetBtn.Disable()
 	stage1Box.Append(t.resetBtn, false)
-	stage1Box.Append(ui.NewLabel("Runs: M851 Z0, G28, G0 Z0"), false)
+	stage1Box.Append(ui.NewLabel("Runs: M851, M211 S0, G28, then moves Z to where the probe triggered"), false)
 	stage1.SetChild(stage1Box)
 	vbox.Append(stage1, false)
 
@@ -51,19 +82,44 @@ func (t *zOffsetTab) Build() ui.Control {
 	stage2.SetMargined(true)
 	stage2Box := ui.NewVerticalBox()
 	stage2Box.SetPadded(true)
-	stage2Box.Append(ui.NewLabel("Move Z until the nozzle touches the bed"), false)
+	stage2Box.Append(ui.NewLabel("Lower Z until the nozzle just drags on a sheet of paper"), false)
 
+	hbox := ui.NewHorizontalBox()
+	hbox.SetPadded(true)
+	jogBox := ui.NewVerticalBox()
+	jogBox.SetPadded(true)
+	stepRow := ui.NewHorizontalBox()
+	stepRow.SetPadded(true)
+	stepRow.Append(ui.NewLabel("Step (mm)"), false)
+	t.stepDrop = ui.NewEditableCombobox()
+	for _, s := range []string{"0.01", "0.02", "0.05", "0.1", "0.5", "1"} {
+		t.stepDrop.Append(s)
+	}
+	t.stepDrop.SetText("0.05")
+	stepRow.Append(t.stepDrop, false)
+	jogBox.Append(stepRow, false)
 	t.buttons = nil
-	deltas := []float64{1.0, 0.1, 0.05, -0.05, -0.1, -1.0}
-	for _, d := range deltas {
-		val := d
-		btn := ui.NewButton(fmt.Sprintf("%+.2f", val))
+	btnRow := ui.NewHorizontalBox()
+	btnRow.SetPadded(true)
+	for _, b := range []struct {
+		label string
+		dir   float64
+	}{{"Raise", 1}, {"Lower", -1}} {
+		dir := b.dir
+		btn := ui.NewButton(b.label)
 		btn.OnClicked(func(*ui.Button) {
-			t.adjustZ(val)
+			t.adjustZ(dir)
 		})
-		stage2Box.Append(btn, false)
+		btnRow.Append(btn, false)
 		t.buttons = append(t.buttons, btn)
 	}
+	jogBox.Append(btnRow, false)
+	t.posLabel = ui.NewLabel("")
+	jogBox.Append(t.posLabel, false)
+	hbox.Append(jogBox, false)
+	t.keyPad = newKeyPad("Click here, then jog with the Up and Down arrow keys", t.adjustZ)
+	hbox.Append(t.keyPad.area, true)
+	stage2Box.Append(hbox, false)
 
 	stage2.SetChild(stage2Box)
 	vbox.Append(stage2, false)
@@ -74,13 +130,31 @@ func (t *zOffsetTab) Build() ui.Control {
 	stage3Box := ui.NewVerticalBox()
 	stage3Box.SetPadded(true)
 	stage3Box.Append(ui.NewLabel("If the nozzle is touching the bed, click Apply"), false)
+	offsets := ui.NewGrid()
+	offsets.SetPadded(true)
+	t.oldLabel = ui.NewLabel("")
+	t.newLabel = ui.NewLabel("")
+	offsets.Append(ui.NewLabel("Current Z offset"), 0, 0, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	offsets.Append(ui.NewLabel("New Z offset"), 1, 0, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	offsets.Append(t.oldLabel, 0, 1, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	offsets.Append(t.newLabel, 1, 1, 1, 1, false, ui.AlignFill, false, ui.AlignFill)
+	stage3Box.Append(offsets, false)
 	t.applyBtn = ui.NewButton("Apply")
 	t.applyBtn.OnClicked(func(*ui.Button) {
 		go t.applyOffset()
 	})
-	stage3Box.Append(t.applyBtn, false)
+	t.cancelBtn = ui.NewButton("Cancel")
+	t.cancelBtn.OnClicked(func(*ui.Button) {
+		go t.abort("Cancelled.")
+	})
+	applyRow := ui.NewHorizontalBox()
+	applyRow.SetPadded(true)
+	applyRow.Append(t.applyBtn, false)
+	applyRow.Append(t.cancelBtn, false)
+	stage3Box.Append(applyRow, false)
 	stage3.SetChild(stage3Box)
 	vbox.Append(stage3, false)
+	vbox.Append(t.buildBabystepGroup(), false)
 
 	t.enableStage2(false)
 	t.OnConnectionChanged(false)
@@ -88,32 +162,264 @@ func (t *zOffsetTab) Build() ui.Control {
 	return vbox
 }
 
+func (t *zOffsetTab) buildBabystepGroup() ui.Control {
+	group := ui.NewGroup("Live Babystepping")
+	group.SetMargined(true)
+	box := ui.NewVerticalBox()
+	box.SetPadded(true)
+	box.Append(ui.NewLabel("Adjust Z while the First Layer test prints, then fold the total into the Z offset."), false)
+	box.Append(ui.NewLabel("Only prints sent from here take steps right away; G26 holds them until it finishes."), false)
+
+	row := ui.NewHorizontalBox()
+	row.SetPadded(true)
+	for _, d := range []float64{-0.05, -0.01, 0.01, 0.05} {
+		delta := d
+		btn := ui.NewButton(fmt.Sprintf("%+.2f", delta))
+		btn.OnClicked(func(*ui.Button) {
+			t.babystep(delta)
+		})
+		row.Append(btn, false)
+		t.babyBtns = append(t.babyBtns, btn)
+	}
+	t.babyLabel = ui.NewLabel("")
+	row.Append(t.babyLabel, true)
+	box.Append(row, false)
+
+	actions := ui.NewHorizontalBox()
+	actions.SetPadded(true)
+	t.foldBtn = ui.NewButton("Fold into Z Offset")
+	t.foldBtn.OnClicked(func(*ui.Button) {
+		t.foldBabysteps()
+	})
+	reset := ui.NewButton("Reset Total")
+	reset.OnClicked(func(*ui.Button) {
+		t.setBabyTotal(0)
+	})
+	actions.Append(t.foldBtn, false)
+	actions.Append(reset, false)
+	box.Append(actions, false)
+
+	group.SetChild(box)
+	t.setBabyTotal(0)
+	return group
+}
+
+// babystep counts the step once the firmware has acknowledged it.
+func (t *zOffsetTab) babystep(delta float64) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := t.client.Babystep(ctx, delta)
+		ui.QueueMain(func() {
+			if err != nil {
+				t.hint.SetText("Babystep failed: " + err.Error())
+				return
+			}
+			t.setBabyTotal(t.babyTotal + delta)
+		})
+	}()
+}
+
+// setBabyTotal shows the accumulated babysteps. Call on the UI thread.
+func (t *zOffsetTab) setBabyTotal(total float64) {
+	t.babyTotal = total
+	t.babyLabel.SetText(fmt.Sprintf("Total: %+.3f mm", total))
+	t.updateBabyButtons()
+}
+
+func (t *zOffsetTab) foldBabysteps() {
+	total := t.babyTotal
+	if total == 0 {
+		return
+	}
+	t.folding = true
+	t.updateBabyButtons()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		z, err := t.client.FoldBabysteps(ctx, total)
+		if err == nil && t.canSave {
+			err = t.client.SaveSettings(ctx)
+		}
+		ui.QueueMain(func() {
+			t.folding = false
+			if err != nil {
+				t.hint.SetText("Fold failed: " + err.Error())
+				t.updateBabyButtons()
+				return
+			}
+			if t.canSave {
+				t.hint.SetText(fmt.Sprintf("Z offset is now %.3f and saved.", z))
+			} else {
+				t.hint.SetText(fmt.Sprintf("Z offset is now %.3f; firmware has no EEPROM, so it is lost on reset.", z))
+			}
+			// keep any steps made while folding
+			t.setBabyTotal(t.babyTotal - total)
+		})
+	}()
+}
+
+func (t *zOffsetTab) updateBabyButtons() {
+	for _, b := range t.babyBtns {
+		if t.connected && t.canStep && t.printing {
+			b.Enable()
+		} else {
+			b.Disable()
+		}
+	}
+	if t.connected && !t.folding && t.babyTotal != 0 {
+		t.foldBtn.Enable()
+	} else {
+		t.foldBtn.Disable()
+	}
+}
+
 func (t *zOffsetTab) runStage1() {
 	t.enableStage2(false)
-	t.currentZ = 0
-	if err := t.client.ResetZOffset(); err != nil {
+	t.setHint("Homing...")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	old, err := t.client.ReadProbeOffset(ctx)
+	if err != nil {
+		t.setHint("Reading the Z offset failed: " + err.Error())
+		return
+	}
+	// The bed may be below Z0 under the old offset.
+	ui.QueueMain(func() {
+		t.endstopsOff = true
+	})
+	if err := t.client.SetSoftEndstops(ctx, false); err != nil {
+		t.abort("Turning off soft endstops failed: " + err.Error())
+		return
+	}
+	if err := t.client.Home(ctx, ""); err != nil {
+		t.abort("Homing failed: " + err.Error())
+		return
+	}
+	// Homed with the probe, Z -old.Z is the height it triggered at, which
+	// is clear of the bed whatever the old offset was.
+	if err := t.client.Move(ctx, false, 600, map[string]float64{"Z": -old.Z}); err != nil {
+		t.abort("Move failed: " + err.Error())
+		return
+	}
+	pos, err := t.client.ReadPosition(ctx)
+	if err != nil {
+		t.abort("Reading the position failed: " + err.Error())
 		return
 	}
+	ui.QueueMain(func() {
+		t.old = old
+		t.showOffsets(pos)
+	})
+	t.setHint("")
 	t.enableStage2(true)
 }
 
-func (t *zOffsetTab) adjustZ(delta float64) {
-	if !t.stageReady {
+// adjustZ jogs Z by one step in dir and reads back where it ended up.
+// Presses while a jog is in flight are dropped. Call on the UI thread.
+func (t *zOffsetTab) adjustZ(dir float64) {
+	if !t.stageReady || t.jogging {
+		return
+	}
+	step, err := strconv.ParseFloat(strings.TrimSpace(t.stepDrop.Text()), 64)
+	if err != nil || step <= 0 {
+		t.hint.SetText("Enter a positive step in mm.")
 		return
 	}
-	t.currentZ += delta
-	_ = t.client.MoveToZ(t.currentZ)
+	t.jogging = true
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := t.client.Jog(ctx, "Z", dir*step, 600)
+		var pos printer.Position
+		if err == nil {
+			pos, err = t.client.ReadPosition(ctx)
+		}
+		ui.QueueMain(func() {
+			t.jogging = false
+			if err != nil {
+				t.hint.SetText("Jog failed: " + err.Error())
+				return
+			}
+			t.showOffsets(pos)
+		})
+	}()
+}
+
+// showOffsets shows the old offset next to the one the nozzle's position
+// implies. Call on the UI thread.
+func (t *zOffsetTab) showOffsets(pos printer.Position) {
+	t.touch = pos
+	t.posLabel.SetText(fmt.Sprintf("Nozzle at Z %.3f", pos.Z))
+	t.oldLabel.SetText(fmt.Sprintf("%.3f", t.old.Z))
+	t.newLabel.SetText(fmt.Sprintf("%.3f", printer.ZOffsetFromTouch(t.old, pos.Z)))
 }
 
 func (t *zOffsetTab) applyOffset() {
 	if !t.stageReady {
 		return
 	}
-	if err := t.client.ApplyZOffset(t.currentZ); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// Read Z again rather than trusting the last jog's reading.
+	pos, err := t.client.ReadPosition(ctx)
+	if err != nil {
+		t.abort("Reading the position failed: " + err.Error())
 		return
 	}
-	_ = t.client.SaveSettings()
+	old := t.old
+	z := printer.ZOffsetFromTouch(old, pos.Z)
+	if err := t.client.ApplyZOffset(ctx, z); err != nil {
+		t.abort("Apply failed: " + err.Error())
+		return
+	}
+	if err := t.restoreSoftEndstops(); err != nil {
+		t.enableStage2(false)
+		t.setHint("Turning soft endstops back on failed: " + err.Error())
+		return
+	}
+	if !t.canSave {
+		t.setHint(fmt.Sprintf("Z offset changed from %.3f to %.3f; firmware has no EEPROM, so it is lost on reset.", old.Z, z))
+		t.enableStage2(false)
+		return
+	}
+	t.enableStage2(false)
+	if err := t.client.SaveSettings(ctx); err != nil {
+		t.setHint("Save failed: " + err.Error())
+		return
+	}
+	t.setHint(fmt.Sprintf("Z offset changed from %.3f to %.3f and saved.", old.Z, z))
+}
+
+// abort ends the procedure with msg, turning soft endstops back on.
+func (t *zOffsetTab) abort(msg string) {
 	t.enableStage2(false)
+	if err := t.restoreSoftEndstops(); err != nil {
+		msg += " Turning soft endstops back on also failed: " + err.Error()
+	}
+	t.setHint(msg)
+}
+
+// restoreSoftEndstops sends M211 S1. Until it succeeds it is retried on
+// each new connection.
+func (t *zOffsetTab) restoreSoftEndstops() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := t.client.SetSoftEndstops(ctx, true); err != nil {
+		return err
+	}
+	ui.QueueMain(func() {
+		t.endstopsOff = false
+	})
+	return nil
+}
+
+func (t *zOffsetTab) setHint(text string) {
+	ui.QueueMain(func() {
+		if t.hint != nil {
+			t.hint.SetText(text)
+		}
+	})
 }
 
 func (t *zOffsetTab) enableStage2(enable bool) {
@@ -126,18 +432,49 @@ func (t *zOffsetTab) enableStage2(enable bool) {
 				b.Disable()
 			}
 		}
-		if t.applyBtn != nil {
+		for _, b := range []*ui.Button{t.applyBtn, t.cancelBtn} {
+			if b == nil {
+				continue
+			}
 			if enable {
-				t.applyBtn.Enable()
+				b.Enable()
 			} else {
-				t.applyBtn.Disable()
+				b.Disable()
 			}
 		}
+		if t.keyPad != nil {
+			t.keyPad.SetEnabled(enable)
+		}
+	})
+}
+
+func (t *zOffsetTab) OnCapabilities(caps printer.Capabilities) {
+	t.canSave = caps.Supports(printer.CapEEPROM)
+	ui.QueueMain(func() {
+		t.canStep = caps.Supports(printer.CapBabystepping)
+		if !t.canStep {
+			t.babyLabel.SetText("Firmware has no babystepping (M290).")
+		}
+		t.updateBabyButtons()
 	})
 }
 
 func (t *zOffsetTab) OnConnectionChanged(connected bool) {
 	ui.QueueMain(func() {
+		t.connected = connected
+		t.canStep = true
+		if !connected {
+			t.enableStage2(false)
+		} else if t.endstopsOff {
+			// Left off by a procedure the last connection cut short.
+			go func() {
+				if err := t.restoreSoftEndstops(); err != nil {
+					t.setHint("Turning soft endstops back on failed: " + err.Error())
+				}
+			}()
+		}
+		// babysteps only mean something for the session they were made in
+		t.setBabyTotal(0)
 		if t.resetBtn != nil {
 			if connected {
 				t.resetBtn.Enable()